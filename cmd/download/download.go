@@ -1,27 +1,124 @@
 package download
 
 import (
-	"time"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"syscall"
 
+	"github.com/movsb/torrent/pkg/daemon/picker"
 	"github.com/movsb/torrent/pkg/daemon/task"
+	"github.com/movsb/torrent/pkg/seeder"
+	trackercommon "github.com/movsb/torrent/pkg/tracker/common"
 	"github.com/spf13/cobra"
 )
 
 // AddCommands ...
 func AddCommands(root *cobra.Command) {
 	downloadCmd := &cobra.Command{
-		Use:   "download <torrent>",
-		Short: "Download the specified torrent.",
+		Use:   "download <torrent|magnet>",
+		Short: "Download the specified torrent or magnet link.",
 		Args:  cobra.ExactArgs(1),
 		RunE:  downloadTorrent,
 	}
-	downloadCmd.Flags().StringP("tracker", "t", "", "use this tracker")
+	downloadCmd.Flags().StringSliceP("tracker", "t", nil, "also announce to these trackers")
+	downloadCmd.Flags().IntP("port", "p", 6881, "the port to accept peers on, 0 not to")
+	downloadCmd.Flags().Int("upload-slots", 4, "the number of peers to upload to, besides the optimistic one")
+	downloadCmd.Flags().String("resume-dir", ".", "where to save resume files, empty to disable")
+	downloadCmd.Flags().StringP("dir", "d", ".", "where to save downloaded files")
+	downloadCmd.Flags().String("completed-dir", "", "where to move files once completed")
+	downloadCmd.Flags().Bool("no-root-folder", false, "don't create a directory for multi-file torrents")
+	downloadCmd.Flags().StringSlice("only", nil, "download only files matching these globs")
+	downloadCmd.Flags().StringSlice("exclude", nil, "don't download files matching these globs")
 	root.AddCommand(downloadCmd)
 }
 
 func downloadTorrent(cmd *cobra.Command, args []string) error {
-	tm := task.NewManager()
-	tm.CreateTask(args[0], ".", 0x00)
-	time.Sleep(time.Hour)
+	resumeDir, _ := cmd.Flags().GetString("resume-dir")
+	tm := task.NewManager(resumeDir)
+	tm.CompletedDir, _ = cmd.Flags().GetString("completed-dir")
+	tm.NoRootFolder, _ = cmd.Flags().GetBool("no-root-folder")
+
+	if port, _ := cmd.Flags().GetInt("port"); port > 0 {
+		tm.ListenPort = port
+		s := seeder.Server{
+			Address:     fmt.Sprintf(":%d", port),
+			MyPeerID:    trackercommon.MyPeerID,
+			LoadTorrent: tm,
+		}
+		go func() {
+			if err := s.Run(); err != nil {
+				log.Printf("download: accept peers: %v", err)
+			}
+		}()
+	}
+
+	if err := checkGlobs(cmd); err != nil {
+		return err
+	}
+
+	dir, _ := cmd.Flags().GetString("dir")
+	t, err := tm.CreateTask(args[0], dir, fileSelector(cmd))
+	if err != nil {
+		return err
+	}
+	if slots, _ := cmd.Flags().GetInt("upload-slots"); slots >= 0 {
+		t.SetUploadSlots(slots)
+	}
+	if trackers, _ := cmd.Flags().GetStringSlice("tracker"); len(trackers) > 0 {
+		t.AddTrackers(trackers...)
+	}
+
+	// Save the progress on exit.
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	<-quit
+
+	return tm.Close()
+}
+
+func checkGlobs(cmd *cobra.Command) error {
+	only, _ := cmd.Flags().GetStringSlice("only")
+	exclude, _ := cmd.Flags().GetStringSlice("exclude")
+	for _, glob := range append(only, exclude...) {
+		if _, err := path.Match(glob, ``); err != nil {
+			return fmt.Errorf("invalid glob: %s", glob)
+		}
+	}
 	return nil
 }
+
+// fileSelector skips files by --only and --exclude, or returns nil
+// if neither is given. A glob matches either the path of a file in
+// the torrent, or its base name.
+func fileSelector(cmd *cobra.Command) task.FileSelector {
+	only, _ := cmd.Flags().GetStringSlice("only")
+	exclude, _ := cmd.Flags().GetStringSlice("exclude")
+	if len(only) == 0 && len(exclude) == 0 {
+		return nil
+	}
+
+	match := func(globs []string, name string) bool {
+		for _, glob := range globs {
+			if ok, _ := path.Match(glob, name); ok {
+				return true
+			}
+			if ok, _ := path.Match(glob, path.Base(name)); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(name string) picker.Priority {
+		if len(only) > 0 && !match(only, name) {
+			return picker.Skip
+		}
+		if match(exclude, name) {
+			return picker.Skip
+		}
+		return picker.Normal
+	}
+}
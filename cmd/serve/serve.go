@@ -0,0 +1,71 @@
+package serve
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/movsb/torrent/pkg/daemon/gateway"
+	"github.com/movsb/torrent/pkg/daemon/task"
+	"github.com/spf13/cobra"
+)
+
+// AddCommands ...
+func AddCommands(root *cobra.Command) {
+	serveCmd := &cobra.Command{
+		Use:   "serve <torrent|magnet>...",
+		Short: "Download torrents, and serve their files over HTTP while downloading.",
+		Long: `Download torrents, and serve their files over HTTP while downloading.
+
+Files are served at /<infohash>/<path>, and pieces requested are downloaded first.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: serveTorrents,
+	}
+	serveCmd.Flags().StringP("listen", "l", "localhost:8080", "the address to listen on")
+	serveCmd.Flags().String("resume-dir", ".", "where to save resume files, empty to disable")
+	serveCmd.Flags().StringP("dir", "d", ".", "where to save downloaded files")
+	root.AddCommand(serveCmd)
+}
+
+func serveTorrents(cmd *cobra.Command, args []string) error {
+	resumeDir, _ := cmd.Flags().GetString("resume-dir")
+	tm := task.NewManager(resumeDir)
+
+	dir, _ := cmd.Flags().GetString("dir")
+	for _, arg := range args {
+		t, err := tm.CreateTask(arg, dir, nil)
+		if err != nil {
+			tm.Close()
+			return err
+		}
+		log.Printf("serve: %s", t.InfoHash)
+	}
+
+	listen, _ := cmd.Flags().GetString("listen")
+	server := &http.Server{
+		Addr:    listen,
+		Handler: &gateway.Gateway{Manager: tm},
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.ListenAndServe()
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case err := <-errCh:
+		tm.Close()
+		return err
+	case <-quit:
+	}
+
+	// Blocked reads return once the tasks are closed.
+	tm.Close()
+	return server.Shutdown(context.Background())
+}
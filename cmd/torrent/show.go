@@ -3,6 +3,7 @@ package torrent
 import (
 	"log"
 	"os"
+	"runtime"
 
 	"github.com/movsb/torrent/pkg/torrent"
 	"github.com/spf13/cobra"
@@ -65,6 +66,15 @@ func AddCommands(root *cobra.Command) {
 		Run:   createTorrent,
 	}
 	fileCmd.AddCommand(createCmd)
+
+	verifyCmd := &cobra.Command{
+		Use:   `verify <torrent> <dir>`,
+		Short: `Verify the files of a torrent in dir`,
+		Args:  cobra.ExactArgs(2),
+		RunE:  verifyFiles,
+	}
+	verifyCmd.Flags().IntP("parallel", "j", runtime.NumCPU(), "the number of pieces to read at the same time")
+	fileCmd.AddCommand(verifyCmd)
 }
 
 func fileInfo(cmd *cobra.Command, args []string) error {
@@ -74,13 +84,16 @@ func fileInfo(cmd *cobra.Command, args []string) error {
 		return err
 	}
 	yaml.NewEncoder(os.Stdout).Encode(map[string]interface{}{
-		`Name`:        tf.Name,
-		`Announce`:    tf.Announce,
-		`Length`:      tf.Length,
-		`FileCount`:   len(tf.Files),
-		`PieceLength`: tf.PieceLength,
-		`PieceCount`:  tf.PieceHashes.Count(),
-		`Single`:      tf.Single,
+		`Name`:         tf.Name,
+		`Announce`:     tf.Announce,
+		`AnnounceList`: tf.AnnounceList,
+		`WebSeeds`:     tf.WebSeeds,
+		`Length`:       tf.Length,
+		`FileCount`:    len(tf.Files),
+		`PieceLength`:  tf.PieceLength,
+		`PieceCount`:   tf.PieceHashes.Count(),
+		`Single`:       tf.Single,
+		`Private`:      tf.Private,
 	})
 	return nil
 }
@@ -0,0 +1,48 @@
+package torrent
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/movsb/torrent/pkg/daemon/store"
+	"github.com/movsb/torrent/pkg/torrent"
+	"github.com/spf13/cobra"
+)
+
+func verifyFiles(cmd *cobra.Command, args []string) error {
+	tf, err := torrent.ParseFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	parallel, _ := cmd.Flags().GetInt("parallel")
+
+	fs, err := store.NewFileStorage(tf, args[1])
+	if err != nil {
+		return err
+	}
+	pm := store.NewPieceManager(tf, fs)
+	defer pm.Close()
+
+	nPieces := tf.PieceHashes.Count()
+	bf, err := pm.Verify(cmd.Context(), parallel, func(checked, good int) {
+		fmt.Fprintf(os.Stderr, "\rverifying: %d / %d, good: %d", checked, nPieces, good)
+	})
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	bad := 0
+	for i := 0; i < nPieces; i++ {
+		if !bf.HasPiece(i) {
+			bad++
+		}
+	}
+
+	fmt.Printf("%d of %d pieces are good\n", nPieces-bad, nPieces)
+	if bad > 0 {
+		return fmt.Errorf("%d pieces are bad or missing", bad)
+	}
+	return nil
+}
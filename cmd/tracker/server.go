@@ -2,31 +2,117 @@ package tracker
 
 import (
 	"context"
+	"fmt"
+	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	trackerstore "github.com/movsb/torrent/pkg/tracker/store"
 	trackertcpserver "github.com/movsb/torrent/pkg/tracker/tcp/server"
+	trackerudpserver "github.com/movsb/torrent/pkg/tracker/udp/server"
 	"github.com/spf13/cobra"
 )
 
+// _Server is either a HTTP or a UDP tracker server.
+type _Server interface {
+	Run(ctx context.Context) error
+	Wait()
+}
+
 func runServer(cmd *cobra.Command, args []string) error {
-	s := trackertcpserver.NewServer(args[0])
+	store := trackerstore.New()
+	store.Interval, _ = cmd.Flags().GetDuration("interval")
+	store.Expiry, _ = cmd.Flags().GetInt("expiry")
+	if store.Interval < time.Second || store.Expiry < 1 {
+		return fmt.Errorf("invalid interval or expiry")
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	if err := s.Run(ctx); err != nil {
-		cancel()
+	var persister trackerstore.Persister
+	if state, _ := cmd.Flags().GetString("state"); state != `` {
+		persister = &trackerstore.FilePersister{Path: state}
+		if err := store.Load(persister); err != nil {
+			return err
+		}
+	}
+	saveInterval, _ := cmd.Flags().GetDuration("save-interval")
+	if persister != nil && saveInterval <= 0 {
+		return fmt.Errorf("invalid save interval")
+	}
+
+	servers, err := newServers(cmd, args, store)
+	if err != nil {
 		return err
 	}
 
-	quit := make(chan os.Signal)
-	signal.Notify(quit, syscall.SIGINT)
-	signal.Notify(quit, syscall.SIGKILL)
-	signal.Notify(quit, syscall.SIGTERM)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, s := range servers {
+		if err := s.Run(ctx); err != nil {
+			return err
+		}
+	}
+
+	saved := make(chan struct{})
+	go func() {
+		defer close(saved)
+		if persister != nil {
+			store.SaveEvery(ctx, persister, saveInterval)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
-	close(quit)
+	signal.Stop(quit)
 
+	log.Printf("tracker: shutting down")
 	cancel()
+	for _, s := range servers {
+		s.Wait()
+	}
+	<-saved
+
+	if persister != nil {
+		if err := store.Save(persister); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
+
+// newServers creates the servers of the endpoints sharing the store.
+func newServers(cmd *cobra.Command, endpoints []string, store *trackerstore.Store) ([]_Server, error) {
+	allowlist, _ := cmd.Flags().GetString("allowlist")
+	users, _ := cmd.Flags().GetString("users")
+
+	var servers []_Server
+	for _, endpoint := range endpoints {
+		if strings.HasPrefix(endpoint, `udp://`) {
+			// UDP requests have no passkeys, and would see peers of private torrents.
+			if allowlist != `` || users != `` {
+				return nil, fmt.Errorf("UDP servers can't be private: %s", endpoint)
+			}
+			servers = append(servers, trackerudpserver.NewServer(endpoint, store))
+			continue
+		}
+
+		s := trackertcpserver.NewServer(endpoint, store)
+		if allowlist != `` {
+			if err := s.SetAllowlist(allowlist); err != nil {
+				return nil, err
+			}
+		}
+		if users != `` {
+			if err := s.SetUsers(users); err != nil {
+				return nil, err
+			}
+		}
+		servers = append(servers, s)
+	}
+	return servers, nil
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"time"
 
+	"github.com/movsb/torrent/pkg/common"
 	"github.com/movsb/torrent/pkg/torrent"
 	trackercommon "github.com/movsb/torrent/pkg/tracker/common"
 	trackertcpclient "github.com/movsb/torrent/pkg/tracker/tcp/client"
@@ -27,15 +29,30 @@ func AddCommands(root *cobra.Command) {
 		Args: cobra.ExactArgs(2),
 		RunE: testTracker,
 	}
+	testCmd.Flags().Int("port", 6881, "the port to announce")
 	trackerCmd.AddCommand(testCmd)
 
+	scrapeCmd := &cobra.Command{
+		Use:   "scrape <tracker> <torrent>...",
+		Short: `Scrapes the stats of torrents, by files or info hashes, from a tracker.`,
+		Args:  cobra.MinimumNArgs(2),
+		RunE:  scrapeTracker,
+	}
+	trackerCmd.AddCommand(scrapeCmd)
+
 	runServerCmd := &cobra.Command{
-		Use:     `server <endpoint>`,
-		Short:   `Runs a tracker server.`,
-		Example: "server localhost:9999\nserver localhost:9999/announce",
-		Args:    cobra.ExactArgs(1),
+		Use:     `server <endpoint>...`,
+		Short:   `Runs tracker servers sharing the same peers, HTTP or UDP by the endpoints.`,
+		Example: "server localhost:9999\nserver localhost:9999/announce\nserver localhost:9999/announce udp://localhost:6969",
+		Args:    cobra.MinimumNArgs(1),
 		RunE:    runServer,
 	}
+	runServerCmd.Flags().Duration("interval", time.Minute, "the interval between announces of peers")
+	runServerCmd.Flags().Int("expiry", 3, "peers expire if they don't announce in the times of the interval")
+	runServerCmd.Flags().String("allowlist", "", "allow only the torrents in the directory, or of the info hashes in the file, reloaded once changed")
+	runServerCmd.Flags().String("users", "", "make the HTTP servers private, to users with passkeys in the file of \"<user> <passkey>\" lines, reloaded once changed")
+	runServerCmd.Flags().String("state", "", "the file the peers and the stats are saved to, and restored from on start")
+	runServerCmd.Flags().Duration("save-interval", time.Minute, "the interval between saves of the state")
 	trackerCmd.AddCommand(runServerCmd)
 }
 
@@ -52,24 +69,28 @@ func testTracker(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	req := &trackercommon.AnnounceRequest{
+		InfoHash: f.InfoHash(),
+		PeerID:   trackercommon.MyPeerID,
+		Left:     f.Length,
+		NumWant:  -1,
+	}
+	req.Port, _ = cmd.Flags().GetInt("port")
+
 	if u.Scheme == "http" || u.Scheme == "https" {
 		t := trackertcpclient.Client{
-			Address:  tracker,
-			InfoHash: f.InfoHash(),
-			MyPeerID: trackercommon.MyPeerID,
+			Address: tracker,
 		}
-		r, err := t.Announce(context.TODO())
+		r, err := t.Announce(context.TODO(), req)
 		if err != nil {
 			return err
 		}
 		yaml.NewEncoder(os.Stdout).Encode(r)
 	} else if u.Scheme == "udp" {
 		t := trackerudpclient.Client{
-			Address:  tracker,
-			InfoHash: f.InfoHash(),
-			MyPeerID: trackercommon.MyPeerID,
+			Address: tracker,
 		}
-		r, err := t.Announce(context.TODO())
+		r, err := t.Announce(context.TODO(), req)
 		if err != nil {
 			return err
 		}
@@ -80,3 +101,66 @@ func testTracker(cmd *cobra.Command, args []string) error {
 
 	return nil
 }
+
+func scrapeTracker(cmd *cobra.Command, args []string) error {
+	tracker := args[0]
+	u, err := url.Parse(tracker)
+	if err != nil {
+		return err
+	}
+
+	var (
+		names      []string
+		infoHashes []common.Hash
+	)
+	for _, arg := range args[1:] {
+		if ih, err := common.HashFromString(arg); err == nil {
+			names = append(names, ``)
+			infoHashes = append(infoHashes, ih)
+			continue
+		}
+		f, err := torrent.ParseFile(arg)
+		if err != nil {
+			return err
+		}
+		names = append(names, f.Name)
+		infoHashes = append(infoHashes, f.InfoHash())
+	}
+
+	var results []trackercommon.ScrapeResult
+	if u.Scheme == "http" || u.Scheme == "https" {
+		t := trackertcpclient.Client{
+			Address: tracker,
+		}
+		results, err = t.Scrape(context.TODO(), infoHashes)
+	} else if u.Scheme == "udp" {
+		t := trackerudpclient.Client{
+			Address: tracker,
+		}
+		results, err = t.Scrape(context.TODO(), infoHashes)
+	} else {
+		return fmt.Errorf("invalid tracker protocol")
+	}
+	if err != nil {
+		return err
+	}
+
+	type Stats struct {
+		Name       string `yaml:"name,omitempty"`
+		InfoHash   string `yaml:"info_hash"`
+		Complete   int    `yaml:"complete"`
+		Incomplete int    `yaml:"incomplete"`
+		Downloaded int    `yaml:"downloaded"`
+	}
+	stats := make([]Stats, 0, len(results))
+	for i, r := range results {
+		stats = append(stats, Stats{
+			Name:       names[i],
+			InfoHash:   r.InfoHash.String(),
+			Complete:   r.Complete,
+			Incomplete: r.Incomplete,
+			Downloaded: r.Downloaded,
+		})
+	}
+	return yaml.NewEncoder(os.Stdout).Encode(stats)
+}
@@ -1,6 +1,8 @@
 module github.com/movsb/torrent
 
-go 1.14
+// Go 1.16 is required by io.ReadSeekCloser of the streaming reader,
+// and io/fs of the torrentfs package.
+go 1.16
 
 require (
 	github.com/spf13/cobra v1.0.0
@@ -8,6 +8,7 @@ import (
 
 	"github.com/movsb/torrent/cmd/download"
 	cmdSeeder "github.com/movsb/torrent/cmd/seeder"
+	"github.com/movsb/torrent/cmd/serve"
 	"github.com/movsb/torrent/cmd/tools"
 	"github.com/movsb/torrent/cmd/torrent"
 	"github.com/movsb/torrent/cmd/tracker"
@@ -24,6 +25,7 @@ func main() {
 	download.AddCommands(rootCmd)
 	tracker.AddCommands(rootCmd)
 	cmdSeeder.AddCommands(rootCmd)
+	serve.AddCommands(rootCmd)
 	tools.AddCommands(rootCmd)
 
 	if os.Getenv("DEBUG") != "" {
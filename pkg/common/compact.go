@@ -0,0 +1,55 @@
+package common
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// Lengths of compact peers: IP followed by 2 bytes of port in network order.
+const (
+	CompactPeerLength4 = net.IPv4len + 2
+	CompactPeerLength6 = net.IPv6len + 2
+)
+
+// ParseCompactPeers parses a compact peer list into addresses of
+// the form host:port. ipLen is net.IPv4len or net.IPv6len.
+func ParseCompactPeers(b []byte, ipLen int) ([]string, error) {
+	n := ipLen + 2
+	if len(b)%n != 0 {
+		return nil, fmt.Errorf("malformed compact peers: length %d", len(b))
+	}
+	peers := make([]string, 0, len(b)/n)
+	for i := 0; i < len(b); i += n {
+		ip := make(net.IP, ipLen)
+		copy(ip, b[i:i+ipLen])
+		port := binary.BigEndian.Uint16(b[i+ipLen:])
+		peers = append(peers, net.JoinHostPort(ip.String(), strconv.Itoa(int(port))))
+	}
+	return peers, nil
+}
+
+// CompactPeer encodes an address of the form ip:port in compact format,
+// 6 bytes for IPv4 and 18 bytes for IPv6.
+func CompactPeer(address string) ([]byte, error) {
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid ip: %s", host)
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid port: %s", portString)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	b := make([]byte, len(ip)+2)
+	copy(b, ip)
+	binary.BigEndian.PutUint16(b[len(ip):], uint16(port))
+	return b, nil
+}
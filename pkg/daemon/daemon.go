@@ -11,7 +11,7 @@ type Daemon struct {
 }
 
 func main() {
-	tm := task.NewManager()
+	tm := task.NewManager(".")
 
 	//seeder := seeder.Server{
 	//	Address:     `localhost:8888`,
@@ -19,8 +19,10 @@ func main() {
 	//	LoadTorrent: tm,
 	//}
 
-	//tm.CreateTask("8ce301d28fe97eed1a6ef7feaf296411b375222f.torrent", ".", 0xFF)
-	tm.CreateTask("ubuntu.torrent", ".", 0x00)
+	//tm.CreateTask("8ce301d28fe97eed1a6ef7feaf296411b375222f.torrent", ".", nil)
+	if _, err := tm.CreateTask("ubuntu.torrent", ".", nil); err != nil {
+		panic(err)
+	}
 
 	// if err := seeder.Run(); err != nil {
 	// 	panic(err)
@@ -0,0 +1,185 @@
+package gateway
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/movsb/torrent/pkg/common"
+	"github.com/movsb/torrent/pkg/daemon/task"
+	"github.com/movsb/torrent/pkg/daemon/torrentfs"
+)
+
+// Gateway serves the files of tasks over HTTP, at /<infohash>/<path>,
+// where path is that of a file in the torrent. Files are streamed while
+// being downloaded, and pieces requested, by Range too, are downloaded
+// first. Directories are listed.
+type Gateway struct {
+	Manager *task.Manager
+}
+
+var _ http.Handler = &Gateway{}
+
+// ServeHTTP ...
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	p := path.Clean("/" + r.URL.Path)
+	if p == "/" {
+		g.listTasks(w, r)
+		return
+	}
+
+	segments := strings.SplitN(p[1:], "/", 2)
+	ih, err := common.HashFromString(strings.ToLower(segments[0]))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	t := g.Manager.Task(ih)
+	if t == nil {
+		http.NotFound(w, r)
+		return
+	}
+	fsys, err := torrentfs.New(t)
+	if err != nil {
+		http.Error(w, "metadata is not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	name := "."
+	if len(segments) > 1 {
+		name = segments[1]
+	}
+
+	info, err := fsys.Stat(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !info.IsDir() {
+		g.serveFile(w, r, t, fsys, name)
+		return
+	}
+
+	// Directories end with a slash, so that relative links work.
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		http.Redirect(w, r, path.Base(r.URL.Path)+"/", http.StatusMovedPermanently)
+		return
+	}
+	dirEntries, err := fsys.ReadDir(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	entries := make([]_Entry, 0, len(dirEntries))
+	for _, e := range dirEntries {
+		entry := _Entry{Name: e.Name(), Dir: e.IsDir()}
+		if info, err := e.Info(); err == nil && !e.IsDir() {
+			entry.Length = info.Size()
+		}
+		entries = append(entries, entry)
+	}
+	title := "/" + segments[0] + "/"
+	if name != "." {
+		title += name
+	}
+	writeList(w, r, title, entries)
+}
+
+// serveFile serves a file, with Range and If-Range supported.
+func (g *Gateway) serveFile(w http.ResponseWriter, r *http.Request, t *task.Task, fsys *torrentfs.FS, name string) {
+	index, err := fsys.FileIndex(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := fsys.Open(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	// Reads block until pieces are downloaded, and return once the
+	// client goes away.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-r.Context().Done():
+			f.Close()
+		case <-done:
+		}
+	}()
+
+	// Set the content type, or it is sniffed, which waits for the first piece.
+	ctype := mime.TypeByExtension(path.Ext(name))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", ctype)
+
+	// Files of a torrent never change, so that the info hash and
+	// the index make a strong validator, for If-Range.
+	w.Header().Set("ETag", fmt.Sprintf(`"%s-%d"`, t.InfoHash, index))
+
+	http.ServeContent(w, r, path.Base(name), time.Time{}, f.(io.ReadSeeker))
+}
+
+// listTasks lists the tasks.
+func (g *Gateway) listTasks(w http.ResponseWriter, r *http.Request) {
+	var entries []_Entry
+	for _, t := range g.Manager.Tasks() {
+		entries = append(entries, _Entry{
+			Name: t.InfoHash.String(),
+			Dir:  true,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	writeList(w, r, "/", entries)
+}
+
+// _Entry is an entry in a directory listing.
+type _Entry struct {
+	Name   string
+	Dir    bool
+	Length int64
+}
+
+// writeList writes a directory listing in HTML.
+func writeList(w http.ResponseWriter, r *http.Request, title string, entries []_Entry) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	title = html.EscapeString(title)
+	fmt.Fprintf(w, "<!doctype html>\n<title>%s</title>\n<h1>%s</h1>\n<pre>\n", title, title)
+	for _, e := range entries {
+		name := e.Name
+		if e.Dir {
+			name += "/"
+		}
+		link := url.URL{Path: name}
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>", link.String(), html.EscapeString(name))
+		if !e.Dir {
+			fmt.Fprintf(w, " %d", e.Length)
+		}
+		io.WriteString(w, "\n")
+	}
+	io.WriteString(w, "</pre>\n")
+}
@@ -0,0 +1,92 @@
+package gateway
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/movsb/torrent/pkg/daemon/task/tasktest"
+	"github.com/movsb/torrent/pkg/torrent/torrenttest"
+)
+
+func TestGateway(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, `files`)
+	a := []byte(`hello, world`)
+	b := bytes.Repeat([]byte(`0123456789`), 10000)
+	torrenttest.WriteFiles(t, root, map[string][]byte{
+		`a.txt`:     a,
+		`sub/b.bin`: b,
+	})
+	tp := filepath.Join(dir, `files.torrent`)
+	torrenttest.Create(t, root, tp)
+
+	m, tk := tasktest.NewTask(t, tp, dir, nil)
+	// Files on disk are verified first.
+	tasktest.WaitComplete(t, tk, 10*time.Second)
+
+	s := httptest.NewServer(&Gateway{Manager: m})
+	defer s.Close()
+	base := s.URL + `/` + tk.InfoHash.String()
+
+	get := func(path string, header map[string]string) (*http.Response, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, base+path, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rsp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rsp.Body.Close()
+		body, err := ioutil.ReadAll(rsp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rsp, string(body)
+	}
+
+	rsp, body := get(``, nil)
+	if rsp.StatusCode != http.StatusOK || !strings.Contains(body, `href="a.txt"`) || !strings.Contains(body, `href="sub/"`) {
+		t.Fatalf("list: %d %s", rsp.StatusCode, body)
+	}
+
+	rsp, body = get(`/sub/`, nil)
+	if rsp.StatusCode != http.StatusOK || !strings.Contains(body, `href="b.bin">b.bin</a> 100000`) {
+		t.Fatalf("list sub: %d %s", rsp.StatusCode, body)
+	}
+
+	rsp, body = get(`/a.txt`, nil)
+	if rsp.StatusCode != http.StatusOK || body != string(a) {
+		t.Fatalf("get: %d %s", rsp.StatusCode, body)
+	}
+	if ct := rsp.Header.Get(`Content-Type`); !strings.HasPrefix(ct, `text/plain`) {
+		t.Fatalf("content type: %s", ct)
+	}
+
+	rsp, body = get(`/sub/b.bin`, map[string]string{`Range`: `bytes=99990-`})
+	if rsp.StatusCode != http.StatusPartialContent || body != string(b[99990:]) {
+		t.Fatalf("range: %d %s", rsp.StatusCode, body)
+	}
+	etag := rsp.Header.Get(`ETag`)
+
+	rsp, body = get(`/sub/b.bin`, map[string]string{`Range`: `bytes=0-9`, `If-Range`: etag})
+	if rsp.StatusCode != http.StatusPartialContent || body != string(b[:10]) {
+		t.Fatalf("if-range: %d %s", rsp.StatusCode, body)
+	}
+	rsp, body = get(`/sub/b.bin`, map[string]string{`Range`: `bytes=0-9`, `If-Range`: `"other"`})
+	if rsp.StatusCode != http.StatusOK || len(body) != len(b) {
+		t.Fatalf("if-range mismatch: %d %d", rsp.StatusCode, len(body))
+	}
+
+	rsp, _ = get(`/sub/nothing`, nil)
+	if rsp.StatusCode != http.StatusNotFound {
+		t.Fatalf("not found: %d", rsp.StatusCode)
+	}
+}
@@ -0,0 +1,229 @@
+package picker
+
+import (
+	"math/rand"
+	"sync"
+
+	"github.com/movsb/torrent/pkg/message"
+)
+
+// State is the download state of a piece.
+type State int
+
+// Piece states.
+const (
+	// Missing pieces are wanted but not started yet.
+	Missing State = iota
+
+	// Partial pieces have some, but not all, blocks downloaded or requested.
+	Partial
+
+	// Busy pieces have all blocks requested, nothing more to pick.
+	Busy
+
+	// Done pieces are downloaded and verified.
+	Done
+)
+
+// Priority is the download priority of a piece.
+type Priority int
+
+// Piece priorities.
+const (
+	// Skip pieces are not wanted.
+	Skip Priority = iota
+	Low
+	Normal
+	High
+
+	// Urgent pieces are needed right now, e.g. by a streaming reader,
+	// and are picked in order instead.
+	Urgent
+)
+
+// Picker decides which piece to download next.
+type Picker interface {
+	// AddBitField adds the pieces of a newly connected peer to the availability.
+	AddBitField(bf *message.BitField)
+
+	// RemoveBitField removes the pieces of a disconnected peer from the availability.
+	RemoveBitField(bf *message.BitField)
+
+	// Have adds a piece a peer announced to the availability.
+	Have(index int)
+
+	// SetState sets the download state of a piece.
+	SetState(index int, state State)
+
+	// State returns the download state of a piece.
+	State(index int) State
+
+	// SetPriority sets the priority of a piece, which is Normal by default.
+	SetPriority(index int, priority Priority)
+
+	// Priority returns the priority of a piece.
+	Priority(index int) Priority
+
+	// Pick picks a wanted Missing or Partial piece among those has returns true for.
+	Pick(has func(index int) bool) (int, bool)
+}
+
+// RarestFirst picks the pieces that the fewest peers have first.
+// Pieces of higher priorities are picked first, and skipped pieces never.
+// Urgent pieces are picked sequentially.
+// Partial pieces are preferred to missing ones, so that they complete sooner.
+// Among equally rare pieces, one is picked randomly.
+type RarestFirst struct {
+	mu         sync.RWMutex
+	avail      []int
+	states     []State
+	priorities []Priority
+	rand       *rand.Rand
+}
+
+var _ Picker = &RarestFirst{}
+
+// NewRarestFirst ...
+func NewRarestFirst(pieceCount int, seed int64) *RarestFirst {
+	r := &RarestFirst{
+		avail:      make([]int, pieceCount),
+		states:     make([]State, pieceCount),
+		priorities: make([]Priority, pieceCount),
+		rand:       rand.New(rand.NewSource(seed)),
+	}
+	for i := range r.priorities {
+		r.priorities[i] = Normal
+	}
+	return r
+}
+
+// AddBitField ...
+func (r *RarestFirst) AddBitField(bf *message.BitField) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.avail {
+		if bf.HasPiece(i) {
+			r.avail[i]++
+		}
+	}
+}
+
+// RemoveBitField ...
+func (r *RarestFirst) RemoveBitField(bf *message.BitField) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.avail {
+		if bf.HasPiece(i) && r.avail[i] > 0 {
+			r.avail[i]--
+		}
+	}
+}
+
+// Have ...
+func (r *RarestFirst) Have(index int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.valid(index) {
+		r.avail[index]++
+	}
+}
+
+// SetState ...
+func (r *RarestFirst) SetState(index int, state State) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.valid(index) {
+		r.states[index] = state
+	}
+}
+
+// State ...
+func (r *RarestFirst) State(index int) State {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if !r.valid(index) {
+		return Done
+	}
+	return r.states[index]
+}
+
+// SetPriority ...
+func (r *RarestFirst) SetPriority(index int, priority Priority) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.valid(index) {
+		r.priorities[index] = priority
+	}
+}
+
+// Priority ...
+func (r *RarestFirst) Priority(index int) Priority {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if !r.valid(index) {
+		return Skip
+	}
+	return r.priorities[index]
+}
+
+// Availability returns the number of peers that have the piece.
+func (r *RarestFirst) Availability(index int) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if !r.valid(index) {
+		return 0
+	}
+	return r.avail[index]
+}
+
+// Pick ...
+func (r *RarestFirst) Pick(has func(index int) bool) (int, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var (
+		best         = -1
+		bestPriority Priority
+		bestState    State
+		bestAvail    int
+		ties         int
+	)
+
+	for i, state := range r.states {
+		if state != Missing && state != Partial {
+			continue
+		}
+		priority := r.priorities[i]
+		if priority == Skip {
+			continue
+		}
+		if !has(i) {
+			continue
+		}
+		if priority == Urgent && bestPriority == Urgent && best != -1 {
+			continue
+		}
+
+		avail := r.avail[i]
+		switch {
+		case best == -1,
+			priority > bestPriority,
+			priority == bestPriority && state == Partial && bestState == Missing,
+			priority == bestPriority && state == bestState && avail < bestAvail:
+			best, bestPriority, bestState, bestAvail = i, priority, state, avail
+			ties = 1
+		case priority == bestPriority && state == bestState && avail == bestAvail:
+			// Reservoir sampling among ties.
+			ties++
+			if r.rand.Intn(ties) == 0 {
+				best = i
+			}
+		}
+	}
+
+	return best, best != -1
+}
+
+func (r *RarestFirst) valid(index int) bool {
+	return index >= 0 && index < len(r.states)
+}
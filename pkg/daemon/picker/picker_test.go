@@ -0,0 +1,133 @@
+package picker
+
+import (
+	"testing"
+
+	"github.com/movsb/torrent/pkg/message"
+)
+
+func bitField(n int, pieces ...int) *message.BitField {
+	bf := message.NewBitField(n, 0)
+	for _, i := range pieces {
+		bf.SetPiece(i)
+	}
+	return bf
+}
+
+func all(int) bool { return true }
+
+func TestRarestFirst(t *testing.T) {
+	r := NewRarestFirst(4, 1)
+	r.AddBitField(bitField(4, 0, 1, 2, 3))
+	r.AddBitField(bitField(4, 0, 1, 3))
+	r.AddBitField(bitField(4, 0, 3))
+
+	// 2 is the rarest.
+	if i, ok := r.Pick(all); !ok || i != 2 {
+		t.Fatalf("want 2, got %d", i)
+	}
+
+	// 1 is the rarest among those not busy.
+	r.SetState(2, Busy)
+	if i, ok := r.Pick(all); !ok || i != 1 {
+		t.Fatalf("want 1, got %d", i)
+	}
+
+	// Partial pieces are preferred, even if not rare.
+	r.SetState(0, Partial)
+	if i, ok := r.Pick(all); !ok || i != 0 {
+		t.Fatalf("want 0, got %d", i)
+	}
+
+	// Only pieces the peer has are picked.
+	r.SetState(0, Done)
+	if i, ok := r.Pick(func(i int) bool { return i == 3 }); !ok || i != 3 {
+		t.Fatalf("want 3, got %d", i)
+	}
+
+	// A new have changes rarity.
+	r.Have(1)
+	r.Have(1)
+	if i, ok := r.Pick(all); !ok || i != 3 {
+		t.Fatalf("want 3, got %d", i)
+	}
+
+	r.SetState(1, Done)
+	r.SetState(3, Done)
+	if i, ok := r.Pick(all); ok {
+		t.Fatalf("want none, got %d", i)
+	}
+}
+
+func TestRarestFirstRandom(t *testing.T) {
+	r := NewRarestFirst(8, 1)
+	r.AddBitField(bitField(8, 0, 1, 2, 3, 4, 5, 6, 7))
+
+	picked := make(map[int]bool)
+	for i := 0; i < 100; i++ {
+		index, _ := r.Pick(all)
+		picked[index] = true
+	}
+	if len(picked) < 2 {
+		t.Fatalf("equally rare pieces should be picked randomly: %v", picked)
+	}
+
+	r.RemoveBitField(bitField(8, 0, 1, 2, 3, 4, 5, 6, 7))
+	if n := r.Availability(3); n != 0 {
+		t.Fatalf("bad availability: %d", n)
+	}
+}
+
+func TestPriorities(t *testing.T) {
+	r := NewRarestFirst(4, 1)
+	r.AddBitField(bitField(4, 0, 1, 2, 3))
+	r.AddBitField(bitField(4, 0, 1, 3))
+
+	// 2 is the rarest, but skipped.
+	r.SetPriority(2, Skip)
+	if i, ok := r.Pick(all); !ok || i == 2 {
+		t.Fatalf("skipped piece picked: %d", i)
+	}
+
+	// Higher priorities come first, even before partial pieces.
+	r.SetState(0, Partial)
+	r.SetPriority(3, High)
+	if i, ok := r.Pick(all); !ok || i != 3 {
+		t.Fatalf("want 3, got %d", i)
+	}
+
+	r.SetPriority(0, Low)
+	r.SetPriority(3, Skip)
+	if i, ok := r.Pick(all); !ok || i != 1 {
+		t.Fatalf("want 1, got %d", i)
+	}
+
+	r.SetPriority(1, Skip)
+	if i, ok := r.Pick(all); !ok || i != 0 {
+		t.Fatalf("want 0, got %d", i)
+	}
+
+	r.SetPriority(0, Skip)
+	if i, ok := r.Pick(all); ok {
+		t.Fatalf("want none, got %d", i)
+	}
+}
+
+func TestUrgent(t *testing.T) {
+	r := NewRarestFirst(4, 1)
+	r.AddBitField(bitField(4, 0, 1, 2, 3))
+	r.AddBitField(bitField(4, 1, 2, 3))
+
+	// Urgent pieces are picked in order, not by rarity.
+	r.SetPriority(2, Urgent)
+	r.SetPriority(3, Urgent)
+	r.SetState(3, Partial)
+	if i, ok := r.Pick(all); !ok || i != 2 {
+		t.Fatalf("want 2, got %d", i)
+	}
+
+	r.SetState(2, Busy)
+	if i, ok := r.Pick(all); !ok || i != 3 {
+		t.Fatalf("want 3, got %d", i)
+	}
+}
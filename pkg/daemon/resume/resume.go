@@ -0,0 +1,116 @@
+package resume
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/zeebo/bencode"
+)
+
+// File is the state of a file on disk when the resume data is saved.
+// A missing file has zero length and mtime.
+type File struct {
+	Length int64 `bencode:"length"`
+	MTime  int64 `bencode:"mtime"`
+}
+
+// Data is the progress of a task, saved across restarts.
+type Data struct {
+	InfoHash []byte `bencode:"info_hash"`
+	BitField []byte `bencode:"bitfield"`
+	Files    []File `bencode:"files"`
+
+	// Bytes uploaded to and downloaded from peers, in total.
+	Uploaded   int64 `bencode:"uploaded"`
+	Downloaded int64 `bencode:"downloaded"`
+
+	// Addresses of peers seen.
+	Peers []string `bencode:"peers,omitempty"`
+
+	// Where the files are saved, as they may have been moved.
+	SavePath string `bencode:"save_path,omitempty"`
+
+	// Priorities of files, empty if they are all normal.
+	Priorities []int `bencode:"priorities,omitempty"`
+}
+
+// Load loads resume data from path.
+func Load(path string) (*Data, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var d Data
+	if err := bencode.DecodeBytes(b, &d); err != nil {
+		return nil, fmt.Errorf("resume: decode %s: %v", path, err)
+	}
+	return &d, nil
+}
+
+// Save saves resume data to path. The file is written to a temporary
+// file first, and then renamed, so it is never partially written.
+func Save(path string, d *Data) error {
+	b, err := bencode.EncodeBytes(d)
+	if err != nil {
+		return fmt.Errorf("resume: encode: %v", err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+`.*`)
+	if err != nil {
+		return fmt.Errorf("resume: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("resume: write: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("resume: sync: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("resume: close: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("resume: rename: %v", err)
+	}
+	return nil
+}
+
+// Stat returns the states of the files on disk.
+func Stat(paths []string) []File {
+	files := make([]File, len(paths))
+	for i, path := range paths {
+		if fi, err := os.Stat(path); err == nil {
+			files[i] = File{
+				Length: fi.Size(),
+				MTime:  fi.ModTime().UnixNano(),
+			}
+		}
+	}
+	return files
+}
+
+// Validate tells why the resume data can't be trusted for the task,
+// or nil if the files on disk haven't changed since it was saved.
+func (d *Data) Validate(infoHash []byte, byteCount int, files []File) error {
+	if !bytes.Equal(d.InfoHash, infoHash) {
+		return fmt.Errorf("resume: info hash mismatch")
+	}
+	if len(d.BitField) != byteCount {
+		return fmt.Errorf("resume: bitfield size mismatch")
+	}
+	if len(d.Files) != len(files) {
+		return fmt.Errorf("resume: file count mismatch")
+	}
+	for i, f := range files {
+		if d.Files[i] != f {
+			return fmt.Errorf("resume: file %d changed", i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package resume
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	data := filepath.Join(dir, `data`)
+	if err := ioutil.WriteFile(data, []byte(`hello`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	files := Stat([]string{data, filepath.Join(dir, `missing`)})
+	if files[0].Length != 5 || files[1] != (File{}) {
+		t.Fatalf("bad stat: %+v", files)
+	}
+
+	ih := make([]byte, 20)
+	d := Data{
+		InfoHash:   ih,
+		BitField:   []byte{0xF0},
+		Files:      files,
+		Uploaded:   100,
+		Downloaded: 200,
+		Peers:      []string{`127.0.0.1:6881`},
+	}
+
+	path := filepath.Join(dir, `x.resume`)
+	if err := Save(path, &d); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*loaded, d) {
+		t.Fatalf("got %+v, want %+v", *loaded, d)
+	}
+
+	if err := loaded.Validate(ih, 1, files); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Validate(ih, 2, files); err == nil {
+		t.Fatal("bitfield size should mismatch")
+	}
+
+	if err := ioutil.WriteFile(data, []byte(`hello, world`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Validate(ih, 1, Stat([]string{data, filepath.Join(dir, `missing`)})); err == nil {
+		t.Fatal("changed file should be detected")
+	}
+}
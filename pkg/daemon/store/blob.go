@@ -0,0 +1,109 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/movsb/torrent/pkg/torrent"
+)
+
+// BlobStorage stores all the files in a single file, one after another
+// in the order of the torrent, as if the torrent were a single file.
+type BlobStorage struct {
+	path    string
+	offsets []int64
+	lengths []int64
+
+	mu   sync.Mutex
+	blob *os.File
+}
+
+var _ Storage = &BlobStorage{}
+
+// NewBlobStorage ...
+func NewBlobStorage(f *torrent.File, path string) *BlobStorage {
+	s := &BlobStorage{
+		path:    path,
+		offsets: make([]int64, len(f.Files)),
+		lengths: make([]int64, len(f.Files)),
+	}
+	var offset int64
+	for i, file := range f.Files {
+		s.offsets[i] = offset
+		s.lengths[i] = file.Length
+		offset += file.Length
+	}
+	return s
+}
+
+// Open ...
+func (s *BlobStorage) Open(index int, create bool) (File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if index < 0 || index >= len(s.offsets) {
+		return nil, fmt.Errorf("BlobStorage.Open: invalid index %d", index)
+	}
+
+	if s.blob == nil {
+		flag := os.O_RDWR
+		if create {
+			if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+				return nil, fmt.Errorf("BlobStorage.Open: os.MkdirAll failed: %v", err)
+			}
+			flag |= os.O_CREATE
+		}
+		fp, err := os.OpenFile(s.path, flag, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("BlobStorage.Open: os.OpenFile failed: %v", err)
+		}
+		s.blob = fp
+	}
+
+	return &_BlobFile{
+		blob:   s.blob,
+		offset: s.offsets[index],
+		length: s.lengths[index],
+	}, nil
+}
+
+// Close ...
+func (s *BlobStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.blob == nil {
+		return nil
+	}
+	err := s.blob.Close()
+	s.blob = nil
+	return err
+}
+
+// _BlobFile is a section of the blob.
+type _BlobFile struct {
+	blob   *os.File
+	offset int64
+	length int64
+}
+
+func (f *_BlobFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > f.length {
+		return 0, io.EOF
+	}
+	return f.blob.ReadAt(p, f.offset+off)
+}
+
+func (f *_BlobFile) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > f.length {
+		return 0, fmt.Errorf("BlobFile.WriteAt: out of bound")
+	}
+	return f.blob.WriteAt(p, f.offset+off)
+}
+
+func (f *_BlobFile) Close() error {
+	return nil
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/movsb/torrent/pkg/torrent"
+)
+
+// MemoryStorage stores the files in memory.
+type MemoryStorage struct {
+	mu    sync.Mutex
+	files []*_MemoryFile
+}
+
+var _ Storage = &MemoryStorage{}
+
+// NewMemoryStorage ...
+func NewMemoryStorage(f *torrent.File) *MemoryStorage {
+	return &MemoryStorage{
+		files: make([]*_MemoryFile, len(f.Files)),
+	}
+}
+
+// Open ...
+func (s *MemoryStorage) Open(index int, create bool) (File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if index < 0 || index >= len(s.files) {
+		return nil, fmt.Errorf("MemoryStorage.Open: invalid index %d", index)
+	}
+	if s.files[index] == nil {
+		if !create {
+			return nil, fmt.Errorf("MemoryStorage.Open: no such file: %d", index)
+		}
+		s.files[index] = &_MemoryFile{}
+	}
+	return s.files[index], nil
+}
+
+// Close ...
+func (s *MemoryStorage) Close() error {
+	return nil
+}
+
+// _MemoryFile grows as it is written. Closing it does nothing,
+// as the data is kept until the storage is gone.
+type _MemoryFile struct {
+	mu   sync.RWMutex
+	data []byte
+}
+
+func (f *_MemoryFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if off < 0 {
+		return 0, fmt.Errorf("MemoryFile.ReadAt: negative offset")
+	}
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *_MemoryFile) WriteAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if off < 0 {
+		return 0, fmt.Errorf("MemoryFile.WriteAt: negative offset")
+	}
+	if end := off + int64(len(p)); end > int64(len(f.data)) {
+		f.data = append(f.data, make([]byte, end-int64(len(f.data)))...)
+	}
+	return copy(f.data[off:], p), nil
+}
+
+func (f *_MemoryFile) Close() error {
+	return nil
+}
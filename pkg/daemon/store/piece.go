@@ -2,8 +2,6 @@ package store
 
 import (
 	"fmt"
-	"os"
-	"path/filepath"
 	"sync"
 
 	"github.com/movsb/torrent/pkg/torrent"
@@ -22,20 +20,36 @@ type PieceManager struct {
 	// The parsed torrent file.
 	f *torrent.File
 
-	// File handles for each file in the torrent.
-	// Opens on demand.
-	fds []*os.File
+	// Where the files are stored.
+	storage Storage
+
+	// File handles for each file in the torrent, and the part file
+	// at the end. Opens on demand.
+	fds []File
 
 	// A piece may span multiple files.
 	piece2files [][]_IndexedFile
+
+	// Data of skipped files are kept in the part file instead,
+	// if the storage has one, so that they are not created.
+	skipped []bool
 }
 
-// NewPieceManager ...
-func NewPieceManager(f *torrent.File) *PieceManager {
+// _Segment is where a part of a piece is stored.
+type _Segment struct {
+	fd     int
+	offset int64
+	length int
+}
+
+// NewPieceManager creates a piece manager for the files of f in storage.
+func NewPieceManager(f *torrent.File, storage Storage) *PieceManager {
 	pm := &PieceManager{
 		f:           f,
-		fds:         make([]*os.File, len(f.Files)),
+		storage:     storage,
+		fds:         make([]File, len(f.Files)+1),
 		piece2files: make([][]_IndexedFile, f.PieceHashes.Count()),
+		skipped:     make([]bool, len(f.Files)),
 	}
 
 	pm.calcFiles()
@@ -85,10 +99,19 @@ func (p *PieceManager) calcFiles() {
 // Close ...
 func (p *PieceManager) Close() error {
 	var lastErr error
-	for _, f := range p.fds {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, f := range p.fds {
+		if f == nil {
+			continue
+		}
 		if err := f.Close(); err != nil {
 			lastErr = err
 		}
+		p.fds[i] = nil
+	}
+	if err := p.storage.Close(); err != nil {
+		lastErr = err
 	}
 	return lastErr
 }
@@ -99,103 +122,308 @@ func (p *PieceManager) PieceCount() int {
 }
 
 // ReadPiece ...
-// TODO merge read & write.
 func (p *PieceManager) ReadPiece(index int) ([]byte, error) {
-	if err := p.openFiles(index); err != nil {
+	segments, err := p.rlockFiles(index, false)
+	if err != nil {
 		return nil, fmt.Errorf("PieceManager.ReadPiece failed: %v", err)
 	}
-
-	p.mu.RLock()
 	defer p.mu.RUnlock()
 
+	data, err := p.read(segments)
+	if err != nil {
+		return nil, fmt.Errorf("PieceManager.ReadPiece failed: %v", err)
+	}
+	return data, nil
+}
+
+// read reads a piece from the segments opened.
+func (p *PieceManager) read(segments []_Segment) ([]byte, error) {
 	offset := 0
-	files := p.piece2files[index]
 	data := make([]byte, p.f.PieceLength)
 
-	for _, f := range files {
-		fp := p.fds[f.index]
-		block := data[offset : offset+f.length]
-		_, err := fp.ReadAt(block, f.offset)
+	for _, seg := range segments {
+		block := data[offset : offset+seg.length]
+		_, err := p.fds[seg.fd].ReadAt(block, seg.offset)
 		if err != nil {
-			return nil, fmt.Errorf("PieceManager.ReadPiece failed: %v", err)
+			return nil, err
 		}
-		offset += f.length
+		offset += seg.length
 	}
 
-	// TODO(movsb): check this
-	// if offset != len(data) {
-	// 	return nil, fmt.Errorf("PieceManager.ReadPiece: offset != len(data)")
-	// }
-
-	return data, nil
+	// The last piece may be shorter.
+	return data[:offset], nil
 }
 
-// WritePiece ...
+// WritePiece writes a verified piece.
 func (p *PieceManager) WritePiece(index int, data []byte) error {
-	if err := p.openFiles(index); err != nil {
-		return fmt.Errorf("PieceManager.WritePiece failed: %v", err)
+	if err := p.writePiece(index, data); err != nil {
+		return err
+	}
+
+	if c, ok := p.storage.(PieceCompleter); ok {
+		if err := c.PieceCompleted(index); err != nil {
+			return fmt.Errorf("PieceManager.WritePiece: %v", err)
+		}
 	}
 
+	return nil
+}
+
+func (p *PieceManager) writePiece(index int, data []byte) error {
 	// There won't be two writes for one piece index,
 	// So it is ok to just Read-Lock?
-	p.mu.RLock()
+	segments, err := p.rlockFiles(index, true)
+	if err != nil {
+		return fmt.Errorf("PieceManager.WritePiece failed: %v", err)
+	}
 	defer p.mu.RUnlock()
 
-	offset := 0
-	files := p.piece2files[index]
+	if err := p.write(segments, data); err != nil {
+		return fmt.Errorf("PieceManager.WritePiece: %v", err)
+	}
+	return nil
+}
 
-	for _, f := range files {
-		fp := p.fds[f.index]
-		block := data[offset : offset+f.length]
-		_, err := fp.WriteAt(block, f.offset)
+// write writes a piece to the segments opened.
+func (p *PieceManager) write(segments []_Segment, data []byte) error {
+	offset := 0
+	for _, seg := range segments {
+		if offset+seg.length > len(data) {
+			return fmt.Errorf("data too short")
+		}
+		block := data[offset : offset+seg.length]
+		_, err := p.fds[seg.fd].WriteAt(block, seg.offset)
 		if err != nil {
-			return fmt.Errorf("PieceManager.WritePiece failed: %v", err)
+			return err
 		}
-		offset += f.length
+		offset += seg.length
 	}
 
 	if offset != len(data) {
-		return fmt.Errorf("PieceManager.WritePiece: offset != len(data)")
+		return fmt.Errorf("offset != len(data)")
+	}
+
+	return nil
+}
+
+// PieceFiles returns the indexes of the files the piece spans.
+func (p *PieceManager) PieceFiles(index int) []int {
+	if index < 0 || index >= len(p.piece2files) {
+		return nil
+	}
+	files := make([]int, 0, len(p.piece2files[index]))
+	for _, f := range p.piece2files[index] {
+		files = append(files, f.index)
+	}
+	return files
+}
+
+// FileRange is a range of a file of the torrent.
+type FileRange struct {
+	File   int
+	Offset int64
+	Length int
+}
+
+// FileRanges returns the ranges of the files that the range of the piece spans.
+func (p *PieceManager) FileRanges(index int, begin int, length int) []FileRange {
+	if index < 0 || index >= len(p.piece2files) {
+		return nil
+	}
+
+	var ranges []FileRange
+	end := begin + length
+	pieceOffset := 0
+	for _, f := range p.piece2files[index] {
+		start := pieceOffset
+		pieceOffset += f.length
+
+		from, to := start, start+f.length
+		if from < begin {
+			from = begin
+		}
+		if to > end {
+			to = end
+		}
+		if from >= to {
+			continue
+		}
+		ranges = append(ranges, FileRange{
+			File:   f.index,
+			Offset: f.offset + int64(from-start),
+			Length: to - from,
+		})
+	}
+	return ranges
+}
+
+// SetSkipped sets whether a file is skipped. Data of skipped files are
+// kept in the part file, if the storage has one. The pieces spanning the
+// file that has returns true for are moved between the file and the part file.
+// Pieces are neither read nor written until it's done, and it must not be
+// called while pieces are being written, which has won't tell.
+func (p *PieceManager) SetSkipped(file int, skipped bool, has func(index int) bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if file < 0 || file >= len(p.skipped) {
+		return fmt.Errorf("PieceManager.SetSkipped: invalid index %d", file)
+	}
+	if p.skipped[file] == skipped {
+		return nil
+	}
+	if _, ok := p.storage.(PartStorage); !ok {
+		p.skipped[file] = skipped
+		return nil
+	}
+
+	pieces := make(map[int][]byte)
+	for index, files := range p.piece2files {
+		for _, f := range files {
+			if f.index == file && has(index) {
+				if err := p.open(index, false); err != nil {
+					return fmt.Errorf("PieceManager.SetSkipped: %v", err)
+				}
+				data, err := p.read(p.segments(index))
+				if err != nil {
+					return fmt.Errorf("PieceManager.SetSkipped: %v", err)
+				}
+				pieces[index] = data
+				break
+			}
+		}
 	}
 
+	p.skipped[file] = skipped
+
+	for index, data := range pieces {
+		if err := p.open(index, true); err != nil {
+			return fmt.Errorf("PieceManager.SetSkipped: %v", err)
+		}
+		if err := p.write(p.segments(index), data); err != nil {
+			return fmt.Errorf("PieceManager.SetSkipped: %v", err)
+		}
+	}
+	return nil
+}
+
+// FilePaths returns the paths of the files in the torrent,
+// or nil if the storage is not a PathStorage.
+func (p *PieceManager) FilePaths() []string {
+	if ps, ok := p.storage.(PathStorage); ok {
+		return ps.Paths()
+	}
 	return nil
 }
 
-// TODO(movsb): on read, this will also open the file, which causes an
-// empty file to be created, if it doesn't exist.
-func (p *PieceManager) openFiles(index int) error {
+// Move moves the files to dir, if the storage supports it.
+// Reads and writes wait until the files are moved.
+func (p *PieceManager) Move(dir string) error {
+	m, ok := p.storage.(Mover)
+	if !ok {
+		return fmt.Errorf("PieceManager.Move: storage can't be moved")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, f := range p.fds {
+		if f == nil {
+			continue
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("PieceManager.Move: %v", err)
+		}
+		p.fds[i] = nil
+	}
+
+	return m.Move(dir)
+}
+
+// rlockFiles opens the files the piece spans, and returns with p.mu
+// read-locked, so that they won't be closed until it's unlocked.
+func (p *PieceManager) rlockFiles(index int, create bool) ([]_Segment, error) {
+	for {
+		if err := p.openFiles(index, create); err != nil {
+			return nil, err
+		}
+		p.mu.RLock()
+		segments := p.segments(index)
+		opened := true
+		for _, seg := range segments {
+			if p.fds[seg.fd] == nil {
+				opened = false
+				break
+			}
+		}
+		if opened {
+			return segments, nil
+		}
+		// Closed in between, e.g. moved.
+		p.mu.RUnlock()
+	}
+}
+
+// segments returns where the parts of the piece are stored.
+// Parts of skipped files are stored in the part file, at the offset
+// of the piece as if the torrent were a single file. p.mu must be held.
+func (p *PieceManager) segments(index int) []_Segment {
+	_, hasPart := p.storage.(PartStorage)
+
+	files := p.piece2files[index]
+	segments := make([]_Segment, 0, len(files))
+	pieceOffset := 0
+
+	for _, f := range files {
+		seg := _Segment{
+			fd:     f.index,
+			offset: f.offset,
+			length: f.length,
+		}
+		if hasPart && p.skipped[f.index] {
+			seg.fd = len(p.f.Files)
+			seg.offset = int64(index)*int64(p.f.PieceLength) + int64(pieceOffset)
+		}
+		segments = append(segments, seg)
+		pieceOffset += f.length
+	}
+	return segments
+}
+
+// openFiles opens the files the piece spans. Files are created
+// only for writing, so that reading a missing piece fails.
+func (p *PieceManager) openFiles(index int, create bool) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	return p.open(index, create)
+}
 
+// open opens the files the piece spans. p.mu must be held.
+func (p *PieceManager) open(index int, create bool) error {
 	if index < 0 || index >= len(p.piece2files) {
 		return fmt.Errorf("PieceManager.openFiles: invalid index %d", index)
 	}
 
-	for _, file := range p.piece2files[index] {
+	for _, seg := range p.segments(index) {
 		// if this file is already open, does nothing.
-		if fd := p.fds[file.index]; fd != nil {
+		if fd := p.fds[seg.fd]; fd != nil {
 			continue
 		}
 
-		segments := p.f.Files[file.index].Paths
-		dir, name := `.`, segments[len(segments)-1]
-
-		// create those parent directories first.
-		if !p.f.Single || len(segments) > 1 {
-			dir = filepath.Join(segments[0 : len(segments)-1]...)
-			dir = filepath.Join(p.f.Name, dir)
-			if err := os.MkdirAll(dir, 0755); err != nil {
-				return fmt.Errorf("PieceManager.openFiles: os.MkdirAll failed: %v", err)
-			}
+		var (
+			fp  File
+			err error
+		)
+		if seg.fd == len(p.f.Files) {
+			fp, err = p.storage.(PartStorage).OpenPart(create)
+		} else {
+			fp, err = p.storage.Open(seg.fd, create)
 		}
-
-		fullPath := filepath.Join(dir, name)
-		fp, err := os.OpenFile(fullPath, os.O_RDWR|os.O_CREATE, 0644)
 		if err != nil {
-			return fmt.Errorf("PieceManager.openFiles: os.OpenFile failed: %v", err)
+			return fmt.Errorf("PieceManager.openFiles: %v", err)
 		}
 
-		p.fds[file.index] = fp
+		p.fds[seg.fd] = fp
 	}
 	return nil
 }
@@ -19,8 +19,64 @@ func TestIndex2Files(t *testing.T) {
 		PieceLength: 100,
 		PieceHashes: common.PieceHashes((&[80]byte{})[:]),
 	}
-	pm := NewPieceManager(&file)
+	pm := NewPieceManager(&file, NewMemoryStorage(&file))
 	for _, pf := range pm.piece2files {
 		fmt.Printf("%+v\n", pf)
 	}
 }
+
+func TestFileRanges(t *testing.T) {
+	file := torrent.File{
+		Files: []torrent.Item{
+			{Length: 80},
+			{Length: 140},
+			{Length: 50},
+		},
+		PieceLength: 100,
+		PieceHashes: common.PieceHashes((&[60]byte{})[:]),
+	}
+	pm := NewPieceManager(&file, NewMemoryStorage(&file))
+
+	tests := []struct {
+		index, begin, length int
+		want                 []FileRange
+	}{
+		{0, 0, 100, []FileRange{{0, 0, 80}, {1, 0, 20}}},
+		{0, 70, 20, []FileRange{{0, 70, 10}, {1, 0, 10}}},
+		{0, 90, 10, []FileRange{{1, 10, 10}}},
+		{2, 10, 40, []FileRange{{1, 130, 10}, {2, 0, 30}}},
+	}
+	for _, test := range tests {
+		got := pm.FileRanges(test.index, test.begin, test.length)
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%d %d %d: got %v, want %v", test.index, test.begin, test.length, got, test.want)
+		}
+	}
+}
+
+type _WrappedFileStorage struct {
+	Storage
+	fs *FileStorage
+}
+
+func (w *_WrappedFileStorage) Paths() []string {
+	return w.fs.Paths()
+}
+
+func TestFilePaths(t *testing.T) {
+	file := torrent.File{
+		Name:        `a`,
+		Single:      true,
+		Files:       []torrent.Item{{Length: 10, Paths: []string{`a`}}},
+		Length:      10,
+		PieceLength: 16,
+		PieceHashes: make(common.PieceHashes, 20),
+	}
+	fs := newFileStorage(t, &file, `/dir`)
+	if paths := NewPieceManager(&file, &_WrappedFileStorage{Storage: fs, fs: fs}).FilePaths(); len(paths) != 1 {
+		t.Fatalf("paths: %v", paths)
+	}
+	if paths := NewPieceManager(&file, NewMemoryStorage(&file)).FilePaths(); paths != nil {
+		t.Fatalf("paths of memory storage: %v", paths)
+	}
+}
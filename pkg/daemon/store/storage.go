@@ -0,0 +1,290 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/movsb/torrent/pkg/torrent"
+)
+
+// File is a file of a torrent opened by a Storage.
+type File interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+}
+
+// Storage stores the files of a torrent, which are indexed as in
+// torrent.File.Files. Opened files are closed before the storage.
+type Storage interface {
+	// Open opens the file. It is created only if create is true,
+	// otherwise opening a missing file fails.
+	Open(index int, create bool) (File, error)
+
+	// Close closes the storage.
+	Close() error
+}
+
+// PieceCompleter is implemented by storages that want to know
+// when a verified piece is written.
+type PieceCompleter interface {
+	PieceCompleted(index int) error
+}
+
+// PartStorage is implemented by storages that keep the data of skipped
+// files, which pieces of wanted files straddle, in a part file instead,
+// so that skipped files are not created.
+type PartStorage interface {
+	OpenPart(create bool) (File, error)
+}
+
+// Mover is implemented by storages whose files can be moved
+// to another directory.
+type Mover interface {
+	// Move moves the files to dir. Either all the files are moved,
+	// or none, if it fails. Files must be closed before.
+	Move(dir string) error
+}
+
+// PathStorage is implemented by storages whose files are on disk,
+// by which the resume data is validated.
+type PathStorage interface {
+	// Paths returns the paths of the files.
+	Paths() []string
+}
+
+// FileStorage stores the files on disk, under a directory.
+// Files of a multi-file torrent are put in a directory named after it,
+// unless NoRootFolder is set.
+type FileStorage struct {
+	// Put the files of a multi-file torrent in the directory directly.
+	// It must be set before the storage is used.
+	NoRootFolder bool
+
+	f *torrent.File
+
+	mu  sync.RWMutex
+	dir string
+}
+
+var (
+	_ Storage     = &FileStorage{}
+	_ PartStorage = &FileStorage{}
+	_ Mover       = &FileStorage{}
+	_ PathStorage = &FileStorage{}
+)
+
+// NewFileStorage creates a storage of the files of f under dir.
+// The torrent may come from anyone, so its paths are checked not to
+// go out of dir.
+func NewFileStorage(f *torrent.File, dir string) (*FileStorage, error) {
+	if err := checkPaths(f); err != nil {
+		return nil, fmt.Errorf("NewFileStorage: %v", err)
+	}
+	return &FileStorage{
+		f:   f,
+		dir: dir,
+	}, nil
+}
+
+// checkPaths checks that the name of the torrent and each path segment
+// of the files are single, non-empty path elements.
+func checkPaths(f *torrent.File) error {
+	if !validSegment(f.Name) {
+		return fmt.Errorf("invalid name: %q", f.Name)
+	}
+	for i, item := range f.Files {
+		if len(item.Paths) == 0 {
+			return fmt.Errorf("file %d has no path", i)
+		}
+		for _, segment := range item.Paths {
+			if !validSegment(segment) {
+				return fmt.Errorf("invalid path of file %d: %q", i, segment)
+			}
+		}
+	}
+	return nil
+}
+
+func validSegment(segment string) bool {
+	switch segment {
+	case ``, `.`, `..`:
+		return false
+	}
+	return !strings.ContainsAny(segment, `/\`) &&
+		!filepath.IsAbs(segment) && filepath.VolumeName(segment) == ``
+}
+
+// Open ...
+func (s *FileStorage) Open(index int, create bool) (File, error) {
+	if index < 0 || index >= len(s.f.Files) {
+		return nil, fmt.Errorf("FileStorage.Open: invalid index %d", index)
+	}
+
+	s.mu.RLock()
+	dir, path := s.path(s.dir, index)
+	s.mu.RUnlock()
+
+	return openFile(dir, path, create)
+}
+
+// OpenPart opens the part file, which is hidden in the directory.
+func (s *FileStorage) OpenPart(create bool) (File, error) {
+	s.mu.RLock()
+	dir, path := s.dir, s.partPath(s.dir)
+	s.mu.RUnlock()
+
+	return openFile(dir, path, create)
+}
+
+func openFile(dir string, path string, create bool) (File, error) {
+	flag := os.O_RDWR
+	if create {
+		// create those parent directories first.
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("FileStorage.Open: os.MkdirAll failed: %v", err)
+		}
+		flag |= os.O_CREATE
+	}
+
+	fp, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("FileStorage.Open: os.OpenFile failed: %v", err)
+	}
+	return fp, nil
+}
+
+// Close ...
+func (s *FileStorage) Close() error {
+	return nil
+}
+
+// Dir returns the directory the files are stored in.
+func (s *FileStorage) Dir() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.dir
+}
+
+// Paths returns the paths of the files.
+func (s *FileStorage) Paths() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	paths := make([]string, len(s.f.Files))
+	for i := range s.f.Files {
+		_, paths[i] = s.path(s.dir, i)
+	}
+	return paths
+}
+
+// Move ...
+func (s *FileStorage) Move(dir string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if filepath.Clean(dir) == filepath.Clean(s.dir) {
+		return nil
+	}
+
+	type _Moved struct{ from, to string }
+	var moved []_Moved
+
+	for i := 0; i <= len(s.f.Files); i++ {
+		var from, to string
+		if i < len(s.f.Files) {
+			_, from = s.path(s.dir, i)
+			_, to = s.path(dir, i)
+		} else {
+			from, to = s.partPath(s.dir), s.partPath(dir)
+		}
+		if _, err := os.Stat(from); os.IsNotExist(err) {
+			continue
+		}
+		if err := moveFile(from, to); err != nil {
+			for j := len(moved) - 1; j >= 0; j-- {
+				if err := moveFile(moved[j].to, moved[j].from); err != nil {
+					log.Printf("FileStorage.Move: failed to move back: %v", err)
+				}
+			}
+			return fmt.Errorf("FileStorage.Move: %v", err)
+		}
+		moved = append(moved, _Moved{from: from, to: to})
+	}
+
+	// Remove the directories left empty, best effort.
+	for _, m := range moved {
+		for d := filepath.Dir(m.from); len(d) > len(s.dir); d = filepath.Dir(d) {
+			if os.Remove(d) != nil {
+				break
+			}
+		}
+	}
+
+	s.dir = dir
+	return nil
+}
+
+// path returns the parent directory and the path of a file under root.
+func (s *FileStorage) path(root string, index int) (string, string) {
+	segments := s.f.Files[index].Paths
+	dir, name := root, segments[len(segments)-1]
+	if !s.f.Single || len(segments) > 1 {
+		dir = filepath.Join(segments[0 : len(segments)-1]...)
+		if s.NoRootFolder {
+			dir = filepath.Join(root, dir)
+		} else {
+			dir = filepath.Join(root, s.f.Name, dir)
+		}
+	}
+	return dir, filepath.Join(dir, name)
+}
+
+func (s *FileStorage) partPath(root string) string {
+	return filepath.Join(root, `.`+s.f.InfoHash().String()+`.parts`)
+}
+
+// moveFile moves a file, by renaming, or copying if it can't be renamed,
+// e.g. across file systems.
+func moveFile(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	if _, err := os.Stat(to); err == nil {
+		return fmt.Errorf("file exists: %s", to)
+	}
+	if err := os.Rename(from, to); err == nil {
+		return nil
+	}
+
+	// Copy to a temporary file first, so that to is never partially written.
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := to + `.part`
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, to); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(from)
+}
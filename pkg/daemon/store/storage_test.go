@@ -0,0 +1,234 @@
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/movsb/torrent/pkg/common"
+	"github.com/movsb/torrent/pkg/torrent"
+)
+
+type _Completed struct {
+	Storage
+	pieces []int
+}
+
+func (c *_Completed) PieceCompleted(index int) error {
+	c.pieces = append(c.pieces, index)
+	return nil
+}
+
+func newFileStorage(t *testing.T, f *torrent.File, dir string) *FileStorage {
+	t.Helper()
+	fs, err := NewFileStorage(f, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func TestStorages(t *testing.T) {
+	file := torrent.File{
+		Name: `dir`,
+		Files: []torrent.Item{
+			{Length: 80, Paths: []string{`a`}},
+			{Length: 140, Paths: []string{`b`, `c`}},
+			{Length: 50, Paths: []string{`d`}},
+		},
+		Length:      270,
+		PieceLength: 100,
+		PieceHashes: common.PieceHashes((&[60]byte{})[:]),
+	}
+
+	dir := t.TempDir()
+	storages := map[string]Storage{
+		`file`:   newFileStorage(t, &file, dir),
+		`memory`: NewMemoryStorage(&file),
+		`blob`:   NewBlobStorage(&file, filepath.Join(dir, `blob`)),
+	}
+
+	for name, storage := range storages {
+		completed := &_Completed{Storage: storage}
+		pm := NewPieceManager(&file, completed)
+
+		if _, err := pm.ReadPiece(1); err == nil {
+			t.Errorf("%s: read missing piece should fail", name)
+		}
+
+		pieces := [][]byte{
+			bytes.Repeat([]byte{1}, 100),
+			bytes.Repeat([]byte{2}, 100),
+			bytes.Repeat([]byte{3}, 70),
+		}
+		for _, i := range []int{2, 0, 1} {
+			if err := pm.WritePiece(i, pieces[i]); err != nil {
+				t.Fatalf("%s: write piece %d: %v", name, i, err)
+			}
+		}
+		for i, want := range pieces {
+			got, err := pm.ReadPiece(i)
+			if err != nil {
+				t.Fatalf("%s: read piece %d: %v", name, i, err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s: piece %d mismatch", name, i)
+			}
+		}
+		if len(completed.pieces) != 3 {
+			t.Errorf("%s: completed pieces: %v", name, completed.pieces)
+		}
+
+		if err := pm.Close(); err != nil {
+			t.Errorf("%s: close: %v", name, err)
+		}
+	}
+}
+
+func TestFileStorageMove(t *testing.T) {
+	file := torrent.File{
+		Name: `dir`,
+		Files: []torrent.Item{
+			{Length: 80, Paths: []string{`a`}},
+			{Length: 20, Paths: []string{`b`, `c`}},
+		},
+		Length:      100,
+		PieceLength: 100,
+		PieceHashes: common.PieceHashes((&[20]byte{})[:]),
+	}
+
+	root := t.TempDir()
+	from, to := filepath.Join(root, `from`), filepath.Join(root, `to`)
+
+	fs := newFileStorage(t, &file, from)
+	fs.NoRootFolder = true
+	pm := NewPieceManager(&file, fs)
+	defer pm.Close()
+
+	piece := bytes.Repeat([]byte{1}, 100)
+	if err := pm.WritePiece(0, piece); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(from, `b`, `c`)); err != nil {
+		t.Fatalf("no root folder: %v", err)
+	}
+
+	// Moving fails if a file exists there, and nothing is moved.
+	if err := os.MkdirAll(filepath.Join(to, `b`), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(to, `b`, `c`), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Move(to); err == nil {
+		t.Fatal("move should fail")
+	}
+	if _, err := os.Stat(filepath.Join(from, `a`)); err != nil {
+		t.Fatalf("not moved back: %v", err)
+	}
+
+	if err := os.RemoveAll(to); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Move(to); err != nil {
+		t.Fatal(err)
+	}
+	if fs.Dir() != to {
+		t.Fatalf("dir: got %s, want %s", fs.Dir(), to)
+	}
+	if _, err := os.Stat(filepath.Join(from, `b`)); !os.IsNotExist(err) {
+		t.Fatalf("empty directory is left: %v", err)
+	}
+	got, err := pm.ReadPiece(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, piece) {
+		t.Fatal("piece mismatch after move")
+	}
+}
+
+func TestFileStorageSkipped(t *testing.T) {
+	file := torrent.File{
+		Name: `dir`,
+		Files: []torrent.Item{
+			{Length: 80, Paths: []string{`a`}},
+			{Length: 40, Paths: []string{`b`}},
+		},
+		Length:      120,
+		PieceLength: 100,
+		PieceHashes: common.PieceHashes((&[40]byte{})[:]),
+	}
+
+	dir := t.TempDir()
+	pm := NewPieceManager(&file, newFileStorage(t, &file, dir))
+	defer pm.Close()
+
+	written := false
+	has := func(index int) bool { return index == 0 && written }
+	if err := pm.SetSkipped(1, true, has); err != nil {
+		t.Fatal(err)
+	}
+
+	// Piece 0 straddles a and b, the part of b goes to the part file.
+	piece := bytes.Repeat([]byte{1}, 100)
+	if err := pm.WritePiece(0, piece); err != nil {
+		t.Fatal(err)
+	}
+	written = true
+	if _, err := os.Stat(filepath.Join(dir, `dir`, `b`)); !os.IsNotExist(err) {
+		t.Fatalf("skipped file is created: %v", err)
+	}
+	got, err := pm.ReadPiece(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, piece) {
+		t.Fatal("piece mismatch")
+	}
+
+	// Once wanted, the data is moved from the part file.
+	if err := pm.SetSkipped(1, false, has); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, `dir`, `b`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, piece[80:]) {
+		t.Fatalf("file b: %v", b)
+	}
+}
+
+func TestFileStorageInvalidPaths(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths []string
+	}{
+		{`dir`, []string{`..`, `..`, `etc`, `x`}},
+		{`dir`, []string{`a`, `..`, `b`}},
+		{`dir`, []string{`/etc/x`}},
+		{`dir`, []string{`a/../../x`}},
+		{`dir`, []string{`a\..\x`}},
+		{`dir`, []string{`a`, ``}},
+		{`dir`, []string{`.`}},
+		{`dir`, nil},
+		{`..`, []string{`a`}},
+		{``, []string{`a`}},
+		{`/etc`, []string{`a`}},
+	}
+	for _, test := range tests {
+		file := torrent.File{
+			Name:        test.name,
+			Files:       []torrent.Item{{Length: 10, Paths: test.paths}},
+			Length:      10,
+			PieceLength: 16,
+			PieceHashes: make(common.PieceHashes, 20),
+		}
+		if _, err := NewFileStorage(&file, t.TempDir()); err == nil {
+			t.Errorf("%q %q: invalid paths are accepted", test.name, test.paths)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"context"
+	"crypto/sha1"
+
+	"github.com/movsb/torrent/pkg/message"
+)
+
+// Verify reads every piece and checks it against its hash, and returns the
+// bitfield of the good pieces. Missing or unreadable pieces are just bad.
+//
+// At most parallel pieces are read at the same time. progress, if not nil,
+// is called after each piece is checked, from one goroutine at a time.
+func (p *PieceManager) Verify(ctx context.Context, parallel int, progress func(checked, good int)) (*message.BitField, error) {
+	nPieces := p.PieceCount()
+	bf := message.NewBitField(nPieces, 0)
+
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	indexes := make(chan int)
+	go func() {
+		defer close(indexes)
+		for i := 0; i < nPieces; i++ {
+			select {
+			case indexes <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	type _Result struct {
+		index int
+		good  bool
+	}
+
+	results := make(chan _Result)
+	for i := 0; i < parallel; i++ {
+		go func() {
+			for index := range indexes {
+				r := _Result{index: index}
+				if data, err := p.ReadPiece(index); err == nil {
+					r.good = sha1.Sum(data) == p.f.PieceHashes.Index(index)
+				}
+				select {
+				case results <- r:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	good := 0
+	for checked := 1; checked <= nPieces; checked++ {
+		select {
+		case r := <-results:
+			if r.good {
+				bf.SetPiece(r.index)
+				good++
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if progress != nil {
+			progress(checked, good)
+		}
+	}
+
+	return bf, nil
+}
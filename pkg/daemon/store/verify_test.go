@@ -0,0 +1,62 @@
+package store
+
+import (
+	"context"
+	"crypto/sha1"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/movsb/torrent/pkg/common"
+	"github.com/movsb/torrent/pkg/torrent"
+)
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+
+	data := make([]byte, 250)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	var hashes []byte
+	for i := 0; i < len(data); i += 100 {
+		end := i + 100
+		if end > len(data) {
+			end = len(data)
+		}
+		sum := sha1.Sum(data[i:end])
+		hashes = append(hashes, sum[:]...)
+	}
+
+	file := torrent.File{
+		Name:   `a`,
+		Single: true,
+		Files: []torrent.Item{
+			{Length: int64(len(data)), Paths: []string{`a`}},
+		},
+		Length:      int64(len(data)),
+		PieceLength: 100,
+		PieceHashes: common.PieceHashes(hashes),
+	}
+
+	// The second piece is corrupted.
+	corrupted := append([]byte(nil), data...)
+	corrupted[150] ^= 0xFF
+	if err := ioutil.WriteFile(filepath.Join(dir, `a`), corrupted, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pm := NewPieceManager(&file, newFileStorage(t, &file, dir))
+	defer pm.Close()
+
+	bf, err := pm.Verify(context.Background(), 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []bool{true, false, true} {
+		if got := bf.HasPiece(i); got != want {
+			t.Errorf("piece %d: got %v, want %v", i, got, want)
+		}
+	}
+}
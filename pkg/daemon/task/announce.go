@@ -4,10 +4,13 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math/rand"
 	"net"
 	"net/url"
+	"sync"
 	"time"
 
+	"github.com/movsb/torrent/pkg/daemon/picker"
 	"github.com/movsb/torrent/pkg/message"
 	"github.com/movsb/torrent/pkg/peer"
 	trackercommon "github.com/movsb/torrent/pkg/tracker/common"
@@ -15,70 +18,324 @@ import (
 	trackerudpclient "github.com/movsb/torrent/pkg/tracker/udp/client"
 )
 
-type _Announce struct {
-	address  string
-	interval int
+const (
+	// How often to announce if the tracker doesn't tell.
+	defaultAnnounceInterval = time.Minute * 30
+
+	// How long to wait before announcing again, if all the trackers
+	// of a tier failed.
+	announceRetryInterval = time.Minute
+)
+
+// _Tier is a tier of trackers (BEP 12). Trackers are tried in order until
+// one works, which is then moved to the front of the tier.
+type _Tier struct {
+	mu       sync.Mutex
+	trackers []string
+
+	// Trackers the started event is sent to.
+	started map[string]bool
+
+	// Whether the completed event is to be sent.
+	completing bool
+
+	// Wakes up the tier to send an event.
+	wakeCh chan struct{}
 }
 
-// announce ...
-func (t *Task) announce(ctx context.Context) error {
-	log.Printf("task.announce-ing\n")
+// _NewTier creates a tier of the trackers, in random order.
+func _NewTier(trackers []string) *_Tier {
+	trackers = append([]string(nil), trackers...)
+	rand.Shuffle(len(trackers), func(i, j int) {
+		trackers[i], trackers[j] = trackers[j], trackers[i]
+	})
+	return &_Tier{
+		trackers: trackers,
+		started:  make(map[string]bool),
+		wakeCh:   make(chan struct{}, 1),
+	}
+}
 
-	execute := func() {
-		interval, peers, err := t.announceOne(ctx, t.File.Announce)
-		if err != nil {
-			log.Printf("task.announce: announce failed: %v", err)
-			return
+// announce announces to the trackers in order by fn until one works.
+// The started event is sent to trackers first, and then the completed
+// event if any.
+func (tier *_Tier) announce(fn func(address string, event trackercommon.Event) error) error {
+	tier.mu.Lock()
+	trackers := append([]string(nil), tier.trackers...)
+	tier.mu.Unlock()
+
+	var err error
+	for _, address := range trackers {
+		tier.mu.Lock()
+		event := trackercommon.EventNone
+		if !tier.started[address] {
+			event = trackercommon.EventStarted
+		} else if tier.completing {
+			event = trackercommon.EventCompleted
 		}
-		if interval <= 0 {
-			log.Printf("task.announce: interval <= 0")
-			return
+		tier.mu.Unlock()
+
+		if err = fn(address, event); err != nil {
+			continue
+		}
+
+		tier.mu.Lock()
+		switch event {
+		case trackercommon.EventStarted:
+			tier.started[address] = true
+		case trackercommon.EventCompleted:
+			tier.completing = false
 		}
-		_ = interval
+		tier.mu.Unlock()
+
+		tier.promote(address)
+		return nil
+	}
+	if err == nil {
+		err = fmt.Errorf("no trackers")
+	}
+	return err
+}
 
-		t.mu.RLock()
-		if t.pieces.Len() == 0 {
-			log.Printf("task.announce: task is done")
-			t.mu.RUnlock()
+// promote moves the tracker to the front of the tier.
+func (tier *_Tier) promote(address string) {
+	tier.mu.Lock()
+	defer tier.mu.Unlock()
+	for i, a := range tier.trackers {
+		if a == address {
+			copy(tier.trackers[1:i+1], tier.trackers[:i])
+			tier.trackers[0] = address
 			return
 		}
-		t.mu.RUnlock()
+	}
+}
 
-		t.spawnPeers(ctx, peers)
+// complete makes the tier send the completed event.
+func (tier *_Tier) complete() {
+	tier.mu.Lock()
+	tier.completing = true
+	tier.mu.Unlock()
+
+	select {
+	case tier.wakeCh <- struct{}{}:
+	default:
 	}
+}
 
-	execute()
+// current returns the tracker the tier announces to,
+// or empty if it has not been started.
+func (tier *_Tier) current() string {
+	tier.mu.Lock()
+	defer tier.mu.Unlock()
+	if len(tier.trackers) == 0 || !tier.started[tier.trackers[0]] {
+		return ``
+	}
+	return tier.trackers[0]
+}
 
-	ticker := time.NewTicker(time.Minute)
-	defer ticker.Stop()
+// AddTrackers adds trackers to announce to, each in a tier of its own.
+func (t *Task) AddTrackers(trackers ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.extraTrackers = append(t.extraTrackers, trackers...)
+
+	// Already announcing, start on the new trackers.
+	if t.announceCtx != nil {
+		for _, tier := range t.addTiers(nil, trackers) {
+			go t.announceTier(t.announceCtx, tier)
+		}
+	}
+}
+
+// addTiers adds tiers of trackers, and returns those added.
+// Trackers seen in tiers are not added again. Trackers not in a tier
+// are each added in a tier of their own. t.mu must be held.
+func (t *Task) addTiers(tiers [][]string, trackers []string) []*_Tier {
+	seen := make(map[string]bool)
+	for _, tier := range t.tiers {
+		for _, address := range tier.trackers {
+			seen[address] = true
+		}
+	}
+
+	for _, address := range trackers {
+		tiers = append(tiers, []string{address})
+	}
+
+	var added []*_Tier
+	for _, tier := range tiers {
+		var list []string
+		for _, address := range tier {
+			if address != `` && !seen[address] {
+				seen[address] = true
+				list = append(list, address)
+			}
+		}
+		if len(list) > 0 {
+			added = append(added, _NewTier(list))
+		}
+	}
+
+	t.tiers = append(t.tiers, added...)
+	return added
+}
+
+// announce announces to all the tiers of trackers at the same time,
+// and peers from all of them are spawned.
+func (t *Task) announce(ctx context.Context) {
+	log.Printf("task.announce-ing\n")
+
+	t.mu.Lock()
+	tiers := t.File.AnnounceList
+	if len(tiers) == 0 {
+		tiers = [][]string{{t.File.Announce}}
+	}
+	var trackers []string
+	if t.Magnet != nil {
+		trackers = append(trackers, t.Magnet.Trackers...)
+	}
+	trackers = append(trackers, t.extraTrackers...)
+	added := t.addTiers(tiers, trackers)
+	t.announceCtx = ctx
+	t.mu.Unlock()
+
+	for _, tier := range added {
+		go t.announceTier(ctx, tier)
+	}
+}
+
+// announceTier announces to the tier periodically, at the interval the
+// tracker tells, and at once on events, but not sooner than the min interval.
+func (t *Task) announceTier(ctx context.Context, tier *_Tier) {
+	var (
+		wait        time.Duration
+		last        time.Time
+		minInterval time.Duration
+	)
 
 	for {
+		timer := time.NewTimer(wait)
 		select {
 		case <-ctx.Done():
+			timer.Stop()
 			log.Printf("task.announce: context done")
+			return
+		case <-timer.C:
+		case <-tier.wakeCh:
+			timer.Stop()
+			if d := time.Until(last.Add(minInterval)); d > 0 {
+				wait = d
+				continue
+			}
+		}
+
+		wait = announceRetryInterval
+
+		err := tier.announce(func(address string, event trackercommon.Event) error {
+			result, err := t.announceOne(ctx, address, event)
+			if err != nil {
+				return err
+			}
+			last = time.Now()
+			wait, minInterval = result.interval, result.minInterval
+			if wait <= 0 {
+				wait = defaultAnnounceInterval
+			}
+			if !t.isComplete() {
+				t.spawnPeers(ctx, result.peers)
+			}
 			return nil
-		case <-ticker.C:
-			execute()
+		})
+		if err != nil {
+			log.Printf("task.announce: announce failed: %v", err)
+		}
+	}
+}
+
+// announceCompleted sends the completed event to the trackers,
+// once a task is completed.
+func (t *Task) announceCompleted() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.completedAnnounced {
+		return
+	}
+	t.completedAnnounced = true
+
+	for _, tier := range t.tiers {
+		tier.complete()
+	}
+}
+
+// announceStopped sends the stopped event to the trackers
+// that have been started.
+func (t *Task) announceStopped(ctx context.Context) {
+	t.mu.RLock()
+	tiers := append([]*_Tier(nil), t.tiers...)
+	t.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, tier := range tiers {
+		address := tier.current()
+		if address == `` {
+			continue
 		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := t.announceOne(ctx, address, trackercommon.EventStopped); err != nil {
+				log.Printf("task.announceStopped: %v", err)
+			}
+		}()
 	}
+	wg.Wait()
 }
 
+// Limits of spawning peers, for peers come from trackers and PEX.
+const (
+	// The max number of connected and connecting peers.
+	maxPeers = 50
+
+	// An address won't be dialed again within this interval.
+	redialInterval = time.Minute * 5
+)
+
 func (t *Task) spawnPeers(ctx context.Context, peers []string) {
 	log.Printf("task.spawnPeers entering")
 
-	t.mu.RLock()
-	defer t.mu.RUnlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
 	n := 0
+	now := time.Now()
+	total := len(t.peers) + len(t.dialing)
+
+	for address, last := range t.dialed {
+		if now.Sub(last) >= redialInterval {
+			delete(t.dialed, address)
+		}
+	}
 
 	for _, address := range peers {
-		if _, ok := t.busyPeers[address]; ok {
+		if total >= maxPeers {
+			log.Printf("task.spawnPeers: too many peers")
+			break
+		}
+		if _, ok := t.peers[address]; ok {
+			continue
+		}
+		if t.dialing[address] {
 			continue
 		}
-		if _, ok := t.idlePeers[address]; ok {
+		if last, ok := t.dialed[address]; ok && now.Sub(last) < redialInterval {
 			continue
 		}
+		t.dialing[address] = true
+		t.dialed[address] = now
 		go t.spawnPeer(ctx, address)
+		total++
 		n++
 	}
 
@@ -86,6 +343,12 @@ func (t *Task) spawnPeers(ctx context.Context, peers []string) {
 }
 
 func (t *Task) spawnPeer(ctx context.Context, address string) {
+	defer func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		delete(t.dialing, address)
+	}()
+
 	conn, err := net.DialTimeout("tcp", address, time.Second*10)
 	if err != nil {
 		log.Printf("dial peer error: %v\n", err)
@@ -106,22 +369,32 @@ func (t *Task) spawnPeer(ctx context.Context, address string) {
 	}
 
 	c := peer.Peer{
-		Ctx:        ctx,
-		HerPeerID:  handshake.PeerID,
-		PM:         t.PM,
-		MyBitField: t.BitField,
-		InfoHash:   t.File.InfoHash(),
-		PeerAddr:   address,
+		Ctx:         ctx,
+		HerPeerID:   handshake.PeerID,
+		HerReserved: handshake.Reserved,
+		Extensions:  t.extensions,
+		PM:          t.PM,
+		MyBitField:  t.BitField,
+		InfoHash:    t.File.InfoHash(),
+		PeerAddr:    address,
 	}
 
 	c.SetConn(conn)
 
+	if err := c.SendBitField(); err != nil {
+		log.Printf("error send bitfield: %v\n", err)
+		return
+	}
 	if err := c.RecvBitField(); err != nil {
 		log.Printf("error recv bitbield: %v\n", err)
 		return
 	}
-	if err := c.Send(message.MsgUnChoke, message.UnChoke{}); err != nil {
-		log.Printf("error send unchoked: %v\n", err)
+	if err := c.SendExtendedHandshake(); err != nil {
+		log.Printf("error send extended handshake: %v\n", err)
+		return
+	}
+	if err := c.SendAllowedFast(); err != nil {
+		log.Printf("error send allowed fast: %v\n", err)
 		return
 	}
 	if err := c.Send(message.MsgInterested, message.Interested{}); err != nil {
@@ -134,48 +407,104 @@ func (t *Task) spawnPeer(ctx context.Context, address string) {
 	t.AddClient(&c)
 }
 
-func (t *Task) announceOne(ctx context.Context, address string) (int, []string, error) {
+// _AnnounceResult is what a tracker responds.
+type _AnnounceResult struct {
+	interval    time.Duration
+	minInterval time.Duration
+	peers       []string
+}
+
+// announceRequest returns the request to announce the event.
+func (t *Task) announceRequest(event trackercommon.Event) *trackercommon.AnnounceRequest {
+	t.mu.RLock()
+	uploaded, downloaded := t.stats()
+	port := t.listenPort
+	t.mu.RUnlock()
+
+	numWant := maxPeers
+	if event == trackercommon.EventStopped {
+		numWant = 0
+	}
+
+	return &trackercommon.AnnounceRequest{
+		InfoHash:   t.InfoHash,
+		PeerID:     trackercommon.MyPeerID,
+		Port:       port,
+		Uploaded:   uploaded,
+		Downloaded: downloaded,
+		Left:       t.left(),
+		Event:      event,
+		NumWant:    numWant,
+		Key:        t.key,
+	}
+}
+
+// left returns the bytes of wanted pieces not downloaded yet.
+func (t *Task) left() int64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	// Unknown without the metadata, but not zero,
+	// or trackers take us for a seeder.
+	if t.File == nil {
+		return 1
+	}
+
+	var left int64
+	for i := 0; i < t.File.PieceHashes.Count(); i++ {
+		if !t.BitField.HasPiece(i) && t.picker.Priority(i) != picker.Skip {
+			left += int64(t.pieceData(i).Length)
+		}
+	}
+	return left
+}
+
+func (t *Task) announceOne(ctx context.Context, address string, event trackercommon.Event) (*_AnnounceResult, error) {
 	u, err := url.Parse(address)
 	if err != nil {
 		log.Printf("task.announce: malformed address: %v", err)
-		return 0, nil, err
+		return nil, err
 	}
 
+	req := t.announceRequest(event)
+
 	switch u.Scheme {
 	case `http`, `https`:
 		tr := trackertcpclient.Client{
-			Address:  address,
-			InfoHash: t.InfoHash,
-			MyPeerID: trackercommon.MyPeerID,
+			Address: address,
 		}
-		resp, err := tr.Announce(ctx)
+		resp, err := tr.Announce(ctx, req)
 		if err != nil {
 			log.Printf("Announce failed: %v", err)
-			return 0, nil, err
-		}
-		if resp.FailureReason != "" {
-			log.Printf("Announce: failure reason: %s", resp.FailureReason)
-			return 0, nil, err
+			return nil, err
 		}
-		peers := make([]string, 0, len(resp.Peers))
+		peers := make([]string, 0, len(resp.Peers)+len(resp.Peers6))
 		for _, peer := range resp.Peers {
-			peers = append(peers, fmt.Sprintf(`%s:%d`, peer.IP, peer.Port))
+			peers = append(peers, peer.Address())
+		}
+		for _, peer := range resp.Peers6 {
+			peers = append(peers, peer.Address())
 		}
-		return resp.Interval, peers, nil
+		return &_AnnounceResult{
+			interval:    time.Duration(resp.Interval) * time.Second,
+			minInterval: time.Duration(resp.MinInterval) * time.Second,
+			peers:       peers,
+		}, nil
 	case `udp`:
 		tr := trackerudpclient.Client{
-			Address:  address,
-			InfoHash: t.InfoHash,
-			MyPeerID: trackercommon.MyPeerID,
+			Address: address,
 		}
-		resp, err := tr.Announce(ctx)
+		resp, err := tr.Announce(ctx, req)
 		if err != nil {
 			log.Printf("Announce failed: %v", err)
-			return 0, nil, err
+			return nil, err
 		}
-		return int(resp.Interval), resp.Peers, nil
+		return &_AnnounceResult{
+			interval: time.Duration(resp.Interval) * time.Second,
+			peers:    resp.Peers,
+		}, nil
 	default:
 		log.Printf("task.announce: unknown address: %s\n", address)
-		return 0, nil, fmt.Errorf("task.announce: unknown address")
+		return nil, fmt.Errorf("task.announce: unknown address")
 	}
 }
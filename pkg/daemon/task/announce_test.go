@@ -0,0 +1,77 @@
+package task
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	trackercommon "github.com/movsb/torrent/pkg/tracker/common"
+)
+
+func TestTier(t *testing.T) {
+	tier := _NewTier(nil)
+	tier.trackers = []string{`a`, `b`, `c`}
+
+	var tried []string
+	var events []trackercommon.Event
+	announce := func(address string, event trackercommon.Event) error {
+		tried = append(tried, address)
+		if address == `a` {
+			return fmt.Errorf("failed")
+		}
+		events = append(events, event)
+		return nil
+	}
+
+	if err := tier.announce(announce); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{`a`, `b`}; !reflect.DeepEqual(tried, want) {
+		t.Fatalf("tried %v, want %v", tried, want)
+	}
+	// The working tracker is moved to the front.
+	if want := []string{`b`, `a`, `c`}; !reflect.DeepEqual(tier.trackers, want) {
+		t.Fatalf("trackers %v, want %v", tier.trackers, want)
+	}
+	if tier.current() != `b` {
+		t.Fatalf("current: %s", tier.current())
+	}
+
+	tier.complete()
+	if err := tier.announce(announce); err != nil {
+		t.Fatal(err)
+	}
+	if err := tier.announce(announce); err != nil {
+		t.Fatal(err)
+	}
+	want := []trackercommon.Event{
+		trackercommon.EventStarted,
+		trackercommon.EventCompleted,
+		trackercommon.EventNone,
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("events %v, want %v", events, want)
+	}
+
+	failed := func(string, trackercommon.Event) error { return fmt.Errorf("failed") }
+	if err := tier.announce(failed); err == nil {
+		t.Fatal("all failed")
+	}
+}
+
+func TestAddTiers(t *testing.T) {
+	task := &Task{}
+	task.addTiers([][]string{{`a`, `b`}, {`c`}, {``}}, []string{`b`, `d`})
+
+	var got [][]string
+	for _, tier := range task.tiers {
+		got = append(got, tier.trackers)
+	}
+	if len(got) != 3 || len(got[0]) != 2 || !reflect.DeepEqual(got[1:], [][]string{{`c`}, {`d`}}) {
+		t.Fatalf("tiers: %v", got)
+	}
+
+	if added := task.addTiers(nil, []string{`a`, `e`}); len(added) != 1 || added[0].trackers[0] != `e` {
+		t.Fatalf("added: %v", added)
+	}
+}
@@ -0,0 +1,171 @@
+package task
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+const (
+	// How often to choke/unchoke peers.
+	chokeInterval = time.Second * 10
+
+	// How often to rotate the optimistic unchoke.
+	optimisticInterval = time.Second * 30
+
+	// The default number of regular upload slots,
+	// the optimistic unchoke is not counted.
+	defaultUploadSlots = 4
+)
+
+// _Chokee is a peer the choker chokes or unchokes.
+type _Chokee interface {
+	DownloadedBytes() int64
+	UploadedBytes() int64
+	HerInterested() bool
+	AmChoking() bool
+	Choke() error
+	UnChoke() error
+
+	// Name is the name of the peer in logs.
+	Name() string
+}
+
+// _Choker decides which peers to unchoke.
+//
+// Every chokeInterval, the interested peers with the top rates are unchoked.
+// The rate is how fast they upload to us while downloading, and how fast
+// we upload to them while seeding. Besides, a random interested peer
+// is unchoked optimistically, which rotates every optimisticInterval.
+type _Choker struct {
+	optimistic     _Chokee
+	lastOptimistic time.Time
+
+	lastTime  time.Time
+	lastBytes map[_Chokee]int64
+}
+
+func _NewChoker() *_Choker {
+	return &_Choker{
+		lastBytes: make(map[_Chokee]int64),
+	}
+}
+
+type _PeerRate struct {
+	peer _Chokee
+	rate float64
+}
+
+// rechoke computes the peers to unchoke by now, and chokes/unchokes
+// those whose states change.
+func (c *_Choker) rechoke(peers []_Chokee, slots int, seeding bool, now time.Time) {
+	elapsed := now.Sub(c.lastTime).Seconds()
+	c.lastTime = now
+
+	lastBytes := make(map[_Chokee]int64, len(peers))
+	rates := make([]_PeerRate, 0, len(peers))
+
+	for _, p := range peers {
+		bytes := p.DownloadedBytes()
+		if seeding {
+			bytes = p.UploadedBytes()
+		}
+		lastBytes[p] = bytes
+
+		if !p.HerInterested() {
+			continue
+		}
+
+		var rate float64
+		if last, ok := c.lastBytes[p]; ok && elapsed > 0 {
+			rate = float64(bytes-last) / elapsed
+		}
+		rates = append(rates, _PeerRate{peer: p, rate: rate})
+	}
+
+	// Forget peers that have gone.
+	c.lastBytes = lastBytes
+
+	// Shuffle first so that peers of equal rates have equal chances.
+	rand.Shuffle(len(rates), func(i, j int) {
+		rates[i], rates[j] = rates[j], rates[i]
+	})
+	sort.SliceStable(rates, func(i, j int) bool {
+		return rates[i].rate > rates[j].rate
+	})
+
+	unchoke := make(map[_Chokee]bool, slots+1)
+	for i := 0; i < len(rates) && i < slots; i++ {
+		unchoke[rates[i].peer] = true
+	}
+
+	// The optimistic unchoke is replaced at once if she leaves or loses interest.
+	if _, ok := lastBytes[c.optimistic]; !ok || !c.optimistic.HerInterested() ||
+		now.Sub(c.lastOptimistic) >= optimisticInterval {
+		c.optimistic = nil
+		var candidates []_Chokee
+		for _, r := range rates {
+			if !unchoke[r.peer] {
+				candidates = append(candidates, r.peer)
+			}
+		}
+		if len(candidates) > 0 {
+			c.optimistic = candidates[rand.Intn(len(candidates))]
+			c.lastOptimistic = now
+		}
+	}
+	if c.optimistic != nil {
+		unchoke[c.optimistic] = true
+	}
+
+	for _, p := range peers {
+		switch {
+		case unchoke[p] && p.AmChoking():
+			if err := p.UnChoke(); err != nil {
+				log.Printf("choker: unchoke %s failed: %v", p.Name(), err)
+			}
+		case !unchoke[p] && !p.AmChoking():
+			if err := p.Choke(); err != nil {
+				log.Printf("choker: choke %s failed: %v", p.Name(), err)
+			}
+		}
+	}
+}
+
+// choke runs the choker periodically.
+func (t *Task) choke(ctx context.Context) {
+	ticker := time.NewTicker(chokeInterval)
+	defer ticker.Stop()
+
+	for {
+		t.mu.RLock()
+		peers := make([]_Chokee, 0, len(t.peers))
+		for _, p := range t.peers {
+			peers = append(peers, _PeerSource{p})
+		}
+		slots := t.uploadSlots
+		t.mu.RUnlock()
+
+		t.choker.rechoke(peers, slots, t.isComplete(), time.Now())
+
+		select {
+		case <-ctx.Done():
+			log.Printf("task.choke: context done")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SetUploadSlots sets the number of peers to unchoke,
+// besides the optimistic one.
+func (t *Task) SetUploadSlots(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if n < 0 {
+		n = 0
+	}
+	t.uploadSlots = n
+}
@@ -0,0 +1,168 @@
+package task
+
+import (
+	"testing"
+	"time"
+)
+
+// _FakePeer counts bytes as told, and records her choking state.
+type _FakePeer struct {
+	name       string
+	downloaded int64
+	uploaded   int64
+	interested bool
+	choking    bool
+}
+
+func (p *_FakePeer) DownloadedBytes() int64 { return p.downloaded }
+func (p *_FakePeer) UploadedBytes() int64   { return p.uploaded }
+func (p *_FakePeer) HerInterested() bool    { return p.interested }
+func (p *_FakePeer) AmChoking() bool        { return p.choking }
+func (p *_FakePeer) Choke() error           { p.choking = true; return nil }
+func (p *_FakePeer) UnChoke() error         { p.choking = false; return nil }
+func (p *_FakePeer) Name() string           { return p.name }
+
+// newTestChoker creates a choker and peers of the names, which have been
+// seen at start without interest, so that no one is unchoked yet.
+func newTestChoker(start time.Time, names ...string) (*_Choker, []*_FakePeer) {
+	c := _NewChoker()
+	peers := make([]*_FakePeer, len(names))
+	for i, name := range names {
+		peers[i] = &_FakePeer{name: name, choking: true}
+	}
+	c.rechoke(chokees(peers), 2, false, start)
+	return c, peers
+}
+
+func chokees(peers []*_FakePeer) []_Chokee {
+	list := make([]_Chokee, len(peers))
+	for i, p := range peers {
+		list[i] = p
+	}
+	return list
+}
+
+// unchoked returns the names of the unchoked peers.
+func unchoked(peers []*_FakePeer) map[string]bool {
+	names := make(map[string]bool)
+	for _, p := range peers {
+		if !p.choking {
+			names[p.name] = true
+		}
+	}
+	return names
+}
+
+func TestChokerRates(t *testing.T) {
+	for _, seeding := range []bool{false, true} {
+		start := time.Now()
+		c, peers := newTestChoker(start, `a`, `b`, `c`, `d`, `e`)
+
+		// The rate of the other direction is the opposite.
+		rates := []int64{100, 500, 300, 0, 1000}
+		for i, p := range peers {
+			p.interested = true
+			if seeding {
+				p.uploaded, p.downloaded = rates[i], 1000-rates[i]
+			} else {
+				p.downloaded, p.uploaded = rates[i], 1000-rates[i]
+			}
+		}
+		// Uninterested peers are not counted.
+		peers[4].interested = false
+
+		c.rechoke(chokees(peers), 2, seeding, start.Add(chokeInterval))
+
+		names := unchoked(peers)
+		if len(names) != 3 || !names[`b`] || !names[`c`] {
+			t.Fatalf("seeding %v: unchoked %v", seeding, names)
+		}
+		optimistic := c.optimistic.Name()
+		if optimistic != `a` && optimistic != `d` || !names[optimistic] {
+			t.Fatalf("seeding %v: optimistic %s", seeding, optimistic)
+		}
+	}
+}
+
+// newOptimisticChoker creates a choker of the peers a, b, c and d, of which
+// a and b are unchoked for their rates, and c or d is unchoked optimistically.
+func newOptimisticChoker(t *testing.T, start time.Time) (*_Choker, []*_FakePeer) {
+	c, peers := newTestChoker(start, `a`, `b`, `c`, `d`)
+	for _, p := range peers {
+		p.interested = true
+	}
+	peers[0].downloaded = 500
+	peers[1].downloaded = 300
+	c.rechoke(chokees(peers), 2, false, start.Add(chokeInterval))
+	if names := unchoked(peers); len(names) != 3 || !names[`a`] || !names[`b`] {
+		t.Fatalf("unchoked %v", names)
+	}
+	return c, peers
+}
+
+// step makes a and b download at the same rates, and rechokes at now.
+func step(c *_Choker, peers []*_FakePeer, now time.Time) {
+	peers[0].downloaded += 500
+	peers[1].downloaded += 300
+	c.rechoke(chokees(peers), 2, false, now)
+}
+
+func TestChokerOptimisticRotation(t *testing.T) {
+	start := time.Now()
+	c, peers := newOptimisticChoker(t, start)
+	optimistic := c.optimistic
+
+	for now := start.Add(2 * chokeInterval); now.Sub(start.Add(chokeInterval)) < optimisticInterval; now = now.Add(chokeInterval) {
+		step(c, peers, now)
+		if c.optimistic != optimistic || !unchoked(peers)[optimistic.Name()] {
+			t.Fatalf("optimistic changes before rotation: %s", c.optimistic.Name())
+		}
+	}
+
+	now := start.Add(chokeInterval + optimisticInterval)
+	step(c, peers, now)
+	if !c.lastOptimistic.Equal(now) {
+		t.Fatal("optimistic is not rotated")
+	}
+	if names := unchoked(peers); len(names) != 3 || !names[c.optimistic.Name()] {
+		t.Fatalf("unchoked %v", names)
+	}
+}
+
+func TestChokerOptimisticLeaves(t *testing.T) {
+	start := time.Now()
+	c, peers := newOptimisticChoker(t, start)
+	gone, other := peers[2], peers[3]
+	if c.optimistic == other {
+		gone, other = other, gone
+	}
+
+	var left []*_FakePeer
+	for _, p := range peers {
+		if p != gone {
+			left = append(left, p)
+		}
+	}
+	step(c, left, start.Add(2*chokeInterval))
+	if c.optimistic != other || other.choking {
+		t.Fatalf("optimistic is not replaced: %v", c.optimistic)
+	}
+}
+
+func TestChokerOptimisticNotInterested(t *testing.T) {
+	start := time.Now()
+	c, peers := newOptimisticChoker(t, start)
+	old, other := peers[2], peers[3]
+	if c.optimistic == other {
+		old, other = other, old
+	}
+
+	old.interested = false
+	step(c, peers, start.Add(2*chokeInterval))
+	if !old.choking {
+		t.Fatal("uninterested optimistic is still unchoked")
+	}
+	if c.optimistic != other || other.choking {
+		t.Fatalf("optimistic is not replaced: %v", c.optimistic)
+	}
+}
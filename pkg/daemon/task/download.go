@@ -0,0 +1,389 @@
+package task
+
+import (
+	"context"
+	"crypto/sha1"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/movsb/torrent/pkg/daemon/picker"
+	"github.com/movsb/torrent/pkg/message"
+	"github.com/movsb/torrent/pkg/peer"
+)
+
+const (
+	// The size of a block, the unit of requests.
+	blockSize = 16 << 10
+
+	// A request not satisfied within this duration is re-requested
+	// from other peers.
+	requestTimeout = time.Minute
+
+	// How often to check for timed out requests.
+	timeoutInterval = time.Second * 5
+
+	// In endgame mode, a block is requested from at most this many peers.
+	maxEndgameRequests = 3
+)
+
+// _Source is where blocks are downloaded from, a peer or a web seed.
+type _Source interface {
+	// CanRequest tells whether the piece can be requested from the source.
+	CanRequest(index int) bool
+
+	// CancelRequest cancels a request sent to the source.
+	CancelRequest(req message.Request) error
+
+	// Name is the name of the source in logs.
+	Name() string
+}
+
+type _Block struct {
+	received bool
+
+	// Sources the block is requested from, and when.
+	requested map[_Source]time.Time
+}
+
+// _Piece is a piece being downloaded.
+type _Piece struct {
+	peer.SinglePieceData
+	blocks   []_Block
+	received int
+}
+
+func _NewPiece(data peer.SinglePieceData) *_Piece {
+	data.Data = make([]byte, data.Length)
+	n := (data.Length + blockSize - 1) / blockSize
+	p := &_Piece{
+		SinglePieceData: data,
+		blocks:          make([]_Block, n),
+	}
+	for i := range p.blocks {
+		p.blocks[i].requested = make(map[_Source]time.Time)
+	}
+	return p
+}
+
+func (p *_Piece) request(block int) message.Request {
+	begin := block * blockSize
+	length := blockSize
+	if begin+length > p.Length {
+		length = p.Length - begin
+	}
+	return message.Request{
+		Index:  p.Index,
+		Begin:  begin,
+		Length: length,
+	}
+}
+
+// full tells whether every block is either received or requested.
+func (p *_Piece) full() bool {
+	for _, b := range p.blocks {
+		if !b.received && len(b.requested) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// _Downloader schedules block requests across the peers of a task.
+//
+// A piece in progress may be downloaded from several peers, each block
+// from one peer. Requests timed out are re-requested from other peers.
+// When all the pieces left are in progress, it goes into endgame mode,
+// in which outstanding blocks are also requested from other peers,
+// and the duplicate requests are cancelled once a block arrives.
+type _Downloader struct {
+	t *Task
+
+	mu sync.Mutex
+	// Pieces in progress, and their indexes in the order they are started.
+	pieces map[int]*_Piece
+	order  []int
+}
+
+var _ peer.Downloader = &_Downloader{}
+
+// _PeerSource is a peer as a source of blocks.
+type _PeerSource struct {
+	*peer.Peer
+}
+
+func (p _PeerSource) Name() string {
+	return p.PeerAddr
+}
+
+func _NewDownloader(t *Task) *_Downloader {
+	return &_Downloader{
+		t:      t,
+		pieces: make(map[int]*_Piece),
+	}
+}
+
+type _Cancel struct {
+	source _Source
+	req    message.Request
+}
+
+// NextRequests ...
+func (d *_Downloader) NextRequests(p *peer.Peer, n int) []message.Request {
+	return d.nextRequests(_PeerSource{p}, n)
+}
+
+// nextRequests returns at most n requests of blocks to download from p.
+func (d *_Downloader) nextRequests(p _Source, n int) []message.Request {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var reqs []message.Request
+
+	// Complete pieces in progress first.
+	for _, index := range d.order {
+		if len(reqs) >= n {
+			return reqs
+		}
+		if p.CanRequest(index) {
+			reqs = d.next(p, d.pieces[index], reqs, n, false)
+		}
+	}
+
+	for len(reqs) < n {
+		index, ok := d.t.picker.Pick(func(index int) bool {
+			return d.pieces[index] == nil && p.CanRequest(index)
+		})
+		if !ok {
+			break
+		}
+		piece := _NewPiece(d.t.pieceData(index))
+		d.pieces[index] = piece
+		d.order = append(d.order, index)
+		reqs = d.next(p, piece, reqs, n, false)
+	}
+
+	if len(reqs) < n && d.endgame() {
+		for _, index := range d.order {
+			if len(reqs) >= n {
+				break
+			}
+			if p.CanRequest(index) {
+				reqs = d.next(p, d.pieces[index], reqs, n, true)
+			}
+		}
+	}
+
+	return reqs
+}
+
+// next appends requests of blocks of the piece to reqs, at most n in total.
+// Blocks requested from others are duplicated only in endgame mode.
+func (d *_Downloader) next(p _Source, piece *_Piece, reqs []message.Request, n int, endgame bool) []message.Request {
+	now := time.Now()
+	for i := range piece.blocks {
+		if len(reqs) >= n {
+			break
+		}
+		b := &piece.blocks[i]
+		if b.received {
+			continue
+		}
+		if _, ok := b.requested[p]; ok {
+			continue
+		}
+		if endgame {
+			if len(b.requested) == 0 || len(b.requested) >= maxEndgameRequests {
+				continue
+			}
+		} else if len(b.requested) > 0 {
+			continue
+		}
+		b.requested[p] = now
+		reqs = append(reqs, piece.request(i))
+	}
+	d.updateState(piece)
+	return reqs
+}
+
+// endgame tells whether all the pieces left are in progress.
+func (d *_Downloader) endgame() bool {
+	_, ok := d.t.picker.Pick(func(index int) bool {
+		return d.pieces[index] == nil
+	})
+	return !ok
+}
+
+func (d *_Downloader) updateState(piece *_Piece) {
+	state := picker.Partial
+	if piece.full() {
+		state = picker.Busy
+	}
+	d.t.picker.SetState(piece.Index, state)
+}
+
+func (d *_Downloader) remove(index int) {
+	delete(d.pieces, index)
+	for i, x := range d.order {
+		if x == index {
+			d.order = append(d.order[:i], d.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// block returns the block the request is for, or nil if it's not wanted.
+func (d *_Downloader) block(index int, begin int, length int) (*_Piece, *_Block) {
+	piece := d.pieces[index]
+	if piece == nil || begin%blockSize != 0 {
+		return nil, nil
+	}
+	i := begin / blockSize
+	if i < 0 || i >= len(piece.blocks) || piece.request(i).Length != length {
+		return nil, nil
+	}
+	return piece, &piece.blocks[i]
+}
+
+// OnBlock ...
+func (d *_Downloader) OnBlock(p *peer.Peer, block *message.Piece) {
+	d.onBlock(p.Ctx, _PeerSource{p}, block)
+}
+
+// onBlock saves a block received from p, and the piece once complete.
+func (d *_Downloader) onBlock(ctx context.Context, p _Source, block *message.Piece) {
+	d.mu.Lock()
+
+	piece, b := d.block(block.Index, block.Begin, len(block.Data))
+	if b == nil || b.received {
+		d.mu.Unlock()
+		return
+	}
+
+	b.received = true
+	piece.received++
+	copy(piece.Data[block.Begin:], block.Data)
+
+	var cancels []_Cancel
+	for other := range b.requested {
+		if other != p {
+			cancels = append(cancels, _Cancel{
+				source: other,
+				req:    piece.request(block.Begin / blockSize),
+			})
+		}
+	}
+	b.requested = nil
+
+	var (
+		done     bool
+		verified bool
+	)
+
+	if piece.received == len(piece.blocks) {
+		d.remove(piece.Index)
+		done = true
+		verified = sha1.Sum(piece.Data) == piece.Hash
+		if !verified {
+			d.t.picker.SetState(piece.Index, picker.Missing)
+		}
+	}
+
+	d.mu.Unlock()
+
+	for _, c := range cancels {
+		if err := c.source.CancelRequest(c.req); err != nil {
+			log.Printf("task.OnBlock: cancel request to %s failed: %v", c.source.Name(), err)
+		}
+	}
+
+	if !done {
+		return
+	}
+
+	if !verified {
+		log.Printf("task.OnBlock: piece %d failed hash check", piece.Index)
+		d.t.wakePeers()
+		return
+	}
+
+	select {
+	case d.t.done <- piece.SinglePieceData:
+	case <-ctx.Done():
+	}
+}
+
+// OnDropped ...
+func (d *_Downloader) OnDropped(p *peer.Peer, requests []message.Request) {
+	d.onDropped(_PeerSource{p}, requests)
+}
+
+// onDropped makes the blocks of the requests dropped by p wanted again.
+func (d *_Downloader) onDropped(p _Source, requests []message.Request) {
+	d.mu.Lock()
+	for _, req := range requests {
+		piece, b := d.block(req.Index, req.Begin, req.Length)
+		if b == nil {
+			continue
+		}
+		delete(b.requested, p)
+		d.updateState(piece)
+	}
+	d.mu.Unlock()
+
+	d.t.wakePeers()
+}
+
+// checkTimeouts periodically cancels timed out requests,
+// so that the blocks can be requested from other peers.
+func (d *_Downloader) checkTimeouts(ctx context.Context) {
+	ticker := time.NewTicker(timeoutInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("task.checkTimeouts: context done")
+			return
+		case <-ticker.C:
+		}
+
+		d.cancelTimeouts(time.Now())
+	}
+}
+
+// cancelTimeouts cancels the requests timed out by now.
+func (d *_Downloader) cancelTimeouts(now time.Time) {
+	var cancels []_Cancel
+
+	d.mu.Lock()
+	for _, index := range d.order {
+		piece := d.pieces[index]
+		for i := range piece.blocks {
+			b := &piece.blocks[i]
+			for p, when := range b.requested {
+				if now.Sub(when) >= requestTimeout {
+					delete(b.requested, p)
+					cancels = append(cancels, _Cancel{
+						source: p,
+						req:    piece.request(i),
+					})
+				}
+			}
+		}
+		d.updateState(piece)
+	}
+	d.mu.Unlock()
+
+	if len(cancels) == 0 {
+		return
+	}
+
+	log.Printf("task.checkTimeouts: %d requests timed out", len(cancels))
+	for _, c := range cancels {
+		if err := c.source.CancelRequest(c.req); err != nil {
+			log.Printf("task.checkTimeouts: cancel request to %s failed: %v", c.source.Name(), err)
+		}
+	}
+	d.t.wakePeers()
+}
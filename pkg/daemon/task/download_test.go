@@ -0,0 +1,232 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/movsb/torrent/pkg/daemon/picker"
+	"github.com/movsb/torrent/pkg/message"
+	"github.com/movsb/torrent/pkg/peer"
+	"github.com/movsb/torrent/pkg/torrent"
+)
+
+// _FakeSource has the pieces in has, or all if has is nil,
+// and records the requests cancelled.
+type _FakeSource struct {
+	name    string
+	has     map[int]bool
+	cancels []message.Request
+}
+
+func (s *_FakeSource) CanRequest(index int) bool {
+	return s.has == nil || s.has[index]
+}
+
+func (s *_FakeSource) CancelRequest(req message.Request) error {
+	s.cancels = append(s.cancels, req)
+	return nil
+}
+
+func (s *_FakeSource) Name() string {
+	return s.name
+}
+
+// newTestDownloader creates a downloader of a task of n pieces of two blocks,
+// and returns the data of the task.
+func newTestDownloader(n int) (*_Downloader, []byte) {
+	const pieceLength = 2 * blockSize
+	data := make([]byte, n*pieceLength-100)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	var hashes []byte
+	for i := 0; i < len(data); i += pieceLength {
+		end := i + pieceLength
+		if end > len(data) {
+			end = len(data)
+		}
+		sum := sha1.Sum(data[i:end])
+		hashes = append(hashes, sum[:]...)
+	}
+	t := &Task{
+		File: &torrent.File{
+			Length:      int64(len(data)),
+			PieceLength: pieceLength,
+			PieceHashes: hashes,
+		},
+		picker: picker.NewRarestFirst(n, 1),
+		done:   make(chan peer.SinglePieceData, n),
+	}
+	t.downloader = _NewDownloader(t)
+	return t.downloader, data
+}
+
+// blockOf returns the block of the data the request is for.
+func blockOf(data []byte, req message.Request) *message.Piece {
+	begin := req.Index*2*blockSize + req.Begin
+	return &message.Piece{
+		Index: req.Index,
+		Begin: req.Begin,
+		Data:  data[begin : begin+req.Length],
+	}
+}
+
+func TestNextRequests(t *testing.T) {
+	d, _ := newTestDownloader(3)
+	a := &_FakeSource{name: `a`, has: map[int]bool{1: true}}
+	b := &_FakeSource{name: `b`}
+
+	if reqs := d.nextRequests(a, 1); !reflect.DeepEqual(reqs, []message.Request{{Index: 1, Begin: 0, Length: blockSize}}) {
+		t.Fatalf("a: %v", reqs)
+	}
+	if d.t.picker.State(1) != picker.Partial {
+		t.Fatalf("state: %v", d.t.picker.State(1))
+	}
+
+	// Pieces in progress are completed first, and then new ones are started.
+	reqs := d.nextRequests(b, 3)
+	if len(reqs) != 3 || reqs[0] != (message.Request{Index: 1, Begin: blockSize, Length: blockSize}) {
+		t.Fatalf("b: %v", reqs)
+	}
+	if reqs[1].Index == 1 || reqs[1].Index != reqs[2].Index || reqs[1].Begin != 0 || reqs[2].Begin != blockSize {
+		t.Fatalf("b: %v", reqs)
+	}
+	if d.t.picker.State(1) != picker.Busy || d.t.picker.State(reqs[1].Index) != picker.Busy {
+		t.Fatal("pieces requested fully should be busy")
+	}
+
+	// Nothing but piece 1 for a, which is requested fully.
+	if reqs := d.nextRequests(a, 1); len(reqs) != 0 {
+		t.Fatalf("a: %v", reqs)
+	}
+}
+
+func TestEndgame(t *testing.T) {
+	d, data := newTestDownloader(1)
+	sources := []*_FakeSource{{name: `a`}, {name: `b`}, {name: `c`}, {name: `d`}}
+
+	for i, s := range sources {
+		reqs := d.nextRequests(s, 2)
+		// A block is requested from at most maxEndgameRequests sources.
+		want := 2
+		if i >= maxEndgameRequests {
+			want = 0
+		}
+		if len(reqs) != want {
+			t.Fatalf("%s: %v", s.name, reqs)
+		}
+	}
+
+	req0 := message.Request{Index: 0, Begin: 0, Length: blockSize}
+	req1 := message.Request{Index: 0, Begin: blockSize, Length: len(data) - blockSize}
+
+	// Duplicate requests are cancelled once the block arrives.
+	d.onBlock(context.Background(), sources[1], blockOf(data, req0))
+	for i, s := range sources {
+		var want []message.Request
+		if i == 0 || i == 2 {
+			want = []message.Request{req0}
+		}
+		if !reflect.DeepEqual(s.cancels, want) {
+			t.Fatalf("%s cancels: %v", s.name, s.cancels)
+		}
+	}
+
+	// Received twice.
+	d.onBlock(context.Background(), sources[0], blockOf(data, req0))
+	if len(sources[1].cancels) != 0 {
+		t.Fatalf("b cancels: %v", sources[1].cancels)
+	}
+
+	d.onBlock(context.Background(), sources[0], blockOf(data, req1))
+	select {
+	case piece := <-d.t.done:
+		if piece.Index != 0 || !bytes.Equal(piece.Data, data) {
+			t.Fatal("piece mismatch")
+		}
+	default:
+		t.Fatal("piece not done")
+	}
+	if len(d.pieces) != 0 || len(d.order) != 0 {
+		t.Fatal("piece not removed")
+	}
+}
+
+func TestHashFailure(t *testing.T) {
+	d, data := newTestDownloader(1)
+	a := &_FakeSource{name: `a`}
+	reqs := d.nextRequests(a, 2)
+	if len(reqs) != 2 {
+		t.Fatalf("reqs: %v", reqs)
+	}
+	for _, req := range reqs {
+		block := blockOf(data, req)
+		block.Data = make([]byte, req.Length)
+		d.onBlock(context.Background(), a, block)
+	}
+	if len(d.t.done) != 0 {
+		t.Fatal("corrupted piece done")
+	}
+	if d.t.picker.State(0) != picker.Missing {
+		t.Fatalf("state: %v", d.t.picker.State(0))
+	}
+	if reqs := d.nextRequests(a, 2); len(reqs) != 2 {
+		t.Fatalf("not re-requested: %v", reqs)
+	}
+}
+
+func TestOnDropped(t *testing.T) {
+	d, _ := newTestDownloader(2)
+	a := &_FakeSource{name: `a`, has: map[int]bool{0: true}}
+	b := &_FakeSource{name: `b`, has: map[int]bool{0: true}}
+
+	reqs := d.nextRequests(a, 2)
+	if len(reqs) != 2 {
+		t.Fatalf("a: %v", reqs)
+	}
+	if got := d.nextRequests(b, 2); len(got) != 0 {
+		t.Fatalf("b: %v", got)
+	}
+
+	d.onDropped(a, reqs[:1])
+	if d.t.picker.State(0) != picker.Partial {
+		t.Fatalf("state: %v", d.t.picker.State(0))
+	}
+	if got := d.nextRequests(b, 2); !reflect.DeepEqual(got, reqs[:1]) {
+		t.Fatalf("b: %v", got)
+	}
+
+	// Requests not wanted are ignored.
+	d.onDropped(a, []message.Request{{Index: 1, Begin: 0, Length: blockSize}})
+}
+
+func TestCancelTimeouts(t *testing.T) {
+	d, _ := newTestDownloader(2)
+	a := &_FakeSource{name: `a`, has: map[int]bool{0: true}}
+	b := &_FakeSource{name: `b`, has: map[int]bool{0: true}}
+
+	reqs := d.nextRequests(a, 2)
+	if len(reqs) != 2 {
+		t.Fatalf("a: %v", reqs)
+	}
+
+	d.cancelTimeouts(time.Now())
+	if len(a.cancels) != 0 {
+		t.Fatalf("cancelled before timeout: %v", a.cancels)
+	}
+
+	d.cancelTimeouts(time.Now().Add(requestTimeout))
+	if !reflect.DeepEqual(a.cancels, reqs) {
+		t.Fatalf("cancels: %v", a.cancels)
+	}
+	if d.t.picker.State(0) != picker.Partial {
+		t.Fatalf("state: %v", d.t.picker.State(0))
+	}
+	if got := d.nextRequests(b, 2); !reflect.DeepEqual(got, reqs) {
+		t.Fatalf("b: %v", got)
+	}
+}
@@ -3,30 +3,111 @@ package task
 import (
 	"context"
 	"fmt"
+	"math/rand"
+	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/movsb/torrent/pkg/common"
 	"github.com/movsb/torrent/pkg/daemon/store"
-	"github.com/movsb/torrent/pkg/message"
+	"github.com/movsb/torrent/pkg/magnet"
 	"github.com/movsb/torrent/pkg/peer"
 	"github.com/movsb/torrent/pkg/seeder"
 	"github.com/movsb/torrent/pkg/torrent"
 )
 
+// How long to wait for trackers to be told tasks are stopped, on close.
+const stopAnnounceTimeout = time.Second * 5
+
 // Manager ...
 type Manager struct {
 	mu    sync.RWMutex
 	tasks map[common.Hash]*Task
+
+	// Where resume files are saved, empty to disable.
+	resumeDir string
+
+	// NewStorage, if set, creates the storage for the files of new tasks,
+	// instead of saving them on disk. It is called with the save path.
+	NewStorage func(tf *torrent.File, savePath string) store.Storage
+
+	// If set, files of new tasks are moved here once completed.
+	CompletedDir string
+
+	// Put the files of multi-file torrents in the save path directly,
+	// instead of a directory named after the torrent.
+	NoRootFolder bool
+
+	// The port we accept peers on, announced to trackers and peers.
+	ListenPort int
+
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
-// NewManager ...
-func NewManager() *Manager {
+// NewManager creates a manager that saves the resume files of
+// its tasks in resumeDir, or nowhere if it is empty.
+func NewManager(resumeDir string) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
 	m := &Manager{
-		tasks: make(map[common.Hash]*Task),
+		tasks:     make(map[common.Hash]*Task),
+		resumeDir: resumeDir,
+		ctx:       ctx,
+		cancel:    cancel,
 	}
 	return m
 }
 
+// Close stops all tasks, tells trackers they are stopped,
+// and saves their resume data.
+func (t *Manager) Close() error {
+	t.cancel()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), stopAnnounceTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, task := range t.tasks {
+		wg.Add(1)
+		go func(task *Task) {
+			defer wg.Done()
+			task.announceStopped(ctx)
+		}(task)
+	}
+	wg.Wait()
+
+	for _, task := range t.tasks {
+		task.saveResume()
+		task.mu.RLock()
+		pm := task.PM
+		task.mu.RUnlock()
+		if pm != nil {
+			pm.Close()
+		}
+	}
+	return nil
+}
+
+// Task returns the task of the info hash, or nil if there is none.
+func (t *Manager) Task(ih common.Hash) *Task {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tasks[ih]
+}
+
+// Tasks returns all tasks.
+func (t *Manager) Tasks() []*Task {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	tasks := make([]*Task, 0, len(t.tasks))
+	for _, task := range t.tasks {
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
+
 // AddClient ...
 func (t *Manager) AddClient(ih common.Hash, client *peer.Peer) {
 	t.mu.Lock()
@@ -41,41 +122,88 @@ func (t *Manager) LoadTorrent(ih common.Hash) (*seeder.LoadInfo, error) {
 	defer t.mu.Unlock()
 
 	if task, ok := t.tasks[ih]; ok {
+		task.mu.RLock()
+		defer task.mu.RUnlock()
+		if task.File == nil {
+			return nil, fmt.Errorf("metadata is not ready")
+		}
 		return &seeder.LoadInfo{
 			TF: task.File,
 			PM: task.PM,
 			BF: task.BitField,
+			EX: task.extensions,
 		}, nil
 	}
 
 	return nil, fmt.Errorf("no such task")
 }
 
-func (t *Manager) CreateTask(file string, savePath string, bf byte) {
+// CreateTask creates a task from a torrent file or a magnet link.
+// The progress is resumed from the resume file, if there is one.
+// Files are prioritized by selector, if it's not nil, once the progress
+// is resumed, or by the priorities saved in the resume file otherwise.
+func (t *Manager) CreateTask(file string, savePath string, selector FileSelector) (*Task, error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	tf, err := torrent.ParseFile(file)
-	if err != nil {
-		panic(err)
+	task := &Task{
+		ctx:           context.TODO(),
+		savePath:      savePath,
+		completedPath: t.CompletedDir,
+		noRootFolder:  t.NoRootFolder,
+		listenPort:    t.ListenPort,
+		key:           rand.Uint32(),
+		newStorage:    t.NewStorage,
+		selector:      selector,
+		choker:        _NewChoker(),
+		uploadSlots:   defaultUploadSlots,
+
+		peers:   make(map[string]*peer.Peer),
+		dialing: make(map[string]bool),
+		dialed:  make(map[string]time.Time),
+		seen:    make(map[string]bool),
+		readers: make(map[*Reader]bool),
+		pieceCh: make(chan struct{}),
+		done:    make(chan peer.SinglePieceData),
 	}
 
-	if _, ok := t.tasks[tf.InfoHash()]; ok {
-		fmt.Printf("task exists\n")
-		return
+	task.downloader = _NewDownloader(task)
+
+	var tf *torrent.File
+
+	if magnet.IsMagnet(file) {
+		m, err := magnet.Parse(file)
+		if err != nil {
+			return nil, err
+		}
+		task.InfoHash = m.InfoHash
+		task.Magnet = m
+	} else {
+		var err error
+		tf, err = torrent.ParseFile(file)
+		if err != nil {
+			return nil, err
+		}
+		task.InfoHash = tf.InfoHash()
 	}
 
-	task := &Task{
-		File:     tf,
-		InfoHash: tf.InfoHash(),
-		BitField: message.NewBitField(tf.PieceHashes.Count(), bf),
-		PM:       store.NewPieceManager(tf),
+	if _, ok := t.tasks[task.InfoHash]; ok {
+		return nil, fmt.Errorf("task exists")
+	}
 
-		busyPeers: make(map[string]*peer.Peer),
-		idlePeers: make(map[string]*peer.Peer),
+	if t.resumeDir != `` {
+		task.resumePath = filepath.Join(t.resumeDir, task.InfoHash.String()+`.resume`)
 	}
 
-	t.tasks[tf.InfoHash()] = task
+	if task.Magnet == nil {
+		if err := task.setFile(tf); err != nil {
+			return nil, err
+		}
+	}
+
+	t.tasks[task.InfoHash] = task
+
+	go task.Run(t.ctx)
 
-	go task.Run(context.TODO())
+	return task, nil
 }
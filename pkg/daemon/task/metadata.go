@@ -0,0 +1,71 @@
+package task
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/movsb/torrent/pkg/metadata"
+	"github.com/movsb/torrent/pkg/torrent"
+	trackercommon "github.com/movsb/torrent/pkg/tracker/common"
+)
+
+// fetchMetadata fetches the info dictionary for tasks created from
+// magnet links. Peers come from the trackers and the peer addresses
+// in the magnet link. It retries every minute until it succeeds.
+func (t *Task) fetchMetadata(ctx context.Context) error {
+	log.Printf("task.fetchMetadata: %s", t.InfoHash)
+
+	f := metadata.Fetcher{
+		InfoHash: t.InfoHash,
+		MyPeerID: trackercommon.MyPeerID,
+	}
+
+	announce := ``
+	if len(t.Magnet.Trackers) > 0 {
+		announce = t.Magnet.Trackers[0]
+	}
+
+	for {
+		seen := make(map[string]bool)
+		var peers []string
+		add := func(list []string) {
+			for _, p := range list {
+				if !seen[p] {
+					seen[p] = true
+					peers = append(peers, p)
+				}
+			}
+		}
+
+		add(t.Magnet.Peers)
+		for _, address := range t.Magnet.Trackers {
+			result, err := t.announceOne(ctx, address, trackercommon.EventNone)
+			if err != nil {
+				continue
+			}
+			add(result.peers)
+		}
+
+		info, err := f.Fetch(ctx, peers)
+		if err == nil {
+			tf, err := torrent.ParseInfo(info, announce)
+			if err != nil {
+				return err
+			}
+			if err := t.setFile(tf); err != nil {
+				return err
+			}
+			log.Printf("task.fetchMetadata: got metadata: %s", tf.Name)
+			return nil
+		}
+
+		log.Printf("task.fetchMetadata: %v, retry later", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Minute):
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package task
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/movsb/torrent/pkg/peer"
+	"github.com/movsb/torrent/pkg/pex"
+)
+
+// BEP 11 requires sending PEX messages at most once per minute.
+const pexInterval = time.Minute
+
+// exchangePeers periodically tells every peer that supports PEX
+// the changes of the peers we are connected to.
+func (t *Task) exchangePeers(ctx context.Context) {
+	ticker := time.NewTicker(pexInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("task.exchangePeers: context done")
+			return
+		case <-ticker.C:
+		}
+
+		var (
+			peers     []*peer.Peer
+			connected []pex.Peer
+		)
+
+		t.mu.RLock()
+		for _, p := range t.peers {
+			peers = append(peers, p)
+			address := p.ListenAddr()
+			if address == `` {
+				continue
+			}
+			var flags byte
+			if !p.Incoming {
+				flags |= pex.FlagReachable
+			}
+			if p.HerBitField != nil && p.HerBitField.AllOnes() {
+				flags |= pex.FlagSeed
+			}
+			connected = append(connected, pex.Peer{
+				Address: address,
+				Flags:   flags,
+			})
+		}
+		t.mu.RUnlock()
+
+		for _, p := range peers {
+			if err := t.pex.Update(p, connected); err != nil {
+				log.Printf("task.exchangePeers: %s: %v", p.PeerAddr, err)
+			}
+		}
+	}
+}
@@ -1,50 +1,56 @@
 package task
 
 import (
-	"container/list"
 	"context"
 	"fmt"
 	"log"
 	"time"
 
+	"github.com/movsb/torrent/pkg/daemon/picker"
 	"github.com/movsb/torrent/pkg/peer"
 )
 
+// initPieces initializes the piece picker with the pieces we have.
 func (t *Task) initPieces() {
-	list := list.New()
 	nPieces := t.File.PieceHashes.Count()
-	remain := int(t.File.Length % int64(t.File.PieceLength))
-
+	p := picker.NewRarestFirst(nPieces, time.Now().UnixNano())
 	for i := 0; i < nPieces; i++ {
-		length := t.File.PieceLength
-		if i == nPieces-1 && remain != 0 {
-			length = remain
-		}
-
-		piece := peer.SinglePieceData{
-			Index:  i,
-			Hash:   t.File.PieceHashes.Index(i),
-			Length: length,
+		if t.BitField.HasPiece(i) {
+			p.SetState(i, picker.Done)
 		}
+	}
+	t.picker = p
+}
 
-		list.PushBack(piece)
+// pieceData returns the piece to download.
+func (t *Task) pieceData(index int) peer.SinglePieceData {
+	nPieces := t.File.PieceHashes.Count()
+	remain := int(t.File.Length % int64(t.File.PieceLength))
 
-		//if i >= 10 {
-		//	break
-		//}
+	length := t.File.PieceLength
+	if index == nPieces-1 && remain != 0 {
+		length = remain
 	}
 
-	t.pieces = list
-	t.done = make(chan peer.SinglePieceData)
+	return peer.SinglePieceData{
+		Index:  index,
+		Hash:   t.File.PieceHashes.Index(index),
+		Length: length,
+	}
 }
 
 func (t *Task) savePiece(ctx context.Context) {
 	donePieces := 0
 	nPieces := t.File.PieceHashes.Count()
+	for i := 0; i < nPieces; i++ {
+		if t.BitField.HasPiece(i) {
+			donePieces++
+		}
+	}
 
 	lastTime := time.Now()
 	lastSpeed := float64(0)
-	lastDonePieces := 0
+	lastDonePieces := donePieces
 
 	speed := func() {
 		now := time.Now()
@@ -69,15 +75,18 @@ func (t *Task) savePiece(ctx context.Context) {
 
 		donePieces++
 		percent := float64(donePieces) / float64(t.File.PieceHashes.Count()) * 100
-		fmt.Printf("%0.2f piece downloaded, piece: %d / %d, size: %d / %d, speed: %s, idle: %d, busy: %d\n",
+		fmt.Printf("%0.2f piece downloaded, piece: %d / %d, size: %d / %d, speed: %s, peers: %d\n",
 			percent, donePieces, nPieces,
 			donePieces*t.File.PieceLength, t.File.Length,
 			speedString,
-			len(t.idlePeers), len(t.busyPeers),
+			len(t.peers),
 		)
 	}
 
 	save := func(piece peer.SinglePieceData) bool {
+		t.saveMu.Lock()
+		defer t.saveMu.Unlock()
+
 		if t.BitField.HasPiece(piece.Index) {
 			log.Printf("task.savePiece: duplicate piece: %d", piece.Index)
 			return false
@@ -85,31 +94,16 @@ func (t *Task) savePiece(ctx context.Context) {
 		err := t.PM.WritePiece(piece.Index, piece.Data)
 		if err != nil {
 			log.Printf("WritePiece failed: %s", err)
+			t.picker.SetState(piece.Index, picker.Missing)
+			t.wakePeers()
 			return false
 		}
 
 		t.BitField.SetPiece(piece.Index)
+		t.picker.SetState(piece.Index, picker.Done)
+		t.notifyPieces()
 
-		go func(index int) {
-			t.mu.RLock()
-			defer t.mu.RUnlock()
-
-			for _, client := range t.busyPeers {
-				select {
-				case client.HaveCh <- index:
-				default:
-					log.Printf("task.savePiece: failed to send have to peer: %s", client.PeerAddr)
-				}
-			}
-
-			for _, client := range t.idlePeers {
-				select {
-				case client.HaveCh <- index:
-				default:
-					log.Printf("task.savePiece: failed to send have to peer: %s", client.PeerAddr)
-				}
-			}
-		}(piece.Index)
+		go t.broadcastHave(piece.Index)
 
 		speed()
 		return true
@@ -119,11 +113,56 @@ func (t *Task) savePiece(ctx context.Context) {
 		select {
 		case <-ctx.Done():
 			log.Printf("task.savePiece: context done")
+			return
 		case piece := <-t.done:
-			if save(piece) && donePieces == nPieces {
+			// Keep saving, as skipped files may be wanted later.
+			if save(piece) && t.isComplete() {
 				log.Printf("task.savePiece: task done")
-				return
+				t.complete()
+				t.announceCompleted()
 			}
 		}
 	}
 }
+
+// broadcastHave tells all peers that we have a new piece.
+func (t *Task) broadcastHave(index int) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, client := range t.peers {
+		select {
+		case client.HaveCh <- index:
+		default:
+			log.Printf("task.broadcastHave: failed to send have to peer: %s", client.PeerAddr)
+		}
+	}
+}
+
+// waitPiece waits until the piece is downloaded.
+func (t *Task) waitPiece(ctx context.Context, index int) error {
+	for {
+		// Take the channel before checking, so that no piece is missed.
+		t.mu.RLock()
+		ch := t.pieceCh
+		t.mu.RUnlock()
+
+		if t.BitField.HasPiece(index) {
+			return nil
+		}
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// notifyPieces wakes up those waiting for pieces.
+func (t *Task) notifyPieces() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	close(t.pieceCh)
+	t.pieceCh = make(chan struct{})
+}
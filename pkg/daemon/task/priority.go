@@ -0,0 +1,131 @@
+package task
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/movsb/torrent/pkg/daemon/picker"
+)
+
+// FileSelector returns the priority of a file by its path in the torrent,
+// with segments joined by slashes.
+type FileSelector func(path string) picker.Priority
+
+// SetFilePriority sets the priority of a file, and so of the pieces it spans.
+func (t *Task) SetFilePriority(index int, priority picker.Priority) error {
+	t.saveMu.Lock()
+	defer t.saveMu.Unlock()
+
+	t.mu.Lock()
+	if t.File == nil {
+		t.mu.Unlock()
+		return fmt.Errorf("task.SetFilePriority: metadata is not ready")
+	}
+	if index < 0 || index >= len(t.priorities) {
+		t.mu.Unlock()
+		return fmt.Errorf("task.SetFilePriority: invalid index %d", index)
+	}
+	t.priorities[index] = priority
+	t.mu.Unlock()
+
+	t.applyPriorities()
+	t.wakePeers()
+	return nil
+}
+
+// FilePriorities returns the priorities of files.
+func (t *Task) FilePriorities() []picker.Priority {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return append([]picker.Priority(nil), t.priorities...)
+}
+
+// initPriorities initializes the priorities of files to those saved,
+// which tell where the data of the files are, and nothing is moved.
+// The selector is applied once the pieces are loaded. t.mu must be held.
+func (t *Task) initPriorities(saved []int) {
+	none := func(int) bool { return false }
+	t.priorities = make([]picker.Priority, len(t.File.Files))
+	for i := range t.priorities {
+		t.priorities[i] = picker.Normal
+		if len(saved) == len(t.priorities) {
+			t.priorities[i] = picker.Priority(saved[i])
+		}
+		if err := t.PM.SetSkipped(i, t.priorities[i] == picker.Skip, none); err != nil {
+			log.Printf("task.initPriorities: %v", err)
+		}
+	}
+	t.prioritizePieces()
+}
+
+// selectFiles sets the priorities of files by the selector, if any,
+// once the pieces are loaded, and before they are saved.
+func (t *Task) selectFiles() {
+	t.saveMu.Lock()
+	defer t.saveMu.Unlock()
+
+	t.mu.Lock()
+	if t.selector == nil {
+		t.mu.Unlock()
+		return
+	}
+	for i, file := range t.File.Files {
+		t.priorities[i] = t.selector(strings.Join(file.Paths, `/`))
+	}
+	t.mu.Unlock()
+
+	t.applyPriorities()
+}
+
+// applyPriorities applies the priorities of files to the storage, where
+// the pieces downloaded are moved, and to the pieces. t.saveMu must be
+// held, so that no pieces are saved meanwhile, and t.mu must not be.
+func (t *Task) applyPriorities() {
+	t.mu.RLock()
+	pm := t.PM
+	priorities := append([]picker.Priority(nil), t.priorities...)
+	t.mu.RUnlock()
+
+	for i, priority := range priorities {
+		if err := pm.SetSkipped(i, priority == picker.Skip, t.BitField.HasPiece); err != nil {
+			log.Printf("task.applyPriorities: %v", err)
+		}
+	}
+
+	t.mu.Lock()
+	t.prioritizePieces()
+	t.mu.Unlock()
+}
+
+// prioritizePieces maps the priorities of files to pieces. A piece has the
+// highest priority of the files it spans, so pieces straddling skipped and
+// wanted files are downloaded, and the skipped parts go to the part file.
+// Pieces that readers are about to read are urgent. t.mu must be held.
+func (t *Task) prioritizePieces() {
+	for i := 0; i < t.File.PieceHashes.Count(); i++ {
+		priority := picker.Skip
+		for _, file := range t.PM.PieceFiles(i) {
+			if t.priorities[file] > priority {
+				priority = t.priorities[file]
+			}
+		}
+		for r := range t.readers {
+			if i >= r.first && i <= r.last {
+				priority = picker.Urgent
+				break
+			}
+		}
+		t.picker.SetPriority(i, priority)
+	}
+}
+
+// isComplete tells whether all wanted pieces are downloaded.
+func (t *Task) isComplete() bool {
+	for i := 0; i < t.File.PieceHashes.Count(); i++ {
+		if !t.BitField.HasPiece(i) && t.picker.Priority(i) != picker.Skip {
+			return false
+		}
+	}
+	return true
+}
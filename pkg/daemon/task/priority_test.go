@@ -0,0 +1,80 @@
+package task_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/movsb/torrent/pkg/daemon/picker"
+	"github.com/movsb/torrent/pkg/daemon/task"
+	"github.com/movsb/torrent/pkg/daemon/task/tasktest"
+	"github.com/movsb/torrent/pkg/torrent/torrenttest"
+)
+
+// Pieces of files skipped last time are in the part file, and are moved
+// back once the files are wanted again on resume.
+func TestResumeWithOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, `files`)
+	b := bytes.Repeat([]byte(`0123456789`), 30000)
+	torrenttest.WriteFiles(t, root, map[string][]byte{
+		`a.bin`: bytes.Repeat([]byte(`abcdefghij`), 30000),
+		`b.bin`: b,
+	})
+	tp := filepath.Join(dir, `files.torrent`)
+	torrenttest.Create(t, root, tp)
+	resumeDir := filepath.Join(dir, `resume`)
+	if err := os.Mkdir(resumeDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	m := task.NewManager(resumeDir)
+	tk, err := m.CreateTask(tp, dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tasktest.WaitComplete(t, tk, 10*time.Second)
+	index := -1
+	for i, f := range tk.Metadata().Files {
+		if strings.Join(f.Paths, `/`) == `b.bin` {
+			index = i
+		}
+	}
+	if err := tk.SetFilePriority(index, picker.Skip); err != nil {
+		t.Fatal(err)
+	}
+	m.Close()
+
+	// Garbage in the skipped file, which is unchanged to the resume data.
+	path := filepath.Join(root, `b.bin`)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, make([]byte, len(b)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	m = task.NewManager(resumeDir)
+	defer m.Close()
+	all := func(string) picker.Priority { return picker.Normal }
+	if _, err := m.CreateTask(tp, dir, all); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		if got, _ := ioutil.ReadFile(path); bytes.Equal(got, b) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("pieces are not moved back")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
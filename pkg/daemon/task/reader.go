@@ -0,0 +1,232 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// defaultReadahead is how many bytes after the read position
+// are downloaded first.
+const defaultReadahead = 8 << 20
+
+// ErrNotDownloaded is returned by non-blocking reads of pieces
+// not downloaded yet.
+var ErrNotDownloaded = errors.New("task: piece is not downloaded yet")
+
+// Reader reads a file of a task while it's being downloaded, e.g.
+// to play a video before it completes. Reads block until the pieces
+// are downloaded, and the pieces from the read position on, in the
+// readahead window, are downloaded first, in order.
+type Reader struct {
+	t      *Task
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// The offset of the file in the torrent, and its length.
+	offset int64
+	length int64
+
+	mu        sync.Mutex
+	pos       int64
+	readahead int64
+	closed    bool
+
+	// Reads fail with ErrNotDownloaded, instead of blocking.
+	nonBlocking bool
+
+	// The last piece read.
+	index int
+	piece []byte
+
+	// The pieces in the readahead window, guarded by both r.mu and t.mu.
+	first, last int
+}
+
+var _ io.ReadSeekCloser = &Reader{}
+
+// NewReader opens the file of the index for streaming.
+// The reader must be closed, so that pieces are no longer urgent.
+func (t *Task) NewReader(file int) (*Reader, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.File == nil {
+		return nil, fmt.Errorf("task.NewReader: metadata is not ready")
+	}
+	if file < 0 || file >= len(t.File.Files) {
+		return nil, fmt.Errorf("task.NewReader: invalid index %d", file)
+	}
+
+	var offset int64
+	for i := 0; i < file; i++ {
+		offset += t.File.Files[i].Length
+	}
+
+	ctx, cancel := context.WithCancel(t.ctx)
+	r := &Reader{
+		t:         t,
+		ctx:       ctx,
+		cancel:    cancel,
+		offset:    offset,
+		length:    t.File.Files[file].Length,
+		readahead: defaultReadahead,
+		index:     -1,
+	}
+	r.first, r.last = r.window()
+
+	t.readers[r] = true
+	t.prioritizePieces()
+	go t.wakePeers()
+
+	return r, nil
+}
+
+// SetReadahead sets how many bytes after the read position
+// are downloaded first.
+func (r *Reader) SetReadahead(n int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.readahead = n
+	r.prioritize()
+}
+
+// SetNonBlocking sets whether reads of pieces not downloaded yet fail
+// with ErrNotDownloaded, instead of blocking. They are downloaded first
+// anyway.
+func (r *Reader) SetNonBlocking(nonBlocking bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nonBlocking = nonBlocking
+}
+
+// Read reads the file, and blocks until the pieces are downloaded.
+func (r *Reader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+	if r.pos >= r.length {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if remain := r.length - r.pos; int64(len(p)) > remain {
+		p = p[:remain]
+	}
+
+	pieceLength := int64(r.t.File.PieceLength)
+	offset := r.offset + r.pos
+	index := int(offset / pieceLength)
+
+	if index != r.index {
+		if r.nonBlocking && !r.t.BitField.HasPiece(index) {
+			return 0, ErrNotDownloaded
+		}
+		if err := r.t.waitPiece(r.ctx, index); err != nil {
+			return 0, err
+		}
+		piece, err := r.t.PM.ReadPiece(index)
+		if err != nil {
+			return 0, fmt.Errorf("task.Reader: %v", err)
+		}
+		r.index, r.piece = index, piece
+	}
+
+	n := copy(p, r.piece[offset-int64(index)*pieceLength:])
+	r.pos += int64(n)
+	r.prioritize()
+
+	return n, nil
+}
+
+// Seek sets the read position, and the pieces after it are downloaded first.
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.length
+	default:
+		return 0, errors.New("task.Reader.Seek: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("task.Reader.Seek: negative position")
+	}
+
+	r.pos = offset
+	r.prioritize()
+
+	return offset, nil
+}
+
+// Close closes the reader, and blocked reads return.
+func (r *Reader) Close() error {
+	r.cancel()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	r.piece = nil
+
+	r.t.mu.Lock()
+	delete(r.t.readers, r)
+	r.t.prioritizePieces()
+	r.t.mu.Unlock()
+
+	return nil
+}
+
+// window returns the first and the last piece in the readahead window.
+// The window is empty if the last is before the first.
+func (r *Reader) window() (int, int) {
+	if r.pos >= r.length {
+		return 0, -1
+	}
+
+	start := r.offset + r.pos
+	end := start + r.readahead
+	if end > r.offset+r.length {
+		end = r.offset + r.length
+	}
+	if end <= start {
+		end = start + 1
+	}
+
+	pieceLength := int64(r.t.File.PieceLength)
+	return int(start / pieceLength), int((end - 1) / pieceLength)
+}
+
+// prioritize makes the pieces in the readahead window urgent,
+// if it has changed. r.mu must be held.
+func (r *Reader) prioritize() {
+	first, last := r.window()
+	if first == r.first && last == r.last {
+		return
+	}
+
+	r.t.mu.Lock()
+	r.first, r.last = first, last
+	r.t.prioritizePieces()
+	r.t.mu.Unlock()
+
+	r.t.wakePeers()
+}
@@ -0,0 +1,200 @@
+package task
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/movsb/torrent/pkg/daemon/picker"
+	"github.com/movsb/torrent/pkg/daemon/store"
+	"github.com/movsb/torrent/pkg/torrent"
+	"github.com/movsb/torrent/pkg/torrent/torrenttest"
+)
+
+// newMemoryTask creates a task of a file of several pieces, stored in memory,
+// with nothing downloaded, and returns the data of the file.
+func newMemoryTask(t *testing.T) (*Task, []byte) {
+	dir := t.TempDir()
+	data := make([]byte, 1<<20+100)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	torrenttest.WriteFiles(t, dir, map[string][]byte{`a.bin`: data})
+	tp := filepath.Join(dir, `a.torrent`)
+	torrenttest.Create(t, filepath.Join(dir, `a.bin`), tp)
+
+	m := NewManager(``)
+	m.NewStorage = func(tf *torrent.File, savePath string) store.Storage {
+		return store.NewMemoryStorage(tf)
+	}
+	t.Cleanup(func() { m.Close() })
+	tk, err := m.CreateTask(tp, dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tk, data
+}
+
+// download makes the task download the piece.
+func download(tk *Task, data []byte, index int) {
+	piece := tk.pieceData(index)
+	begin := index * tk.File.PieceLength
+	piece.Data = data[begin : begin+piece.Length]
+	tk.done <- piece
+}
+
+// readAsync reads n bytes in the background.
+func readAsync(r io.Reader, n int) <-chan error {
+	ch := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(r, make([]byte, n))
+		ch <- err
+	}()
+	return ch
+}
+
+func TestReaderRead(t *testing.T) {
+	tk, data := newMemoryTask(t)
+	pieceLength := tk.File.PieceLength
+
+	r, err := tk.NewReader(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// Blocks until the pieces are downloaded.
+	done := readAsync(r, pieceLength+10)
+	download(tk, data, 0)
+	select {
+	case err := <-done:
+		t.Fatalf("read returns before the second piece is downloaded: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	download(tk, data, 1)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	last := tk.File.PieceHashes.Count() - 1
+	for i := 2; i <= last; i++ {
+		download(tk, data, i)
+	}
+	if _, err := r.Seek(-20, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(b, data[len(data)-20:]) {
+		t.Fatalf("read the end: %v", err)
+	}
+}
+
+func TestReaderNonBlocking(t *testing.T) {
+	tk, data := newMemoryTask(t)
+
+	r, err := tk.NewReader(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.SetNonBlocking(true)
+
+	b := make([]byte, 10)
+	if _, err := r.Read(b); !errors.Is(err, ErrNotDownloaded) {
+		t.Fatalf("want ErrNotDownloaded, got %v", err)
+	}
+	download(tk, data, 0)
+	if err := tk.waitPiece(r.ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(b); err != nil || !bytes.Equal(b, data[:10]) {
+		t.Fatalf("read: %v", err)
+	}
+}
+
+func TestReaderReadahead(t *testing.T) {
+	tk, _ := newMemoryTask(t)
+	pieceLength := int64(tk.File.PieceLength)
+	count := tk.File.PieceHashes.Count()
+
+	urgent := func(pieces ...int) {
+		t.Helper()
+		want := make(map[int]bool)
+		for _, i := range pieces {
+			want[i] = true
+		}
+		for i := 0; i < count; i++ {
+			if got := tk.picker.Priority(i) == picker.Urgent; got != want[i] {
+				t.Fatalf("piece %d: urgent %v, want %v", i, got, want[i])
+			}
+		}
+	}
+
+	r, err := tk.NewReader(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The default readahead covers the whole file.
+	urgent(0, 1, 2, 3, 4)
+
+	r.SetReadahead(2 * pieceLength)
+	urgent(0, 1)
+
+	// Seeking moves the window.
+	if _, err := r.Seek(2*pieceLength+1, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	urgent(2, 3, 4)
+	if _, err := r.Seek(-pieceLength, io.SeekCurrent); err != nil {
+		t.Fatal(err)
+	}
+	urgent(1, 2, 3)
+
+	// Nothing to read ahead at the end.
+	if _, err := r.Seek(0, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	urgent()
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	urgent(0, 1)
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	urgent()
+}
+
+func TestReaderClose(t *testing.T) {
+	tk, _ := newMemoryTask(t)
+
+	r, err := tk.NewReader(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := readAsync(r, 10)
+	select {
+	case err := <-done:
+		t.Fatalf("read returns before the piece is downloaded: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("read succeeds after close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("read is still blocked after close")
+	}
+	if _, err := r.Read(make([]byte, 1)); err == nil {
+		t.Fatal("read succeeds after close")
+	}
+}
@@ -0,0 +1,61 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime"
+
+	"github.com/movsb/torrent/pkg/daemon/picker"
+)
+
+// Recheck verifies the pieces on disk, and updates the bitfield to what
+// is really there. Good pieces are announced to peers, bad pieces are
+// downloaded again. Pieces are not saved while rechecking.
+func (t *Task) Recheck(ctx context.Context) error {
+	t.mu.RLock()
+	pm, nPieces := t.PM, 0
+	if t.File != nil {
+		nPieces = t.File.PieceHashes.Count()
+	}
+	t.mu.RUnlock()
+
+	if pm == nil {
+		return fmt.Errorf("task.Recheck: metadata is not ready")
+	}
+
+	step := nPieces / 20
+	if step == 0 {
+		step = 1
+	}
+
+	t.saveMu.Lock()
+	defer t.saveMu.Unlock()
+
+	bf, err := pm.Verify(ctx, runtime.NumCPU(), func(checked, good int) {
+		if checked%step == 0 || checked == nPieces {
+			log.Printf("task.Recheck: %d / %d checked, %d good", checked, nPieces, good)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("task.Recheck: %v", err)
+	}
+
+	for i := 0; i < nPieces; i++ {
+		switch had, has := t.BitField.HasPiece(i), bf.HasPiece(i); {
+		case has && !had:
+			t.BitField.SetPiece(i)
+			t.picker.SetState(i, picker.Done)
+			go t.broadcastHave(i)
+		case !has && had:
+			t.BitField.ClearPiece(i)
+			t.picker.SetState(i, picker.Missing)
+		}
+	}
+
+	t.notifyPieces()
+	t.wakePeers()
+	t.saveResume()
+
+	return nil
+}
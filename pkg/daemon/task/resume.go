@@ -0,0 +1,143 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/movsb/torrent/pkg/daemon/picker"
+	"github.com/movsb/torrent/pkg/daemon/resume"
+)
+
+const (
+	// How often to save the resume data.
+	resumeInterval = time.Minute
+
+	// The max number of peers saved in the resume data.
+	maxResumePeers = 200
+)
+
+// loadResume loads the progress saved last time, and returns the peers seen.
+// If the resume data is missing or the files have changed since, the pieces
+// on disk are checked instead.
+func (t *Task) loadResume(ctx context.Context) []string {
+	paths := t.PM.FilePaths()
+	if paths == nil {
+		log.Printf("task.loadResume: the storage has no file paths, the resume data can't be validated")
+	}
+	files := resume.Stat(paths)
+
+	var d *resume.Data
+	err := fmt.Errorf("no resume file")
+	if t.resumePath != `` {
+		d, err = resume.Load(t.resumePath)
+	}
+	// Nothing to tell whether files not on disk have changed.
+	if err == nil && files == nil {
+		err = fmt.Errorf("files are not on disk")
+	}
+	if err == nil {
+		t.mu.Lock()
+		t.uploaded, t.downloaded = d.Uploaded, d.Downloaded
+		for _, address := range d.Peers {
+			t.seen[address] = true
+		}
+		t.mu.Unlock()
+
+		if err = d.Validate(t.InfoHash[:], len(t.BitField.Fields), files); err == nil {
+			t.BitField.Unmarshal(d.BitField)
+			for i := 0; i < t.File.PieceHashes.Count(); i++ {
+				if t.BitField.HasPiece(i) {
+					t.picker.SetState(i, picker.Done)
+				}
+			}
+			log.Printf("task.loadResume: resumed from %s", t.resumePath)
+			return d.Peers
+		}
+	}
+
+	log.Printf("task.loadResume: %v, checking pieces", err)
+	if err := t.Recheck(ctx); err != nil {
+		log.Printf("task.loadResume: %v", err)
+	}
+
+	if d != nil {
+		return d.Peers
+	}
+	return nil
+}
+
+// saveResume saves the progress of the task, if it has the metadata.
+func (t *Task) saveResume() {
+	if t.resumePath == `` {
+		return
+	}
+
+	t.mu.Lock()
+	if t.File == nil {
+		t.mu.Unlock()
+		return
+	}
+	uploaded, downloaded := t.stats()
+	savePath := t.savePath
+	var priorities []int
+	for _, priority := range t.priorities {
+		if priority != picker.Normal {
+			priorities = make([]int, len(t.priorities))
+			for i, priority := range t.priorities {
+				priorities[i] = int(priority)
+			}
+			break
+		}
+	}
+	for _, p := range t.peers {
+		if address := p.ListenAddr(); address != `` {
+			t.seen[address] = true
+		}
+	}
+	peers := make([]string, 0, len(t.seen))
+	for address := range t.seen {
+		if len(peers) >= maxResumePeers {
+			break
+		}
+		peers = append(peers, address)
+	}
+	t.mu.Unlock()
+
+	// Files are stat-ed after the bitfield is taken, so that pieces written
+	// in between either make the resume data invalid, or are just missed.
+	bf, _ := t.BitField.Marshal()
+	files := resume.Stat(t.PM.FilePaths())
+
+	d := resume.Data{
+		InfoHash:   t.InfoHash[:],
+		BitField:   bf,
+		Files:      files,
+		Uploaded:   uploaded,
+		Downloaded: downloaded,
+		Peers:      peers,
+		SavePath:   savePath,
+		Priorities: priorities,
+	}
+
+	if err := resume.Save(t.resumePath, &d); err != nil {
+		log.Printf("task.saveResume: %v", err)
+	}
+}
+
+// keepResume saves the resume data periodically.
+func (t *Task) keepResume(ctx context.Context) {
+	ticker := time.NewTicker(resumeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("task.keepResume: context done")
+			return
+		case <-ticker.C:
+			t.saveResume()
+		}
+	}
+}
@@ -1,15 +1,21 @@
 package task
 
 import (
-	"container/list"
 	"context"
+	"fmt"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/movsb/torrent/pkg/common"
+	"github.com/movsb/torrent/pkg/daemon/picker"
+	"github.com/movsb/torrent/pkg/daemon/resume"
 	"github.com/movsb/torrent/pkg/daemon/store"
+	"github.com/movsb/torrent/pkg/magnet"
 	"github.com/movsb/torrent/pkg/message"
+	"github.com/movsb/torrent/pkg/metadata"
 	"github.com/movsb/torrent/pkg/peer"
+	"github.com/movsb/torrent/pkg/pex"
 	"github.com/movsb/torrent/pkg/torrent"
 )
 
@@ -20,16 +26,99 @@ type Task struct {
 	BitField *message.BitField
 	PM       *store.PieceManager
 
+	// Magnet is set if the task is created from a magnet link,
+	// File is nil until the metadata is fetched from peers.
+	Magnet *magnet.Magnet
+
+	// Extensions we support, shared by all peers.
+	extensions *peer.Extensions
+
+	// Peer exchange, nil for private torrents.
+	pex *pex.Extension
+
 	// map from peer address to peer.
-	busyPeers map[string]*peer.Peer
-	idlePeers map[string]*peer.Peer
+	peers map[string]*peer.Peer
+
+	// Schedules block requests across peers and web seeds.
+	downloader *_Downloader
+	webSeeds   []*_WebSeed
+
+	// The directory to save files in, and where to move them once completed.
+	savePath      string
+	completedPath string
+
+	// Whether not to put files in a directory named after the torrent.
+	noRootFolder bool
+
+	// Creates the storage of the files, nil for files on disk.
+	newStorage func(tf *torrent.File, savePath string) store.Storage
+
+	// Priorities of files, and the selector that sets them
+	// once the pieces are loaded.
+	priorities []picker.Priority
+	selector   FileSelector
+
+	// Readers streaming the files, and a channel closed, and then
+	// renewed, whenever pieces are downloaded, to wake them up.
+	readers map[*Reader]bool
+	pieceCh chan struct{}
+
+	// Serializes moves, so that the save path is where the files are.
+	moveMu sync.Mutex
+
+	// Where the resume data is saved, empty if not saved.
+	resumePath string
+
+	// Bytes uploaded and downloaded by peers that have exited,
+	// and those loaded from the resume data.
+	uploaded   int64
+	downloaded int64
 
-	pieces *list.List
+	// Addresses of peers seen, saved in the resume data.
+	seen map[string]bool
+
+	// The choker and the number of its regular upload slots.
+	choker      *_Choker
+	uploadSlots int
+
+	// Tiers of trackers, extra trackers added, and the context
+	// announcing runs in, nil before it starts.
+	tiers         []*_Tier
+	extraTrackers []string
+	announceCtx   context.Context
+
+	// The port we accept peers on, and the key, announced to trackers.
+	listenPort int
+	key        uint32
+
+	// Whether the completed event has been sent.
+	completedAnnounced bool
+
+	// Addresses being dialed, and when they were dialed.
+	dialing map[string]bool
+	dialed  map[string]time.Time
+
+	picker picker.Picker
 	done   chan peer.SinglePieceData
 
+	// Held while a piece is saved, or while the pieces are rechecked,
+	// so that no piece saved during a recheck is taken as bad.
+	saveMu sync.Mutex
+
+	// The context the task runs in, for incoming peers.
+	ctx context.Context
+
 	mu sync.RWMutex
 }
 
+// Metadata returns the metadata of the task,
+// or nil if it is not fetched yet.
+func (t *Task) Metadata() *torrent.File {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.File
+}
+
 // AddClient ...
 func (t *Task) AddClient(client *peer.Peer) {
 	t.mu.Lock()
@@ -40,85 +129,207 @@ func (t *Task) AddClient(client *peer.Peer) {
 		return
 	}
 
-	t.idlePeers[client.PeerAddr] = client
-	log.Printf("add %s to idle", client.PeerAddr)
+	// Incoming peers have no context.
+	if client.Ctx == nil {
+		client.Ctx = t.ctx
+	}
+
+	t.peers[client.PeerAddr] = client
+	if !client.Incoming {
+		t.seen[client.PeerAddr] = true
+	}
+	t.picker.AddBitField(client.HerBitField)
+	log.Printf("add peer %s", client.PeerAddr)
+
+	client.Downloader = t.downloader
+
+	// She will request from the picker as soon as her loop goes on.
+	client.OnHave = func(p *peer.Peer, index int) {
+		t.picker.Have(index)
+	}
+
+	// Incoming peers without the fast extension send bitfields after being added.
+	client.OnBitField = func(p *peer.Peer, bf *message.BitField) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.picker.RemoveBitField(p.HerBitField)
+		p.HerBitField = bf
+		t.picker.AddBitField(bf)
+	}
 
 	client.OnExit = func(p *peer.Peer) {
 		t.mu.Lock()
 		defer t.mu.Unlock()
-		delete(t.busyPeers, p.PeerAddr)
-		delete(t.idlePeers, p.PeerAddr)
+		// It may be called more than once.
+		if _, ok := t.peers[p.PeerAddr]; !ok {
+			return
+		}
+		delete(t.peers, p.PeerAddr)
+		t.uploaded += p.UploadedBytes()
+		t.downloaded += p.DownloadedBytes()
+		t.picker.RemoveBitField(p.HerBitField)
+		if t.pex != nil {
+			t.pex.Remove(p)
+		}
 	}
 
 	go client.Run()
 	go client.Poll()
+}
 
-	t.schedule(false)
+// wakePeers wakes all peers up to request blocks.
+func (t *Task) wakePeers() {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, p := range t.peers {
+		p.Wake()
+	}
+	for _, w := range t.webSeeds {
+		w.wake()
+	}
+}
+
+// setFile sets the torrent file and those depending on it.
+// We have no pieces until the resume data is loaded.
+func (t *Task) setFile(tf *torrent.File) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// The files may have been moved last time.
+	var saved []int
+	if d, err := resume.Load(t.resumePath); err == nil {
+		if d.SavePath != `` {
+			t.savePath = d.SavePath
+		}
+		saved = d.Priorities
+	}
+
+	var storage store.Storage
+	if t.newStorage != nil {
+		storage = t.newStorage(tf, t.savePath)
+	} else {
+		fs, err := store.NewFileStorage(tf, t.savePath)
+		if err != nil {
+			return fmt.Errorf("task.setFile: %v", err)
+		}
+		fs.NoRootFolder = t.noRootFolder
+		storage = fs
+	}
+
+	t.File = tf
+	t.BitField = message.NewBitField(tf.PieceHashes.Count(), 0)
+	t.PM = store.NewPieceManager(tf, storage)
+	t.initPieces()
+	t.initPriorities(saved)
+	return nil
+}
+
+// initExtensions initializes the extensions we support.
+// PEX is disabled for private torrents.
+func (t *Task) initExtensions(ctx context.Context) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	exts := []peer.Extension{
+		&metadata.Extension{Info: t.File.RawInfo()},
+	}
+
+	if !t.File.Private {
+		t.pex = pex.NewExtension(func(addresses []string) {
+			t.spawnPeers(ctx, addresses)
+		})
+		exts = append(exts, t.pex)
+	}
+
+	t.extensions = peer.NewExtensions(exts...)
+	t.extensions.ListenPort = t.listenPort
 }
 
 // Run ...
 func (t *Task) Run(ctx context.Context) {
-	t.initPieces()
+	t.mu.Lock()
+	t.ctx = ctx
+	t.mu.Unlock()
+
+	if t.File == nil {
+		if err := t.fetchMetadata(ctx); err != nil {
+			log.Printf("task.Run: fetch metadata failed: %v", err)
+			return
+		}
+	}
+
+	peers := t.loadResume(ctx)
+	t.selectFiles()
+	if t.isComplete() {
+		t.complete()
+	}
+
+	t.initExtensions(ctx)
+
+	if t.pex != nil {
+		go t.exchangePeers(ctx)
+	}
+
+	go t.choke(ctx)
+	go t.downloader.checkTimeouts(ctx)
+
+	go t.keepResume(ctx)
 
 	go t.announce(ctx)
 	go t.savePiece(ctx)
+	t.startWebSeeds(ctx)
+
+	if len(peers) > 0 {
+		t.spawnPeers(ctx, peers)
+	}
 }
 
-func (t *Task) schedule(lock bool) {
-	if lock {
-		t.mu.Lock()
-		defer t.mu.Unlock()
+// Move moves the files of the task to dir while it's running.
+// Either all the files are moved, or none. t.mu is not held while
+// moving, which may copy the files, reads and writes of the pieces
+// wait for the piece manager instead.
+func (t *Task) Move(dir string) error {
+	t.moveMu.Lock()
+	defer t.moveMu.Unlock()
+
+	t.mu.RLock()
+	pm := t.PM
+	t.mu.RUnlock()
+
+	if pm == nil {
+		return fmt.Errorf("task.Move: metadata is not ready")
+	}
+	if err := pm.Move(dir); err != nil {
+		return fmt.Errorf("task.Move: %v", err)
 	}
 
-	var pops []*list.Element
+	t.mu.Lock()
+	t.savePath = dir
+	t.mu.Unlock()
 
-	defer func() {
-		log.Printf("moved %d elements to back", len(pops))
-		for _, p := range pops {
-			t.pieces.MoveToBack(p)
-		}
-	}()
+	log.Printf("task.Move: moved to %s", dir)
+	t.saveResume()
+	return nil
+}
 
-	for e := t.pieces.Front(); e != nil; e = e.Next() {
-		if len(t.idlePeers) <= 0 {
-			return
-		}
-		p := e.Value.(peer.SinglePieceData)
-		if t.BitField.HasPiece(p.Index) {
-			pops = append(pops, e)
-			continue
-		}
-		busy := make(map[string]*peer.Peer)
-		for _, client := range t.idlePeers {
-			if _, ok := busy[client.PeerAddr]; ok {
-				log.Printf("peer is busy")
-				continue
-			}
-			if !client.HerBitField.HasPiece(p.Index) {
-				log.Printf("peer has no index: %d", p.Index)
-				continue
-			}
-
-			pops = append(pops, e)
-			busy[client.PeerAddr] = client
-
-			go func(client *peer.Peer, piece peer.SinglePieceData) {
-				if err := client.Download(piece, t.done); err != nil {
-					log.Printf("peer download error: %s", err)
-					client.OnExit(client)
-					return
-				}
-				t.mu.Lock()
-				defer t.mu.Unlock()
-				t.idlePeers[client.PeerAddr] = client
-				delete(t.busyPeers, client.PeerAddr)
-				t.schedule(false)
-			}(client, p)
-			break
-		}
-		for a, b := range busy {
-			delete(t.idlePeers, a)
-			t.busyPeers[a] = b
+// complete is called when all pieces are downloaded.
+// Files are moved to the completed path, if set.
+func (t *Task) complete() {
+	if t.completedPath != `` {
+		if err := t.Move(t.completedPath); err != nil {
+			log.Printf("task.complete: %v", err)
 		}
 	}
+	t.saveResume()
+}
+
+// stats returns the bytes uploaded and downloaded in total.
+// t.mu must be held.
+func (t *Task) stats() (int64, int64) {
+	uploaded, downloaded := t.uploaded, t.downloaded
+	for _, p := range t.peers {
+		uploaded += p.UploadedBytes()
+		downloaded += p.DownloadedBytes()
+	}
+	return uploaded, downloaded
 }
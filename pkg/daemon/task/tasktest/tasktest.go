@@ -0,0 +1,38 @@
+// Package tasktest runs tasks for tests.
+package tasktest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/movsb/torrent/pkg/daemon/task"
+)
+
+// NewTask creates a task of the torrent in a new manager,
+// which is closed once the test is done.
+func NewTask(t testing.TB, torrentPath string, savePath string, selector task.FileSelector) (*task.Manager, *task.Task) {
+	t.Helper()
+	m := task.NewManager(``)
+	t.Cleanup(func() { m.Close() })
+	tk, err := m.CreateTask(torrentPath, savePath, selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, tk
+}
+
+// WaitComplete waits until all the pieces of the task are downloaded,
+// or verified on disk, and fails the test after timeout.
+func WaitComplete(t testing.TB, tk *task.Task, timeout time.Duration) {
+	t.Helper()
+	for deadline := time.Now().Add(timeout); ; {
+		// The bitfield is nil until the metadata is ready.
+		if tk.Metadata() != nil && tk.BitField.AllOnes() {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
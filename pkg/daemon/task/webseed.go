@@ -0,0 +1,182 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/movsb/torrent/pkg/message"
+	"github.com/movsb/torrent/pkg/webseed"
+)
+
+const (
+	// The max bytes requested from a web seed at a time.
+	webSeedRequestSize = 1 << 20
+
+	// How long to wait before retrying a web seed that failed,
+	// doubled on each failure in a row, up to the max.
+	webSeedMinBackoff = time.Second * 5
+	webSeedMaxBackoff = time.Minute * 10
+
+	// How long a read from a web seed may take, like a request to a peer.
+	// Requests can't be cancelled otherwise if the server stalls.
+	webSeedTimeout = requestTimeout
+)
+
+// _WebSeed downloads blocks from a web seed, which are scheduled
+// by the downloader like those from peers.
+type _WebSeed struct {
+	t      *Task
+	client *webseed.Client
+	wakeCh chan struct{}
+
+	// The timeout of each read, which fails the fetch.
+	timeout time.Duration
+}
+
+var _ _Source = &_WebSeed{}
+
+// CanRequest is always true, a web seed has all the pieces.
+func (w *_WebSeed) CanRequest(index int) bool {
+	return true
+}
+
+// CancelRequest does nothing, requests being fetched are not cancelled
+// but time out, and duplicate blocks are dropped.
+func (w *_WebSeed) CancelRequest(req message.Request) error {
+	return nil
+}
+
+// Name ...
+func (w *_WebSeed) Name() string {
+	return w.client.URL
+}
+
+func (w *_WebSeed) wake() {
+	select {
+	case w.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (w *_WebSeed) run(ctx context.Context) {
+	d := w.t.downloader
+	failures := 0
+
+	for {
+		reqs := d.nextRequests(w, webSeedRequestSize/blockSize)
+		if len(reqs) == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-w.wakeCh:
+			}
+			continue
+		}
+
+		err := w.fetch(ctx, reqs)
+		if err == nil {
+			failures = 0
+			continue
+		}
+
+		d.onDropped(w, reqs)
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, webseed.ErrRangeNotSupported) {
+			log.Printf("task.webSeed: %s is disabled: %v", w.Name(), err)
+			return
+		}
+
+		failures++
+		backoff := webSeedMinBackoff
+		for i := 1; i < failures && backoff < webSeedMaxBackoff; i++ {
+			backoff *= 2
+		}
+		if backoff > webSeedMaxBackoff {
+			backoff = webSeedMaxBackoff
+		}
+		log.Printf("task.webSeed: %s failed %d times, retry in %v: %v", w.Name(), failures, backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// fetch fetches the blocks of the requests, contiguous blocks at once,
+// and each from the files the blocks span.
+func (w *_WebSeed) fetch(ctx context.Context, reqs []message.Request) error {
+	for len(reqs) > 0 {
+		n, length := 1, reqs[0].Length
+		for n < len(reqs) && reqs[n].Index == reqs[0].Index && reqs[n].Begin == reqs[0].Begin+length {
+			length += reqs[n].Length
+			n++
+		}
+
+		data := make([]byte, length)
+		offset := 0
+		for _, r := range w.t.PM.FileRanges(reqs[0].Index, reqs[0].Begin, length) {
+			readCtx, cancel := context.WithTimeout(ctx, w.timeout)
+			err := w.client.ReadAt(readCtx, r.File, data[offset:offset+r.Length], r.Offset)
+			cancel()
+			if err != nil {
+				return err
+			}
+			offset += r.Length
+		}
+
+		w.t.mu.Lock()
+		w.t.downloaded += int64(length)
+		w.t.mu.Unlock()
+
+		offset = 0
+		for _, req := range reqs[:n] {
+			w.t.downloader.onBlock(ctx, w, &message.Piece{
+				Index: req.Index,
+				Begin: req.Begin,
+				Data:  data[offset : offset+req.Length],
+			})
+			offset += req.Length
+		}
+
+		reqs = reqs[n:]
+	}
+	return nil
+}
+
+// startWebSeeds starts downloading from the web seeds of the torrent,
+// and those of the magnet link.
+func (t *Task) startWebSeeds(ctx context.Context) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	urls := append([]string(nil), t.File.WebSeeds...)
+	if t.Magnet != nil {
+		urls = append(urls, t.Magnet.WebSeeds...)
+	}
+
+	seen := make(map[string]bool)
+	for _, u := range urls {
+		if seen[u] {
+			continue
+		}
+		seen[u] = true
+
+		w := &_WebSeed{
+			t: t,
+			client: &webseed.Client{
+				URL:  u,
+				File: t.File,
+			},
+			wakeCh:  make(chan struct{}, 1),
+			timeout: webSeedTimeout,
+		}
+		t.webSeeds = append(t.webSeeds, w)
+		go w.run(ctx)
+	}
+}
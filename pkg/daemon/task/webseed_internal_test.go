@@ -0,0 +1,50 @@
+package task
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/movsb/torrent/pkg/webseed"
+)
+
+func TestWebSeedTimeout(t *testing.T) {
+	tk, _ := newMemoryTask(t)
+
+	// The server stops sending in the middle of the body.
+	stop := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(`Content-Range`, strings.Replace(r.Header.Get(`Range`), `=`, ` `, 1)+`/*`)
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(make([]byte, 10))
+		w.(http.Flusher).Flush()
+		<-stop
+	}))
+	defer s.Close()
+	defer close(stop)
+
+	w := &_WebSeed{
+		t:       tk,
+		client:  &webseed.Client{URL: s.URL + `/a.bin`, File: tk.File},
+		wakeCh:  make(chan struct{}, 1),
+		timeout: 100 * time.Millisecond,
+	}
+	reqs := tk.downloader.nextRequests(w, 1)
+	if len(reqs) != 1 {
+		t.Fatalf("requests: %v", reqs)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- w.fetch(context.Background(), reqs) }()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), `deadline exceeded`) {
+			t.Fatalf("want a timeout, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("fetch is still blocked after the timeout")
+	}
+}
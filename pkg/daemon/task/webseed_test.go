@@ -0,0 +1,45 @@
+package task_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/movsb/torrent/pkg/daemon/task/tasktest"
+	"github.com/movsb/torrent/pkg/torrent/torrenttest"
+)
+
+func TestWebSeed(t *testing.T) {
+	dir := t.TempDir()
+	seedDir := filepath.Join(dir, `seed`)
+	root := filepath.Join(seedDir, `files`)
+	files := map[string][]byte{
+		`a.txt`:     []byte(`hello, world`),
+		`sub/b.bin`: bytes.Repeat([]byte(`0123456789`), 300000),
+	}
+	torrenttest.WriteFiles(t, root, files)
+
+	s := httptest.NewServer(http.FileServer(http.Dir(seedDir)))
+	defer s.Close()
+
+	tp := filepath.Join(dir, `files.torrent`)
+	torrenttest.Create(t, root, tp, s.URL+`/`)
+
+	leechDir := filepath.Join(dir, `leech`)
+	_, tk := tasktest.NewTask(t, tp, leechDir, nil)
+	tasktest.WaitComplete(t, tk, 30*time.Second)
+
+	for name, want := range files {
+		got, err := ioutil.ReadFile(filepath.Join(leechDir, `files`, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s mismatch", name)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package torrentfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/movsb/torrent/pkg/daemon/task"
+)
+
+// FS is the file system of the files of a task, whose directories come from
+// the paths of the files in the torrent. Files are read while being
+// downloaded, and reads block until the pieces are downloaded, unless
+// NonBlocking is set.
+type FS struct {
+	// Reads of pieces not downloaded yet fail with task.ErrNotDownloaded,
+	// instead of blocking. It must be set before files are opened.
+	NonBlocking bool
+
+	t     *task.Task
+	nodes map[string]*_Node
+}
+
+var (
+	_ fs.FS        = &FS{}
+	_ fs.ReadDirFS = &FS{}
+	_ fs.StatFS    = &FS{}
+)
+
+// _Node is a file or a directory.
+type _Node struct {
+	name   string
+	dir    bool
+	index  int
+	length int64
+
+	// Sorted by name.
+	children []*_Node
+}
+
+// New creates the file system of the task, whose metadata must be ready.
+func New(t *task.Task) (*FS, error) {
+	tf := t.Metadata()
+	if tf == nil {
+		return nil, fmt.Errorf("torrentfs.New: metadata is not ready")
+	}
+
+	f := &FS{
+		t: t,
+		nodes: map[string]*_Node{
+			`.`: {name: `.`, dir: true},
+		},
+	}
+
+	for i, item := range tf.Files {
+		name := strings.Join(item.Paths, `/`)
+		if !fs.ValidPath(name) || name == `.` {
+			continue
+		}
+		if _, ok := f.nodes[name]; ok {
+			continue
+		}
+		if parent := f.mkdirAll(path.Dir(name)); parent != nil {
+			node := &_Node{name: path.Base(name), index: i, length: item.Length}
+			parent.children = append(parent.children, node)
+			f.nodes[name] = node
+		}
+	}
+
+	for _, node := range f.nodes {
+		sort.Slice(node.children, func(i, j int) bool {
+			return node.children[i].name < node.children[j].name
+		})
+	}
+
+	return f, nil
+}
+
+// mkdirAll creates the directory and its parents, or returns nil
+// if a file is in the way.
+func (f *FS) mkdirAll(name string) *_Node {
+	if node, ok := f.nodes[name]; ok {
+		if !node.dir {
+			return nil
+		}
+		return node
+	}
+	parent := f.mkdirAll(path.Dir(name))
+	if parent == nil {
+		return nil
+	}
+	node := &_Node{name: path.Base(name), dir: true}
+	parent.children = append(parent.children, node)
+	f.nodes[name] = node
+	return node
+}
+
+func (f *FS) lookup(op string, name string) (*_Node, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	node, ok := f.nodes[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return node, nil
+}
+
+// Open ...
+func (f *FS) Open(name string) (fs.File, error) {
+	node, err := f.lookup(`open`, name)
+	if err != nil {
+		return nil, err
+	}
+	if node.dir {
+		return &_Dir{node: node}, nil
+	}
+
+	r, err := f.t.NewReader(node.index)
+	if err != nil {
+		return nil, &fs.PathError{Op: `open`, Path: name, Err: err}
+	}
+	r.SetNonBlocking(f.NonBlocking)
+	return &_File{node: node, path: name, r: r}, nil
+}
+
+// ReadDir ...
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	node, err := f.lookup(`readdir`, name)
+	if err != nil {
+		return nil, err
+	}
+	if !node.dir {
+		return nil, &fs.PathError{Op: `readdir`, Path: name, Err: errors.New(`not a directory`)}
+	}
+	entries := make([]fs.DirEntry, len(node.children))
+	for i, child := range node.children {
+		entries[i] = &_FileInfo{child}
+	}
+	return entries, nil
+}
+
+// Stat ...
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	node, err := f.lookup(`stat`, name)
+	if err != nil {
+		return nil, err
+	}
+	return &_FileInfo{node}, nil
+}
+
+// FileIndex returns the index of the file in the torrent.
+func (f *FS) FileIndex(name string) (int, error) {
+	node, err := f.lookup(`stat`, name)
+	if err != nil {
+		return 0, err
+	}
+	if node.dir {
+		return 0, &fs.PathError{Op: `stat`, Path: name, Err: errors.New(`is a directory`)}
+	}
+	return node.index, nil
+}
+
+// _File is an opened file, read by a streaming reader.
+type _File struct {
+	node *_Node
+	path string
+	r    *task.Reader
+}
+
+func (f *_File) Stat() (fs.FileInfo, error) {
+	return &_FileInfo{f.node}, nil
+}
+
+func (f *_File) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = &fs.PathError{Op: `read`, Path: f.path, Err: err}
+	}
+	return n, err
+}
+
+func (f *_File) Seek(offset int64, whence int) (int64, error) {
+	return f.r.Seek(offset, whence)
+}
+
+func (f *_File) Close() error {
+	return f.r.Close()
+}
+
+// _Dir is an opened directory.
+type _Dir struct {
+	node   *_Node
+	offset int
+}
+
+func (d *_Dir) Stat() (fs.FileInfo, error) {
+	return &_FileInfo{d.node}, nil
+}
+
+func (d *_Dir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: `read`, Path: d.node.name, Err: errors.New(`is a directory`)}
+}
+
+func (d *_Dir) Close() error {
+	return nil
+}
+
+func (d *_Dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	children := d.node.children[d.offset:]
+	if n > 0 && len(children) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && len(children) > n {
+		children = children[:n]
+	}
+	d.offset += len(children)
+
+	entries := make([]fs.DirEntry, len(children))
+	for i, child := range children {
+		entries[i] = &_FileInfo{child}
+	}
+	return entries, nil
+}
+
+// _FileInfo is both the fs.FileInfo and the fs.DirEntry of a node.
+type _FileInfo struct {
+	node *_Node
+}
+
+func (i *_FileInfo) Name() string       { return i.node.name }
+func (i *_FileInfo) Size() int64        { return i.node.length }
+func (i *_FileInfo) ModTime() time.Time { return time.Time{} }
+func (i *_FileInfo) IsDir() bool        { return i.node.dir }
+func (i *_FileInfo) Sys() interface{}   { return nil }
+
+func (i *_FileInfo) Mode() fs.FileMode {
+	if i.node.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (i *_FileInfo) Type() fs.FileMode {
+	return i.Mode().Type()
+}
+
+func (i *_FileInfo) Info() (fs.FileInfo, error) {
+	return i, nil
+}
@@ -0,0 +1,83 @@
+package torrentfs
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/movsb/torrent/pkg/daemon/task"
+	"github.com/movsb/torrent/pkg/daemon/task/tasktest"
+	"github.com/movsb/torrent/pkg/torrent/torrenttest"
+)
+
+// createTorrent creates a torrent of a directory with files,
+// and returns the path of the torrent.
+func createTorrent(t *testing.T, dir string) string {
+	root := filepath.Join(dir, `files`)
+	torrenttest.WriteFiles(t, root, map[string][]byte{
+		`a.txt`:             []byte(`hello, world`),
+		`sub/b.bin`:         bytes.Repeat([]byte(`0123456789`), 10000),
+		`sub/deep/c.txt`:    []byte(`c`),
+		`sub/deep/empty.go`: nil,
+	})
+	path := filepath.Join(dir, `files.torrent`)
+	torrenttest.Create(t, root, path)
+	return path
+}
+
+func TestFS(t *testing.T) {
+	dir := t.TempDir()
+	tp := createTorrent(t, dir)
+
+	_, tk := tasktest.NewTask(t, tp, dir, nil)
+
+	fsys, err := New(tk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Reads block until files on disk are verified.
+	if err := fstest.TestFS(fsys, `a.txt`, `sub/b.bin`, `sub/deep/c.txt`, `sub/deep/empty.go`); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := fs.ReadFile(fsys, `sub/deep/c.txt`)
+	if err != nil || string(b) != `c` {
+		t.Fatalf("read: %q, %v", b, err)
+	}
+}
+
+func TestFSNonBlocking(t *testing.T) {
+	dir := t.TempDir()
+	tp := createTorrent(t, dir)
+
+	// Nothing is downloaded in another directory.
+	_, tk := tasktest.NewTask(t, tp, filepath.Join(dir, `empty`), nil)
+
+	fsys, err := New(tk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys.NonBlocking = true
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := fs.ReadFile(fsys, `a.txt`)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, task.ErrNotDownloaded) {
+			t.Fatalf("want ErrNotDownloaded, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("read blocks")
+	}
+
+	if _, err := fs.Stat(fsys, `sub/deep`); err != nil {
+		t.Fatal(err)
+	}
+}
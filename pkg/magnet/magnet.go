@@ -0,0 +1,150 @@
+package magnet
+
+import (
+	"encoding/base32"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/movsb/torrent/pkg/common"
+)
+
+// Magnet is a parsed magnet URI.
+//
+// Reference: https://www.bittorrent.org/beps/bep_0009.html
+type Magnet struct {
+	InfoHash common.Hash
+
+	// Display name, may be empty.
+	Name string
+
+	// Tracker URLs.
+	Trackers []string
+
+	// Web seed URLs.
+	WebSeeds []string
+
+	// Peer addresses in the form of host:port.
+	Peers []string
+}
+
+const (
+	scheme     = `magnet`
+	btihPrefix = `urn:btih:`
+)
+
+// IsMagnet tells whether s looks like a magnet URI.
+func IsMagnet(s string) bool {
+	return strings.HasPrefix(strings.ToLower(s), scheme+`:`)
+}
+
+// Parse parses a magnet URI.
+func Parse(uri string) (*Magnet, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("magnet: %v", err)
+	}
+	if !strings.EqualFold(u.Scheme, scheme) {
+		return nil, fmt.Errorf("magnet: invalid scheme: %s", u.Scheme)
+	}
+
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("magnet: %v", err)
+	}
+
+	m := &Magnet{}
+	hasInfoHash := false
+
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// Some clients number the parameters, like tr.1, tr.2.
+	for _, key := range keys {
+		values := query[key]
+		if i := strings.IndexByte(key, '.'); i > 0 && key != `x.pe` {
+			key = key[:i]
+		}
+		for _, value := range values {
+			switch key {
+			case `xt`:
+				if hasInfoHash || !strings.HasPrefix(strings.ToLower(value), btihPrefix) {
+					continue
+				}
+				ih, err := parseInfoHash(value[len(btihPrefix):])
+				if err != nil {
+					return nil, err
+				}
+				m.InfoHash = ih
+				hasInfoHash = true
+			case `dn`:
+				m.Name = value
+			case `tr`:
+				m.Trackers = appendUnique(m.Trackers, value)
+			case `ws`:
+				m.WebSeeds = appendUnique(m.WebSeeds, value)
+			case `x.pe`:
+				m.Peers = appendUnique(m.Peers, value)
+			}
+		}
+	}
+
+	if !hasInfoHash {
+		return nil, fmt.Errorf("magnet: no btih info hash")
+	}
+
+	return m, nil
+}
+
+// The info hash is either hex-encoded (40 chars) or base32-encoded (32 chars).
+func parseInfoHash(s string) (common.Hash, error) {
+	switch len(s) {
+	case 40:
+		ih, err := common.HashFromString(s)
+		if err != nil {
+			return common.Hash{}, fmt.Errorf("magnet: invalid info hash: %v", err)
+		}
+		return ih, nil
+	case 32:
+		b, err := base32.StdEncoding.DecodeString(strings.ToUpper(s))
+		if err != nil {
+			return common.Hash{}, fmt.Errorf("magnet: invalid info hash: %v", err)
+		}
+		var ih common.Hash
+		ih.Set(b)
+		return ih, nil
+	default:
+		return common.Hash{}, fmt.Errorf("magnet: invalid info hash length: %d", len(s))
+	}
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// String returns the magnet URI.
+func (m *Magnet) String() string {
+	var b strings.Builder
+	b.WriteString(scheme + `:?xt=` + btihPrefix + m.InfoHash.String())
+	add := func(key string, values ...string) {
+		for _, v := range values {
+			b.WriteString(`&` + key + `=` + url.QueryEscape(v))
+		}
+	}
+	if m.Name != `` {
+		add(`dn`, m.Name)
+	}
+	add(`tr`, m.Trackers...)
+	add(`ws`, m.WebSeeds...)
+	add(`x.pe`, m.Peers...)
+	return b.String()
+}
@@ -0,0 +1,63 @@
+package magnet
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/movsb/torrent/pkg/common"
+)
+
+func TestParse(t *testing.T) {
+	const ih = `8ce301d28fe97eed1a6ef7feaf296411b375222f`
+	uri := `magnet:?xt=urn:btih:` + ih +
+		`&dn=ubuntu.iso` +
+		`&tr=udp%3A%2F%2Ftracker.example.com%3A6969` +
+		`&tr.1=http%3A%2F%2Ftracker.example.org%2Fannounce` +
+		`&ws=http%3A%2F%2Fseed.example.com%2Fubuntu.iso` +
+		`&x.pe=10.0.0.1%3A6881`
+
+	m, err := Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Magnet{
+		Name:     `ubuntu.iso`,
+		Trackers: []string{`udp://tracker.example.com:6969`, `http://tracker.example.org/announce`},
+		WebSeeds: []string{`http://seed.example.com/ubuntu.iso`},
+		Peers:    []string{`10.0.0.1:6881`},
+	}
+	want.InfoHash, _ = common.HashFromString(ih)
+	if !reflect.DeepEqual(m, want) {
+		t.Fatalf("got %+v, want %+v", m, want)
+	}
+
+	again, err := Parse(m.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, want) {
+		t.Fatalf("round trip: got %+v, want %+v", again, want)
+	}
+}
+
+func TestParseBase32(t *testing.T) {
+	m, err := Parse(`magnet:?xt=urn:btih:RTRQDUUP5F7O2GTO677K6KLECGZXKIRP`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.InfoHash.String(); got != `8ce301d28fe97eed1a6ef7feaf296411b375222f` {
+		t.Fatalf("bad info hash: %s", got)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, uri := range []string{
+		`http://example.com/`,
+		`magnet:?dn=no-hash`,
+		`magnet:?xt=urn:btih:1234`,
+	} {
+		if _, err := Parse(uri); err == nil {
+			t.Errorf("expect error: %s", uri)
+		}
+	}
+}
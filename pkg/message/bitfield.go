@@ -42,7 +42,9 @@ func (m *BitField) Marshal() ([]byte, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	return m.Fields, nil
+	b := make([]byte, len(m.Fields))
+	copy(b, m.Fields)
+	return b, nil
 }
 
 // Unmarshal ...
@@ -70,6 +72,22 @@ func (m *BitField) Init(pieceCount int) {
 	m.bitsRemain = bitsRemain
 }
 
+// Valid reports whether the fields received are of the piece count of Init,
+// with the spare bits of the last byte cleared.
+func (m *BitField) Valid() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.Fields) != m.byteCount {
+		return false
+	}
+	if m.byteCount == 0 {
+		return true
+	}
+	spare := byte(1<<m.bitsRemain - 1)
+	return m.Fields[m.byteCount-1]&spare == 0
+}
+
 // HasPiece ...
 func (m *BitField) HasPiece(index int) (has bool) {
 	m.mu.RLock()
@@ -89,6 +107,15 @@ func (m *BitField) SetPiece(index int) {
 	})
 }
 
+// ClearPiece ...
+func (m *BitField) ClearPiece(index int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calc(index, func(byteIndex int, bitMask byte) {
+		m.Fields[byteIndex] &^= bitMask
+	})
+}
+
 func (m *BitField) calc(index int, fn func(byteIndex int, bitMask byte)) {
 	byteIndex := index / 8
 	bitMask := byte(1 << (7 - index%8))
@@ -101,6 +128,19 @@ func (m *BitField) calc(index int, fn func(byteIndex int, bitMask byte)) {
 	fn(byteIndex, bitMask)
 }
 
+// AllZeros indicates that we have no pieces.
+func (m *BitField) AllZeros() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for i := 0; i < m.byteCount; i++ {
+		if m.Fields[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
 // AllOnes indicates that we have all pieces.
 func (m *BitField) AllOnes() bool {
 	m.mu.RLock()
@@ -112,6 +152,7 @@ func (m *BitField) AllOnes() bool {
 		}
 	}
 
-	lastByte := m.Fields[m.byteCount-1]
-	return ^lastByte == 0xFF>>m.bitsRemain
+	// The unused bits of the last byte may be either zeros or ones.
+	mask := byte(0xFF << m.bitsRemain)
+	return m.Fields[m.byteCount-1]&mask == mask
 }
@@ -0,0 +1,27 @@
+package message
+
+import "testing"
+
+func TestBitFieldValid(t *testing.T) {
+	for _, c := range []struct {
+		pieceCount int
+		fields     []byte
+		valid      bool
+	}{
+		{10, []byte{0xFF, 0xC0}, true},
+		{10, []byte{0xFF}, false},
+		{10, []byte{0xFF, 0xC0, 0x00}, false},
+		{10, []byte{0xFF, 0xE0}, false},
+		{16, []byte{0xFF, 0xFF}, true},
+		{0, nil, true},
+	} {
+		var bf BitField
+		if err := bf.Unmarshal(c.fields); err != nil {
+			t.Fatal(err)
+		}
+		bf.Init(c.pieceCount)
+		if bf.Valid() != c.valid {
+			t.Errorf("%d pieces, %x: want valid %v", c.pieceCount, c.fields, c.valid)
+		}
+	}
+}
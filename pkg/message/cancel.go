@@ -0,0 +1,8 @@
+package message
+
+// Cancel cancels a request sent before.
+type Cancel struct {
+	Request
+}
+
+var _ Message = &Cancel{}
@@ -30,4 +30,10 @@ const (
 	MsgRequest       = MsgID(6)
 	MsgPiece         = MsgID(7)
 	MsgCancel        = MsgID(8)
+	MsgSuggestPiece  = MsgID(13)
+	MsgHaveAll       = MsgID(14)
+	MsgHaveNone      = MsgID(15)
+	MsgRejectRequest = MsgID(16)
+	MsgAllowedFast   = MsgID(17)
+	MsgExtended      = MsgID(20)
 )
@@ -0,0 +1,73 @@
+package message
+
+import (
+	"fmt"
+
+	"github.com/zeebo/bencode"
+)
+
+// ExtendedHandshakeID is the extended message ID of the extended handshake.
+// Other IDs are those negotiated in the handshake.
+const ExtendedHandshakeID = 0
+
+// Extended is the message of the extension protocol (BEP 10).
+type Extended struct {
+	ExtendedID byte
+	Payload    []byte
+}
+
+var _ Message = &Extended{}
+
+// Marshal ...
+func (m *Extended) Marshal() ([]byte, error) {
+	buf := make([]byte, 1+len(m.Payload))
+	buf[0] = m.ExtendedID
+	copy(buf[1:], m.Payload)
+	return buf, nil
+}
+
+// Unmarshal ...
+func (m *Extended) Unmarshal(r []byte) error {
+	if len(r) < 1 {
+		return fmt.Errorf("message size should be at least 1")
+	}
+	m.ExtendedID = r[0]
+	m.Payload = make([]byte, len(r)-1)
+	copy(m.Payload, r[1:])
+	return nil
+}
+
+// ExtendedHandshake is the payload of the extended handshake.
+type ExtendedHandshake struct {
+	// M maps extension names to the extended message IDs
+	// the sender wants to receive them with. 0 disables one.
+	M map[string]int `bencode:"m"`
+
+	// V is the client name and version.
+	V string `bencode:"v,omitempty"`
+
+	// P is the local TCP listen port of the sender.
+	P int `bencode:"p,omitempty"`
+
+	// ReqQ is the number of outstanding requests the sender allows.
+	ReqQ int `bencode:"reqq,omitempty"`
+
+	// YourIP is the compact IP address (4 or 16 bytes) of the receiver
+	// as the sender sees it.
+	YourIP []byte `bencode:"yourip,omitempty"`
+
+	// MetadataSize is the size of the info dictionary (BEP 9).
+	MetadataSize int `bencode:"metadata_size,omitempty"`
+}
+
+var _ Message = &ExtendedHandshake{}
+
+// Marshal ...
+func (m *ExtendedHandshake) Marshal() ([]byte, error) {
+	return bencode.EncodeBytes(m)
+}
+
+// Unmarshal ...
+func (m *ExtendedHandshake) Unmarshal(r []byte) error {
+	return bencode.DecodeBytes(r, m)
+}
@@ -0,0 +1,37 @@
+package message
+
+// Messages of the Fast Extension (BEP 6).
+
+// SuggestPiece tells the peer that it may want to download the piece.
+type SuggestPiece struct {
+	Have
+}
+
+// HaveAll replaces bitfield if the sender has all pieces.
+type HaveAll struct {
+	_Empty
+}
+
+// HaveNone replaces bitfield if the sender has no pieces.
+type HaveNone struct {
+	_Empty
+}
+
+// RejectRequest tells the peer that the request won't be satisfied.
+type RejectRequest struct {
+	Request
+}
+
+// AllowedFast tells the peer that it can request the piece
+// even if it is choked.
+type AllowedFast struct {
+	Have
+}
+
+var (
+	_ Message = &SuggestPiece{}
+	_ Message = &HaveAll{}
+	_ Message = &HaveNone{}
+	_ Message = &RejectRequest{}
+	_ Message = &AllowedFast{}
+)
@@ -4,17 +4,44 @@ import (
 	"bytes"
 	"crypto/sha1"
 	"fmt"
-	"log"
 
 	"github.com/movsb/torrent/pkg/common"
 )
 
 // Handshake ...
 type Handshake struct {
+	Reserved Reserved
 	InfoHash common.Hash
 	PeerID   common.PeerID
 }
 
+// Reserved is the 8 reserved bytes in the handshake,
+// each bit of which advertises a protocol extension.
+type Reserved [8]byte
+
+// ReservedBit is the index of a bit in Reserved, counting from
+// the most significant bit of the first byte.
+type ReservedBit int
+
+// Known reserved bits.
+const (
+	// ReservedExtensionProtocol is reserved[5] & 0x10, BEP 10.
+	ReservedExtensionProtocol = ReservedBit(43)
+
+	// ReservedFastExtension is reserved[7] & 0x04, BEP 6.
+	ReservedFastExtension = ReservedBit(61)
+)
+
+// Has tells whether the bit is set.
+func (r Reserved) Has(bit ReservedBit) bool {
+	return r[bit/8]&(0x80>>(bit%8)) != 0
+}
+
+// Set sets the bit.
+func (r *Reserved) Set(bit ReservedBit) {
+	r[bit/8] |= 0x80 >> (bit % 8)
+}
+
 // Handshake indeed is not a BitTorrent message.
 // We just put it here for convenience.
 var _ Message = &Handshake{}
@@ -22,7 +49,7 @@ var _ Message = &Handshake{}
 var (
 	handshakeStart    = byte(19)
 	handshakeString   = `BitTorrent protocol`
-	handshakeReserved = [8]byte{0, 0, 0, 0, 0, 0, 0, 0}
+	handshakeReserved = Reserved{}
 
 	// HandshakeLength ...
 	HandshakeLength        = 1 + len(handshakeString) + len(handshakeReserved) + sha1.Size + common.PeerIDLength
@@ -36,7 +63,7 @@ func (m *Handshake) Marshal() ([]byte, error) {
 	b.Grow(HandshakeLength)
 	b.WriteByte(handshakeStart)
 	b.WriteString(handshakeString)
-	b.Write(handshakeReserved[:])
+	b.Write(m.Reserved[:])
 	b.Write(m.InfoHash[:])
 	b.Write(m.PeerID[:])
 	return b.Bytes(), nil
@@ -54,10 +81,7 @@ func (m *Handshake) Unmarshal(r []byte) error {
 	if btProto := string(r[1 : 1+19]); btProto != handshakeString {
 		return fmt.Errorf("handshake: invalid protocol: %s", btProto)
 	}
-	if reserved := r[20 : 20+8]; !bytes.Equal(reserved, handshakeReserved[:]) {
-		log.Printf("handshake: invalid reserved: %x\n", reserved)
-		// return fmt.Errorf("handshake: invalid reserved: %x", reserved)
-	}
+	copy(m.Reserved[:], r[20:20+8])
 
 	start := handshakeInfoHashStart
 	copy(m.InfoHash[:], r[start:start+sha1.Size])
@@ -0,0 +1,64 @@
+package metadata
+
+import (
+	"github.com/movsb/torrent/pkg/message"
+	"github.com/movsb/torrent/pkg/peer"
+)
+
+// Extension serves the metadata to peers who request it.
+type Extension struct {
+	// The raw info dictionary.
+	Info []byte
+}
+
+var _ interface {
+	peer.Extension
+	peer.HandshakeFiller
+} = &Extension{}
+
+// Name ...
+func (e *Extension) Name() string {
+	return ExtensionName
+}
+
+// FillHandshake ...
+func (e *Extension) FillHandshake(p *peer.Peer, hs *message.ExtendedHandshake) {
+	hs.MetadataSize = len(e.Info)
+}
+
+// HandleMessage ...
+func (e *Extension) HandleMessage(p *peer.Peer, payload []byte) error {
+	var m Message
+	if err := m.Unmarshal(payload); err != nil {
+		return err
+	}
+	if m.Type != MsgRequest {
+		return nil
+	}
+
+	reply := Message{
+		Type:  MsgReject,
+		Piece: m.Piece,
+	}
+	// Checked before multiplied, which may overflow.
+	if m.Piece >= 0 && m.Piece < PieceCount(len(e.Info)) {
+		begin := m.Piece * BlockSize
+		end := begin + BlockSize
+		if end > len(e.Info) {
+			end = len(e.Info)
+		}
+		reply.Type = MsgData
+		reply.TotalSize = len(e.Info)
+		reply.Data = e.Info[begin:end]
+	}
+
+	return sendMessage(p, &reply)
+}
+
+func sendMessage(p *peer.Peer, m *Message) error {
+	b, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+	return p.SendExtended(ExtensionName, b)
+}
@@ -0,0 +1,218 @@
+package metadata
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/movsb/torrent/pkg/common"
+	"github.com/movsb/torrent/pkg/message"
+	"github.com/movsb/torrent/pkg/peer"
+)
+
+const (
+	maxConcurrentPeers = 8
+	fetchTimeout       = time.Minute
+)
+
+// Fetcher downloads the info dictionary of a torrent from peers
+// via the metadata extension (BEP 9).
+type Fetcher struct {
+	InfoHash common.Hash
+	MyPeerID common.PeerID
+}
+
+// Fetch tries the peers concurrently, and returns the raw info dictionary
+// from the first peer that gives one matching the info hash.
+func (f *Fetcher) Fetch(ctx context.Context, peers []string) ([]byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg     sync.WaitGroup
+		sem    = make(chan struct{}, maxConcurrentPeers)
+		result = make(chan []byte, 1)
+	)
+
+loop:
+	for _, address := range peers {
+		select {
+		case <-ctx.Done():
+			break loop
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(address string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			info, err := f.fetchFrom(ctx, address)
+			if err != nil {
+				log.Printf("metadata: fetch from %s failed: %v", address, err)
+				return
+			}
+			select {
+			case result <- info:
+				cancel()
+			default:
+			}
+		}(address)
+	}
+
+	go func() {
+		wg.Wait()
+		close(result)
+	}()
+
+	info, ok := <-result
+	if !ok {
+		return nil, fmt.Errorf("metadata: no peer gave the metadata")
+	}
+	return info, nil
+}
+
+func (f *Fetcher) fetchFrom(ctx context.Context, address string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	dialer := net.Dialer{Timeout: time.Second * 10}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// Unblocks reads & writes on cancellation.
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	handshake, err := peer.HandshakeOutgoing(conn, 10, f.InfoHash, f.MyPeerID)
+	if err != nil {
+		return nil, err
+	}
+	if !handshake.Reserved.Has(message.ReservedExtensionProtocol) {
+		return nil, fmt.Errorf("extension protocol is not supported")
+	}
+
+	d := &_Downloader{infoHash: f.InfoHash}
+	c := peer.Peer{
+		Ctx:         ctx,
+		InfoHash:    f.InfoHash,
+		HerPeerID:   handshake.PeerID,
+		PeerAddr:    address,
+		HerReserved: handshake.Reserved,
+		Extensions:  peer.NewExtensions(d),
+	}
+	c.SetConn(conn)
+
+	if err := c.SendExtendedHandshake(); err != nil {
+		return nil, err
+	}
+
+	for !d.complete {
+		id, msg, err := c.Recv()
+		if err != nil {
+			return nil, err
+		}
+		if id != message.MsgExtended || msg == nil {
+			continue
+		}
+		if err := c.HandleExtended(msg.(*message.Extended)); err != nil {
+			return nil, err
+		}
+	}
+
+	return d.info, nil
+}
+
+// _Downloader is the extension that requests
+// all the metadata pieces from a peer.
+type _Downloader struct {
+	infoHash common.Hash
+
+	info     []byte
+	received []bool
+	remain   int
+	complete bool
+}
+
+func (d *_Downloader) Name() string {
+	return ExtensionName
+}
+
+func (d *_Downloader) HandleHandshake(p *peer.Peer, hs *message.ExtendedHandshake) error {
+	if d.info != nil {
+		return nil
+	}
+	if p.HerExtensionID(ExtensionName) == 0 {
+		return fmt.Errorf("%s is not supported", ExtensionName)
+	}
+	if hs.MetadataSize <= 0 || hs.MetadataSize > MaxSize {
+		return fmt.Errorf("invalid metadata size: %d", hs.MetadataSize)
+	}
+
+	d.info = make([]byte, hs.MetadataSize)
+	d.remain = PieceCount(hs.MetadataSize)
+	d.received = make([]bool, d.remain)
+
+	for i := 0; i < len(d.received); i++ {
+		if err := sendMessage(p, &Message{Type: MsgRequest, Piece: i}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *_Downloader) HandleMessage(p *peer.Peer, payload []byte) error {
+	if d.info == nil {
+		return fmt.Errorf("metadata message before extended handshake")
+	}
+
+	var m Message
+	if err := m.Unmarshal(payload); err != nil {
+		return err
+	}
+	switch m.Type {
+	case MsgReject:
+		return fmt.Errorf("piece %d rejected", m.Piece)
+	case MsgRequest:
+		return sendMessage(p, &Message{Type: MsgReject, Piece: m.Piece})
+	case MsgData:
+	default:
+		return fmt.Errorf("unknown message type: %d", m.Type)
+	}
+
+	if m.Piece < 0 || m.Piece >= len(d.received) {
+		return fmt.Errorf("invalid piece: %d", m.Piece)
+	}
+	begin := m.Piece * BlockSize
+	end := begin + BlockSize
+	if end > len(d.info) {
+		end = len(d.info)
+	}
+	if len(m.Data) != end-begin {
+		return fmt.Errorf("invalid piece size: %d", len(m.Data))
+	}
+	if d.received[m.Piece] {
+		return nil
+	}
+
+	copy(d.info[begin:end], m.Data)
+	d.received[m.Piece] = true
+
+	if d.remain--; d.remain == 0 {
+		if sum := sha1.Sum(d.info); !d.infoHash.Equal(sum) {
+			return fmt.Errorf("info hash mismatch")
+		}
+		d.complete = true
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package metadata
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"net"
+	"testing"
+
+	"github.com/movsb/torrent/pkg/common"
+	"github.com/movsb/torrent/pkg/message"
+	"github.com/movsb/torrent/pkg/peer"
+)
+
+func TestFetch(t *testing.T) {
+	info := bytes.Repeat([]byte(`0123456789`), BlockSize/5)
+	infoHash := common.Hash(sha1.Sum(info))
+
+	var seederID, leecherID common.PeerID
+	copy(seederID[:], `seeder-0123456789012`)
+	copy(leecherID[:], `leecher-012345678901`)
+
+	lis, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handshake, err := peer.HandshakeIncoming(conn, 10, seederID,
+			func(*message.Handshake) error { return nil },
+		)
+		if err != nil {
+			return
+		}
+		c := peer.Peer{
+			HerReserved: handshake.Reserved,
+			Extensions:  peer.NewExtensions(&Extension{Info: info}),
+		}
+		c.SetConn(conn)
+		if err := c.SendExtendedHandshake(); err != nil {
+			return
+		}
+		for {
+			_, msg, err := c.Recv()
+			if err != nil {
+				return
+			}
+			if ext, ok := msg.(*message.Extended); ok {
+				if err := c.HandleExtended(ext); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	f := Fetcher{
+		InfoHash: infoHash,
+		MyPeerID: leecherID,
+	}
+	got, err := f.Fetch(context.Background(), []string{lis.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, info) {
+		t.Fatal("metadata mismatch")
+	}
+}
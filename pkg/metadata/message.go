@@ -0,0 +1,79 @@
+package metadata
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/zeebo/bencode"
+)
+
+// ExtensionName is the name of the metadata extension
+// in the extended handshake.
+const ExtensionName = `ut_metadata`
+
+// BlockSize is the size of each metadata piece, except the last one.
+const BlockSize = 16 << 10
+
+// MaxSize is the max metadata size we accept.
+const MaxSize = 16 << 20
+
+// MsgType ...
+type MsgType int
+
+// Known message types.
+const (
+	MsgRequest = MsgType(0)
+	MsgData    = MsgType(1)
+	MsgReject  = MsgType(2)
+)
+
+// Message is a ut_metadata message.
+// For data messages, the piece data follows the bencoded dictionary.
+type Message struct {
+	Type      MsgType
+	Piece     int
+	TotalSize int
+	Data      []byte
+}
+
+type _Header struct {
+	Type      MsgType `bencode:"msg_type"`
+	Piece     int     `bencode:"piece"`
+	TotalSize int     `bencode:"total_size,omitempty"`
+}
+
+// Marshal ...
+func (m *Message) Marshal() ([]byte, error) {
+	b, err := bencode.EncodeBytes(_Header{
+		Type:      m.Type,
+		Piece:     m.Piece,
+		TotalSize: m.TotalSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(b, m.Data...), nil
+}
+
+// Unmarshal ...
+func (m *Message) Unmarshal(r []byte) error {
+	var h _Header
+	d := bencode.NewDecoder(bytes.NewReader(r))
+	if err := d.Decode(&h); err != nil {
+		return fmt.Errorf("metadata: unmarshal: %v", err)
+	}
+	m.Type = h.Type
+	m.Piece = h.Piece
+	m.TotalSize = h.TotalSize
+	m.Data = nil
+	if n := d.BytesParsed(); n < len(r) {
+		m.Data = make([]byte, len(r)-n)
+		copy(m.Data, r[n:])
+	}
+	return nil
+}
+
+// PieceCount returns the number of pieces of metadata of size.
+func PieceCount(size int) int {
+	return (size + BlockSize - 1) / BlockSize
+}
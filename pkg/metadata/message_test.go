@@ -0,0 +1,34 @@
+package metadata
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMessage(t *testing.T) {
+	m := Message{
+		Type:      MsgData,
+		Piece:     1,
+		TotalSize: BlockSize + 3,
+		Data:      []byte(`abc`),
+	}
+	b, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `d8:msg_typei1e5:piecei1e10:total_sizei16387eeabc`; string(b) != want {
+		t.Fatalf("got %q, want %q", b, want)
+	}
+
+	var got Message
+	if err := got.Unmarshal(b); err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != m.Type || got.Piece != m.Piece || got.TotalSize != m.TotalSize || !bytes.Equal(got.Data, m.Data) {
+		t.Fatalf("got %+v, want %+v", got, m)
+	}
+
+	if n := PieceCount(m.TotalSize); n != 2 {
+		t.Fatalf("bad piece count: %d", n)
+	}
+}
@@ -0,0 +1,144 @@
+package peer
+
+import (
+	"fmt"
+
+	"github.com/movsb/torrent/pkg/message"
+)
+
+// The default number of outstanding requests to a peer,
+// lowered to her reqq if she allows fewer.
+const defaultRequestQueue = 16
+
+// Downloader feeds peers with blocks to request, and takes the blocks
+// they receive. It is shared by all peers of a task, and its methods
+// are called from the goroutines running the peers.
+type Downloader interface {
+	// NextRequests returns at most n blocks to request from p.
+	NextRequests(p *Peer, n int) []message.Request
+
+	// OnBlock is called when a requested block is received from p.
+	OnBlock(p *Peer, block *message.Piece)
+
+	// OnDropped is called when requests to p won't be satisfied, because
+	// they are rejected or discarded, or the peer exits.
+	OnDropped(p *Peer, requests []message.Request)
+}
+
+// CanRequest tells whether we can request blocks of the piece from her now.
+// It must be called from the goroutine running the peer,
+// which is the case for methods of Downloader.
+func (c *Peer) CanRequest(index int) bool {
+	if !c.unchoked && !c.herAllowedFast[index] {
+		return false
+	}
+	return c.HerBitField.HasPiece(index)
+}
+
+// Wake wakes the peer up to request more blocks, as new blocks are available.
+func (c *Peer) Wake() {
+	select {
+	case c.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// CancelRequest cancels an outstanding request, if it is still outstanding.
+// The Downloader won't be told about it.
+func (c *Peer) CancelRequest(req message.Request) error {
+	c.reqMu.Lock()
+	_, ok := c.requests[req]
+	delete(c.requests, req)
+	c.reqMu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return c.Send(message.MsgCancel, &message.Cancel{Request: req})
+}
+
+func (c *Peer) requestQueue() int {
+	n := defaultRequestQueue
+	if hs := c.HerExtendedHandshake(); hs != nil && hs.ReqQ > 0 && hs.ReqQ < n {
+		n = hs.ReqQ
+	}
+	return n
+}
+
+// fillRequests requests more blocks from the downloader
+// to keep the request queue full.
+func (c *Peer) fillRequests() error {
+	if c.Downloader == nil {
+		return nil
+	}
+	if !c.unchoked && len(c.herAllowedFast) == 0 {
+		return nil
+	}
+
+	c.reqMu.Lock()
+	n := c.requestQueue() - len(c.requests)
+	c.reqMu.Unlock()
+	if n <= 0 {
+		return nil
+	}
+
+	reqs := c.Downloader.NextRequests(c, n)
+	for i, req := range reqs {
+		c.reqMu.Lock()
+		c.requests[req] = struct{}{}
+		c.reqMu.Unlock()
+		if err := c.Send(message.MsgRequest, &req); err != nil {
+			c.dropRequests(reqs[i:]...)
+			return fmt.Errorf("send request failed: %v", err)
+		}
+	}
+	return nil
+}
+
+// dropRequests removes outstanding requests, and tells the downloader.
+// With no requests given, all outstanding requests are dropped.
+func (c *Peer) dropRequests(reqs ...message.Request) {
+	c.reqMu.Lock()
+	if len(reqs) == 0 {
+		for req := range c.requests {
+			reqs = append(reqs, req)
+		}
+	}
+	var dropped []message.Request
+	for _, req := range reqs {
+		if _, ok := c.requests[req]; ok {
+			delete(c.requests, req)
+			dropped = append(dropped, req)
+		}
+	}
+	c.reqMu.Unlock()
+
+	if len(dropped) > 0 && c.Downloader != nil {
+		c.Downloader.OnDropped(c, dropped)
+	}
+}
+
+// handleBlock handles a block she sent, which must be requested.
+func (c *Peer) handleBlock(block *message.Piece) {
+	req := message.Request{
+		Index:  block.Index,
+		Begin:  block.Begin,
+		Length: len(block.Data),
+	}
+
+	c.reqMu.Lock()
+	_, ok := c.requests[req]
+	delete(c.requests, req)
+	c.reqMu.Unlock()
+
+	// It may have been cancelled.
+	if !ok {
+		return
+	}
+
+	c.addDownloaded(len(block.Data))
+
+	if c.Downloader != nil {
+		c.Downloader.OnBlock(c, block)
+	}
+}
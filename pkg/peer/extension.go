@@ -0,0 +1,254 @@
+package peer
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/movsb/torrent/pkg/message"
+)
+
+// The client name and version sent as v in the extended handshake.
+const myVersion = `movsb/torrent 0.1`
+
+// Extension is a protocol extension built on top of
+// the extension protocol (BEP 10).
+type Extension interface {
+	// Name is the key in the m dictionary of the extended handshake.
+	Name() string
+
+	// HandleMessage handles an extension message the peer sent us.
+	HandleMessage(p *Peer, payload []byte) error
+}
+
+// HandshakeFiller is implemented by extensions that
+// add their fields to the extended handshake we send.
+type HandshakeFiller interface {
+	FillHandshake(p *Peer, hs *message.ExtendedHandshake)
+}
+
+// HandshakeHandler is implemented by extensions that want to know
+// the extended handshake the peer sent us.
+type HandshakeHandler interface {
+	HandleHandshake(p *Peer, hs *message.ExtendedHandshake) error
+}
+
+// Extensions is a registry of extensions, usually shared by all peers of a task.
+// Extended message IDs are assigned in the order of registration, starting from 1.
+type Extensions struct {
+	// ListenPort is sent as p in the extended handshake, if non-zero.
+	ListenPort int
+
+	mu   sync.RWMutex
+	list []Extension
+}
+
+// NewExtensions ...
+func NewExtensions(exts ...Extension) *Extensions {
+	e := &Extensions{}
+	for _, ext := range exts {
+		e.Register(ext)
+	}
+	return e
+}
+
+// Register adds an extension to the registry.
+// Peers that have already exchanged extended handshakes won't know it.
+func (e *Extensions) Register(ext Extension) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, x := range e.list {
+		if x.Name() == ext.Name() {
+			panic(fmt.Sprintf("extension %s already registered", ext.Name()))
+		}
+	}
+	e.list = append(e.list, ext)
+}
+
+// Get returns the extension by name.
+func (e *Extensions) Get(name string) Extension {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for _, x := range e.list {
+		if x.Name() == name {
+			return x
+		}
+	}
+	return nil
+}
+
+func (e *Extensions) byID(id byte) Extension {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if id == 0 || int(id) > len(e.list) {
+		return nil
+	}
+	return e.list[id-1]
+}
+
+func (e *Extensions) all() []Extension {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return append([]Extension(nil), e.list...)
+}
+
+// SupportsExtensionProtocol tells whether both of us support the extension protocol.
+func (c *Peer) SupportsExtensionProtocol() bool {
+	return c.HerReserved.Has(message.ReservedExtensionProtocol)
+}
+
+// SendExtendedHandshake sends our extended handshake,
+// if the peer supports the extension protocol.
+func (c *Peer) SendExtendedHandshake() error {
+	if !c.SupportsExtensionProtocol() {
+		return nil
+	}
+
+	hs := message.ExtendedHandshake{
+		M:    make(map[string]int),
+		V:    myVersion,
+		ReqQ: myRequestQueue,
+	}
+
+	if c.Extensions != nil {
+		hs.P = c.Extensions.ListenPort
+		for i, ext := range c.Extensions.all() {
+			hs.M[ext.Name()] = i + 1
+			if filler, ok := ext.(HandshakeFiller); ok {
+				filler.FillHandshake(c, &hs)
+			}
+		}
+	}
+
+	if addr, ok := c.conn.RemoteAddr().(*net.TCPAddr); ok {
+		if ip := addr.IP.To4(); ip != nil {
+			hs.YourIP = ip
+		} else {
+			hs.YourIP = addr.IP.To16()
+		}
+	}
+
+	payload, err := hs.Marshal()
+	if err != nil {
+		return fmt.Errorf("peer: marshal extended handshake: %v", err)
+	}
+
+	return c.Send(message.MsgExtended, &message.Extended{
+		ExtendedID: message.ExtendedHandshakeID,
+		Payload:    payload,
+	})
+}
+
+// HerExtendedHandshake returns the extended handshake the peer sent,
+// nil if not received yet.
+func (c *Peer) HerExtendedHandshake() *message.ExtendedHandshake {
+	c.extMu.RLock()
+	defer c.extMu.RUnlock()
+	return c.herExtended
+}
+
+// HerExtensionID returns the extended message ID the peer wants for
+// the named extension, or 0 if she doesn't support it.
+func (c *Peer) HerExtensionID(name string) byte {
+	c.extMu.RLock()
+	defer c.extMu.RUnlock()
+
+	if c.herExtended == nil {
+		return 0
+	}
+	id := c.herExtended.M[name]
+	if id <= 0 || id > 255 {
+		return 0
+	}
+	return byte(id)
+}
+
+// ListenAddr returns the address she listens on. For incoming connections,
+// it is built from the port in her extended handshake, empty if unknown.
+func (c *Peer) ListenAddr() string {
+	if !c.Incoming {
+		return c.PeerAddr
+	}
+	hs := c.HerExtendedHandshake()
+	if hs == nil || hs.P <= 0 || hs.P > 65535 {
+		return ``
+	}
+	host, _, err := net.SplitHostPort(c.PeerAddr)
+	if err != nil {
+		return ``
+	}
+	return net.JoinHostPort(host, strconv.Itoa(hs.P))
+}
+
+// SendExtended sends an extension message to the peer.
+func (c *Peer) SendExtended(name string, payload []byte) error {
+	id := c.HerExtensionID(name)
+	if id == 0 {
+		return fmt.Errorf("peer: extension %s is not supported by peer", name)
+	}
+	return c.Send(message.MsgExtended, &message.Extended{
+		ExtendedID: id,
+		Payload:    payload,
+	})
+}
+
+// HandleExtended handles an extended message by dispatching it
+// to the registered extensions.
+func (c *Peer) HandleExtended(m *message.Extended) error {
+	if m.ExtendedID == message.ExtendedHandshakeID {
+		return c.handleExtendedHandshake(m.Payload)
+	}
+
+	if c.Extensions == nil {
+		return fmt.Errorf("peer: unexpected extended message: %d", m.ExtendedID)
+	}
+	ext := c.Extensions.byID(m.ExtendedID)
+	if ext == nil {
+		log.Printf("peer: unknown extended message: %d", m.ExtendedID)
+		return nil
+	}
+	if err := ext.HandleMessage(c, m.Payload); err != nil {
+		return fmt.Errorf("peer: extension %s: %v", ext.Name(), err)
+	}
+	return nil
+}
+
+func (c *Peer) handleExtendedHandshake(payload []byte) error {
+	hs := &message.ExtendedHandshake{}
+	if err := hs.Unmarshal(payload); err != nil {
+		return fmt.Errorf("peer: bad extended handshake: %v", err)
+	}
+
+	// Later handshakes update the former ones.
+	c.extMu.Lock()
+	if old := c.herExtended; old != nil {
+		for name, id := range old.M {
+			if _, ok := hs.M[name]; !ok {
+				if hs.M == nil {
+					hs.M = make(map[string]int)
+				}
+				hs.M[name] = id
+			}
+		}
+	}
+	c.herExtended = hs
+	c.extMu.Unlock()
+
+	if c.Extensions == nil {
+		return nil
+	}
+	for _, ext := range c.Extensions.all() {
+		if handler, ok := ext.(HandshakeHandler); ok {
+			if err := handler.HandleHandshake(c, hs); err != nil {
+				return fmt.Errorf("peer: extension %s: %v", ext.Name(), err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package peer
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"net"
+	"sync/atomic"
+
+	"github.com/movsb/torrent/pkg/common"
+	"github.com/movsb/torrent/pkg/message"
+)
+
+// The number of pieces in the allowed fast set.
+const allowedFastCount = 10
+
+// AllowedFastSet computes the allowed fast set of k pieces for
+// the peer at ip, as described in BEP 6.
+// Only IPv4 addresses are supported, nil is returned for others.
+func AllowedFastSet(ip net.IP, infoHash common.Hash, pieceCount int, k int) []int {
+	ip = ip.To4()
+	if ip == nil || pieceCount <= 0 {
+		return nil
+	}
+	if k > pieceCount {
+		k = pieceCount
+	}
+
+	x := make([]byte, 0, 4+len(infoHash))
+	x = append(x, ip[0], ip[1], ip[2], 0)
+	x = append(x, infoHash[:]...)
+
+	set := make([]int, 0, k)
+	has := make(map[int]bool, k)
+
+	for len(set) < k {
+		sum := sha1.Sum(x)
+		x = sum[:]
+		for i := 0; i < 5 && len(set) < k; i++ {
+			y := binary.BigEndian.Uint32(x[i*4:])
+			index := int(y % uint32(pieceCount))
+			if !has[index] {
+				has[index] = true
+				set = append(set, index)
+			}
+		}
+	}
+
+	return set
+}
+
+// SupportsFastExtension tells whether both of us support the fast extension.
+func (c *Peer) SupportsFastExtension() bool {
+	return c.HerReserved.Has(message.ReservedFastExtension)
+}
+
+// SendBitField sends our bitfield. With the fast extension,
+// HaveAll or HaveNone is sent instead if possible.
+func (c *Peer) SendBitField() error {
+	if c.SupportsFastExtension() {
+		switch {
+		case c.MyBitField.AllOnes():
+			return c.Send(message.MsgHaveAll, &message.HaveAll{})
+		case c.MyBitField.AllZeros():
+			return c.Send(message.MsgHaveNone, &message.HaveNone{})
+		}
+	}
+	return c.Send(message.MsgBitField, c.MyBitField)
+}
+
+// SendAllowedFast computes the allowed fast set of the peer and sends it,
+// so that she can bootstrap while we are choking her.
+func (c *Peer) SendAllowedFast() error {
+	if !c.SupportsFastExtension() {
+		return nil
+	}
+	addr, ok := c.conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return nil
+	}
+
+	set := AllowedFastSet(addr.IP, c.InfoHash, c.PM.PieceCount(), allowedFastCount)
+	c.myAllowedFast = make(map[int]bool, len(set))
+	for _, index := range set {
+		c.myAllowedFast[index] = true
+		if err := c.Send(message.MsgAllowedFast, &message.AllowedFast{
+			Have: message.Have{Index: index},
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Choke chokes the peer, her queued requests are discarded.
+func (c *Peer) Choke() error {
+	atomic.StoreInt32(&c.amChoking, 1)
+	if err := c.Send(message.MsgChoke, &message.Choke{}); err != nil {
+		return err
+	}
+	return c.clearUploads()
+}
+
+// UnChoke unchokes the peer.
+func (c *Peer) UnChoke() error {
+	if err := c.Send(message.MsgUnChoke, &message.UnChoke{}); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&c.amChoking, 0)
+	return nil
+}
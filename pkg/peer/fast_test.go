@@ -0,0 +1,64 @@
+package peer
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/movsb/torrent/pkg/common"
+	"github.com/movsb/torrent/pkg/message"
+)
+
+// The test vectors from BEP 6.
+func TestAllowedFastSet(t *testing.T) {
+	var ih common.Hash
+	ih.Set(bytes.Repeat([]byte{0xAA}, 20))
+	ip := net.ParseIP(`80.4.4.200`)
+
+	if got, want := AllowedFastSet(ip, ih, 1313, 7), []int{1059, 431, 808, 1217, 287, 376, 1188}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := AllowedFastSet(ip, ih, 1313, 9), []int{1059, 431, 808, 1217, 287, 376, 1188, 353, 508}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestFastMessagesWithoutExtension(t *testing.T) {
+	var c Peer
+	for _, msg := range []message.Message{
+		&message.SuggestPiece{},
+		&message.AllowedFast{},
+		&message.RejectRequest{},
+	} {
+		if err := c.handleMessage(msg); err == nil {
+			t.Errorf("%T accepted without fast extension", msg)
+		}
+	}
+}
+
+func TestFastMessageIndexes(t *testing.T) {
+	c := newTestPeer()
+	c.HerReserved.Set(message.ReservedFastExtension)
+
+	for _, index := range []int{-1, 12} {
+		if err := c.handleMessage(&message.SuggestPiece{Have: message.Have{Index: index}}); err == nil {
+			t.Errorf("suggest piece %d is accepted", index)
+		}
+		if err := c.handleMessage(&message.AllowedFast{Have: message.Have{Index: index}}); err == nil {
+			t.Errorf("allowed fast %d is accepted", index)
+		}
+	}
+	if len(c.suggested) != 0 || len(c.herAllowedFast) != 0 {
+		t.Fatalf("invalid pieces are kept: %v, %v", c.suggested, c.herAllowedFast)
+	}
+
+	for i := 0; i < 12; i++ {
+		if err := c.handleMessage(&message.AllowedFast{Have: message.Have{Index: i}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(c.herAllowedFast) != allowedFastCount {
+		t.Fatalf("allowed fast set: got %d, want %d", len(c.herAllowedFast), allowedFastCount)
+	}
+}
@@ -48,8 +48,17 @@ func HandshakeIncoming(conn net.Conn, timeout int, myPeerID common.PeerID, onRec
 	return m, nil
 }
 
+// myReserved returns the reserved bytes we send in handshakes.
+func myReserved() message.Reserved {
+	var r message.Reserved
+	r.Set(message.ReservedExtensionProtocol)
+	r.Set(message.ReservedFastExtension)
+	return r
+}
+
 func handshakeSend(conn net.Conn, timeout int, infoHash common.Hash, myPeerID common.PeerID) error {
 	m := message.Handshake{
+		Reserved: myReserved(),
 		InfoHash: infoHash,
 		PeerID:   myPeerID,
 	}
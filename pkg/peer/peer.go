@@ -2,15 +2,15 @@ package peer
 
 import (
 	"bufio"
-	"bytes"
 	"context"
-	"crypto/sha1"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"reflect"
+	"sync"
+	"sync/atomic"
 
 	"github.com/movsb/torrent/pkg/common"
 	"github.com/movsb/torrent/pkg/daemon/store"
@@ -32,10 +32,31 @@ type Peer struct {
 	HerPeerID common.PeerID
 	PeerAddr  string
 
+	// Whether she connected to us.
+	Incoming bool
+
+	// The reserved bytes from her handshake.
+	HerReserved message.Reserved
+
+	// Extensions we support, may be nil.
+	Extensions *Extensions
+
 	OnExit func(p *Peer)
 
-	conn net.Conn
-	rw   *bufio.ReadWriter
+	// OnHave is called when she announces a new piece, may be nil.
+	OnHave func(p *Peer, index int)
+
+	// OnBitField is called to replace HerBitField with bf, when she sends her
+	// bitfield after the peer starts running. HerBitField is replaced directly
+	// if it is nil.
+	OnBitField func(p *Peer, bf *message.BitField)
+
+	conn   net.Conn
+	rw     *bufio.ReadWriter
+	sendMu sync.Mutex
+
+	extMu       sync.RWMutex
+	herExtended *message.ExtendedHandshake
 
 	MyBitField  *message.BitField
 	HerBitField *message.BitField
@@ -44,15 +65,48 @@ type Peer struct {
 
 	msgCh  chan message.Message
 	HaveCh chan int
+	wakeCh chan struct{}
+
+	// Downloader feeds us with blocks to request, may be nil.
+	Downloader Downloader
+
+	// Whether she is choking us.
+	unchoked bool
+
+	// Our outstanding requests.
+	reqMu    sync.Mutex
+	requests map[message.Request]struct{}
+
+	// Her requests to serve, served by another goroutine.
+	upMu      sync.Mutex
+	uploads   []message.Request
+	uploadCh  chan struct{}
+	uploadErr chan error
 
-	curPiece   *SinglePieceData
-	chSetPiece chan *SinglePieceData
-	donePiece  chan error
+	// Closed on exit.
+	quit chan struct{}
 
-	unchoked   bool
-	downloaded int
-	requested  int
-	backlog    int
+	// Whether we are choking her, and whether she is interested in us.
+	// Accessed atomically, as they are used by the choker.
+	amChoking     int32
+	herInterested int32
+
+	// Bytes of piece data received from her and sent to her.
+	// Accessed atomically.
+	downloadedBytes int64
+	uploadedBytes   int64
+
+	// Pieces she can request while choked, and pieces we can
+	// request while choked. (Fast Extension)
+	myAllowedFast  map[int]bool
+	herAllowedFast map[int]bool
+
+	// Pieces she suggested us to download. (Fast Extension)
+	suggested map[int]bool
+
+	// Whether she has sent messages other than extended ones,
+	// after which bitfields are not allowed.
+	gotMessage bool
 }
 
 // tmp
@@ -63,9 +117,17 @@ func (c *Peer) SetConn(conn net.Conn) {
 		bufio.NewWriter(conn),
 	)
 
-	c.chSetPiece = make(chan *SinglePieceData)
 	c.msgCh = make(chan message.Message)
 	c.HaveCh = make(chan int, 16)
+	c.wakeCh = make(chan struct{}, 1)
+	c.requests = make(map[message.Request]struct{})
+	c.uploadCh = make(chan struct{}, 1)
+	c.uploadErr = make(chan error, 1)
+	c.quit = make(chan struct{})
+
+	c.amChoking = 1
+	c.herAllowedFast = make(map[int]bool)
+	c.suggested = make(map[int]bool)
 }
 
 // Close ...
@@ -84,6 +146,10 @@ func (c *Peer) Send(msgID message.MsgID, req message.Marshaler) error {
 	if err != nil {
 		return fmt.Errorf("client: send: %v", err)
 	}
+
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
 	sizeBuf := []byte{0, 0, 0, 0}
 	binary.BigEndian.PutUint32(sizeBuf, 1+uint32(len(b)))
 	if _, err := c.rw.Write(sizeBuf); err != nil {
@@ -148,6 +214,19 @@ func (c *Peer) Recv() (message.MsgID, message.Message, error) {
 	case message.MsgPiece:
 		msg = &message.Piece{}
 	case message.MsgCancel:
+		msg = &message.Cancel{}
+	case message.MsgSuggestPiece:
+		msg = &message.SuggestPiece{}
+	case message.MsgHaveAll:
+		msg = &message.HaveAll{}
+	case message.MsgHaveNone:
+		msg = &message.HaveNone{}
+	case message.MsgRejectRequest:
+		msg = &message.RejectRequest{}
+	case message.MsgAllowedFast:
+		msg = &message.AllowedFast{}
+	case message.MsgExtended:
+		msg = &message.Extended{}
 	}
 
 	if err := msg.Unmarshal(buf[1:]); err != nil {
@@ -168,57 +247,52 @@ keepalive:
 	if msg == nil {
 		goto keepalive
 	}
-	if id != message.MsgBitField {
+	// The extended handshake may be sent before bitfield.
+	if id == message.MsgExtended {
+		if err := c.HandleExtended(msg.(*message.Extended)); err != nil {
+			return err
+		}
+		goto keepalive
+	}
+	switch id {
+	case message.MsgBitField:
+		c.HerBitField = msg.(*message.BitField)
+		c.HerBitField.Init(c.PM.PieceCount())
+		if !c.HerBitField.Valid() {
+			return fmt.Errorf("recv invalid bitfield of %d bytes", len(c.HerBitField.Fields))
+		}
+	case message.MsgHaveAll, message.MsgHaveNone:
+		if !c.SupportsFastExtension() {
+			return fmt.Errorf("recv %v without fast extension", id)
+		}
+		value := byte(0x00)
+		if id == message.MsgHaveAll {
+			value = 0xFF
+		}
+		c.HerBitField = message.NewBitField(c.PM.PieceCount(), value)
+	default:
 		log.Printf("recv non-bitfield message: %v", id)
 		return fmt.Errorf("recv non-bitfield message")
 	}
-	c.HerBitField = msg.(*message.BitField)
-	c.HerBitField.Init(c.PM.PieceCount())
-	return nil
-}
-
-// Download ...
-func (c *Peer) Download(piece SinglePieceData, done chan SinglePieceData) error {
-	c.donePiece = make(chan error)
-	c.chSetPiece <- &piece
-	if err := <-c.donePiece; err != nil {
-		log.Printf("download piece failed: %v\n", err)
-		return fmt.Errorf("download piece failed: %v", err)
-	}
-	close(c.donePiece)
-
-	if err := c.checkIntegrity(&piece); err != nil {
-		log.Printf("check integrity failed: %v\n", err)
-		return fmt.Errorf("check integrity failed: %v", err)
-	}
-
-	done <- piece
-
+	c.gotMessage = true
 	return nil
 }
 
-func (c *Peer) getPendingPiece(pending chan SinglePieceData) (SinglePieceData, bool) {
-	select {
-	case <-c.Ctx.Done():
-		log.Printf("peer: context done")
-		return SinglePieceData{}, false
-	case piece := <-pending:
-		return piece, true
-	}
-}
-
 func (c *Peer) exit(err error) {
 	log.Printf("peer: exiting: %v", err)
+	close(c.quit)
+	c.dropRequests()
 	c.OnExit(c)
 }
 
+// Run ...
 func (c *Peer) Run() {
+	go c.serveUploads()
+
 	for {
-		if c.curPiece != nil {
-			if err := c.sendRequests(c.curPiece); err != nil {
-				c.donePiece <- err
-				return
-			}
+		if err := c.fillRequests(); err != nil {
+			c.exit(err)
+			return
 		}
 		select {
 		case msg := <-c.msgCh:
@@ -236,9 +310,10 @@ func (c *Peer) Run() {
 				c.exit(err)
 				return
 			}
-		case piece := <-c.chSetPiece:
-			c.reset(piece)
-			c.curPiece = piece
+		case <-c.wakeCh:
+		case err := <-c.uploadErr:
+			c.exit(err)
+			return
 		case <-c.Ctx.Done():
 			log.Printf("peer.work: context done: %v", c.Ctx.Err())
 			c.exit(c.Ctx.Err())
@@ -247,16 +322,6 @@ func (c *Peer) Run() {
 	}
 }
 
-func (c *Peer) reset(piece *SinglePieceData) {
-	c.requested = 0
-	c.downloaded = 0
-	c.backlog = 0
-
-	if len(piece.Data) != piece.Length {
-		piece.Data = make([]byte, piece.Length)
-	}
-}
-
 // poll polls messages from peer and sends it to
 // the message channel. On error, the message will be nil.
 func (c *Peer) Poll() {
@@ -280,100 +345,133 @@ func (c *Peer) Poll() {
 	}
 }
 
-func (c *Peer) sendRequests(piece *SinglePieceData) error {
-	for c.unchoked && c.backlog < 5 && c.downloaded < piece.Length && c.requested < piece.Length {
-		blockSize := message.MaxRequestLength
-		if c.requested+blockSize > piece.Length {
-			blockSize = piece.Length - c.requested
-		}
-
-		if err := c.Send(message.MsgRequest, &message.Request{
-			Index:  piece.Index,
-			Begin:  c.requested,
-			Length: blockSize,
-		}); err != nil {
-			return fmt.Errorf("send request failed: %v", err)
-		}
-
-		c.backlog++
-		c.requested += blockSize
+func (c *Peer) handleMessage(msg message.Message) error {
+	// Without the fast extension, incoming peers don't receive the bitfield
+	// before running, she sends it as the first message, if any.
+	first := !c.gotMessage
+	if _, ok := msg.(*message.Extended); !ok {
+		c.gotMessage = true
 	}
-	return nil
-}
 
-func (c *Peer) handleMessage(msg message.Message) error {
-	piece := c.curPiece
 	switch typed := msg.(type) {
 	default:
 		return fmt.Errorf("peer sent unknown message: %v", reflect.TypeOf(typed).String())
+	case *message.BitField:
+		if !first {
+			return fmt.Errorf("peer sent bitfield after other messages")
+		}
+		typed.Init(c.PM.PieceCount())
+		if !typed.Valid() {
+			return fmt.Errorf("peer sent invalid bitfield of %d bytes", len(typed.Fields))
+		}
+		if c.OnBitField != nil {
+			c.OnBitField(c, typed)
+		} else {
+			c.HerBitField = typed
+		}
 	case *message.Choke:
 		c.unchoked = false
 		log.Printf("peer choked\n")
+		// Without the fast extension, pending requests are discarded
+		// implicitly. Otherwise she will reject them explicitly.
+		if !c.SupportsFastExtension() {
+			c.dropRequests()
+		}
 	case *message.UnChoke:
 		c.unchoked = true
 		log.Printf("peer not choked\n")
 	case *message.Interested:
+		atomic.StoreInt32(&c.herInterested, 1)
 		log.Printf("peer interested\n")
+	case *message.NotInterested:
+		atomic.StoreInt32(&c.herInterested, 0)
+		log.Printf("peer not interested\n")
+	case *message.Extended:
+		return c.HandleExtended(typed)
 	case *message.Have:
-		c.HerBitField.SetPiece(typed.Index)
-		// log.Printf("peer has piece %d\n", typed.Index)
-	case *message.Request:
-		request := msg.(*message.Request)
-		if !c.MyBitField.HasPiece(request.Index) {
-			log.Printf("peer requests piece I don't have: %d\n", request.Index)
-			return fmt.Errorf("peer: unexpected piece")
+		if !c.validIndex(typed.Index) {
+			return fmt.Errorf("peer sent have of invalid piece: %d", typed.Index)
 		}
-		piece, err := c.PM.ReadPiece(request.Index)
-		if err != nil {
-			log.Printf("read piece failed: %d, %v\n", request.Index, err)
-			return fmt.Errorf("peer: read piece failed: %v", err)
+		if c.HerBitField.HasPiece(typed.Index) {
+			break
 		}
-		if request.Begin+request.Length > len(piece) {
-			log.Printf("peer requests piece out of bound: %d\n", request.Index)
-			return fmt.Errorf("peer: request piece out of bound")
+		c.HerBitField.SetPiece(typed.Index)
+		if c.OnHave != nil {
+			c.OnHave(c, typed.Index)
 		}
-		if err := c.Send(message.MsgPiece, &message.Piece{
-			Index: request.Index,
-			Begin: request.Begin,
-			Data:  piece[request.Begin : request.Begin+request.Length],
-		}); err != nil {
-			log.Printf("error sent piece: %v\n", err)
-			return fmt.Errorf("peer: error sending piece: %v", err)
+		// log.Printf("peer has piece %d\n", typed.Index)
+	case *message.SuggestPiece:
+		if !c.SupportsFastExtension() {
+			return fmt.Errorf("peer sent suggest piece without fast extension")
 		}
-		log.Printf("upload piece to %s: %d\n", c.PeerAddr, request.Index)
-	case *message.Piece:
-		if piece == nil {
-			return fmt.Errorf("peer sent piece but I'm downloading it")
+		if !c.validIndex(typed.Index) {
+			return fmt.Errorf("peer suggested invalid piece: %d", typed.Index)
 		}
-		pieceRecv := msg.(*message.Piece)
-		if pieceRecv.Index != piece.Index {
-			return fmt.Errorf("peer sent unknown piece index %d", pieceRecv.Index)
+		c.suggested[typed.Index] = true
+	case *message.AllowedFast:
+		if !c.SupportsFastExtension() {
+			return fmt.Errorf("peer sent allowed fast without fast extension")
 		}
-		if pieceRecv.Begin < 0 || pieceRecv.Begin+len(pieceRecv.Data) > len(piece.Data) {
-			return fmt.Errorf(
-				"peer sent data too long: begin: %d + data: %d > data: %d",
-				pieceRecv.Begin, len(pieceRecv.Data), len(piece.Data),
-			)
+		if !c.validIndex(typed.Index) {
+			return fmt.Errorf("peer allowed invalid piece: %d", typed.Index)
 		}
-		copy(piece.Data[pieceRecv.Begin:], pieceRecv.Data)
-		c.downloaded += len(pieceRecv.Data)
-		c.backlog--
-		if c.downloaded == piece.Length {
-			c.donePiece <- nil
+		// More than we allow her is ignored.
+		if len(c.herAllowedFast) >= allowedFastCount {
+			break
 		}
-		//log.Printf("receive piece: index=%d,begin:%d,length:%d backlog:%d,requested:%d,downloaded:%d",
-		//	pieceRecv.Index, pieceRecv.Begin, len(pieceRecv.Data),
-		//	c.backlog, c.requested, c.downloaded,
-		//)
+		c.herAllowedFast[typed.Index] = true
+	case *message.RejectRequest:
+		if !c.SupportsFastExtension() {
+			return fmt.Errorf("peer sent reject without fast extension")
+		}
+		c.dropRequests(typed.Request)
+	case *message.Cancel:
+		c.cancelUpload(typed.Request)
+	case *message.Request:
+		return c.queueRequest(typed)
+	case *message.Piece:
+		c.handleBlock(typed)
 	}
 
 	return nil
 }
 
-func (c *Peer) checkIntegrity(piece *SinglePieceData) error {
-	got := sha1.Sum(piece.Data)
-	if !bytes.Equal(piece.Hash[:], got[:]) {
-		return fmt.Errorf("check integrity failed")
+// validIndex tells whether the piece index she sent is in range.
+func (c *Peer) validIndex(index int) bool {
+	return index >= 0 && index < c.PM.PieceCount()
+}
+
+// AmChoking tells whether we are choking her.
+func (c *Peer) AmChoking() bool {
+	return atomic.LoadInt32(&c.amChoking) != 0
+}
+
+// HerInterested tells whether she is interested in us.
+func (c *Peer) HerInterested() bool {
+	return atomic.LoadInt32(&c.herInterested) != 0
+}
+
+// DownloadedBytes returns the bytes of piece data received from her.
+func (c *Peer) DownloadedBytes() int64 {
+	return atomic.LoadInt64(&c.downloadedBytes)
+}
+
+func (c *Peer) addDownloaded(n int) {
+	atomic.AddInt64(&c.downloadedBytes, int64(n))
+}
+
+// UploadedBytes returns the bytes of piece data sent to her.
+func (c *Peer) UploadedBytes() int64 {
+	return atomic.LoadInt64(&c.uploadedBytes)
+}
+
+// rejectRequest rejects a request of her explicitly with the fast extension,
+// or drops it silently without.
+func (c *Peer) rejectRequest(request *message.Request) error {
+	if !c.SupportsFastExtension() {
+		return nil
 	}
-	return nil
+	return c.Send(message.MsgRejectRequest, &message.RejectRequest{
+		Request: *request,
+	})
 }
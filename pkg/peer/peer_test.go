@@ -0,0 +1,78 @@
+package peer
+
+import (
+	"testing"
+
+	"github.com/movsb/torrent/pkg/common"
+	"github.com/movsb/torrent/pkg/daemon/store"
+	"github.com/movsb/torrent/pkg/message"
+	"github.com/movsb/torrent/pkg/torrent"
+)
+
+// newTestPeer creates a peer of a torrent of 12 pieces stored in memory,
+// without a connection.
+func newTestPeer() *Peer {
+	file := torrent.File{
+		Name:        `a`,
+		Single:      true,
+		Files:       []torrent.Item{{Length: 12 * 16, Paths: []string{`a`}}},
+		Length:      12 * 16,
+		PieceLength: 16,
+		PieceHashes: make(common.PieceHashes, 12*20),
+	}
+	c := &Peer{
+		PM:          store.NewPieceManager(&file, store.NewMemoryStorage(&file)),
+		HerBitField: message.NewBitField(12, 0),
+	}
+	c.herAllowedFast = make(map[int]bool)
+	c.suggested = make(map[int]bool)
+	return c
+}
+
+func TestBitFieldMessage(t *testing.T) {
+	c := newTestPeer()
+	var replaced *message.BitField
+	c.OnBitField = func(p *Peer, bf *message.BitField) {
+		replaced = bf
+		p.HerBitField = bf
+	}
+
+	// The extended handshake may come before the bitfield.
+	if err := c.handleMessage(&message.Extended{ExtendedID: message.ExtendedHandshakeID, Payload: []byte(`de`)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.handleMessage(&message.BitField{Fields: []byte{0x80, 0x10}}); err != nil {
+		t.Fatal(err)
+	}
+	if replaced == nil || !c.HerBitField.HasPiece(0) || !c.HerBitField.HasPiece(11) || c.HerBitField.HasPiece(1) {
+		t.Fatalf("bitfield is not replaced: %v", c.HerBitField.Fields)
+	}
+	if err := c.handleMessage(&message.BitField{Fields: []byte{0xFF, 0xF0}}); err == nil {
+		t.Fatal("second bitfield is accepted")
+	}
+
+	c = newTestPeer()
+	if err := c.handleMessage(&message.BitField{Fields: []byte{0xFF, 0xFF}}); err == nil {
+		t.Fatal("bitfield with spare bits set is accepted")
+	}
+
+	c = newTestPeer()
+	if err := c.handleMessage(&message.Interested{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.handleMessage(&message.BitField{Fields: []byte{0xFF, 0xF0}}); err == nil {
+		t.Fatal("bitfield after other messages is accepted")
+	}
+}
+
+func TestHaveIndex(t *testing.T) {
+	c := newTestPeer()
+	if err := c.handleMessage(&message.Have{Index: 11}); err != nil || !c.HerBitField.HasPiece(11) {
+		t.Fatalf("have: %v", err)
+	}
+	for _, index := range []int{-1, 12} {
+		if err := c.handleMessage(&message.Have{Index: index}); err == nil {
+			t.Errorf("have of piece %d is accepted", index)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package peer
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+
+	"github.com/movsb/torrent/pkg/message"
+)
+
+// The max number of her requests we queue,
+// sent as reqq in the extended handshake.
+const myRequestQueue = 250
+
+// The max length of a block she can request.
+const maxRequestLength = 128 << 10
+
+// queueRequest validates a request of her and queues it to be served.
+func (c *Peer) queueRequest(request *message.Request) error {
+	if c.AmChoking() && !c.myAllowedFast[request.Index] {
+		log.Printf("peer requests while choked: %d\n", request.Index)
+		return c.rejectRequest(request)
+	}
+	if !c.MyBitField.HasPiece(request.Index) {
+		log.Printf("peer requests piece I don't have: %d\n", request.Index)
+		if c.SupportsFastExtension() {
+			return c.rejectRequest(request)
+		}
+		return fmt.Errorf("peer: unexpected piece")
+	}
+	if request.Length <= 0 || request.Length > maxRequestLength {
+		log.Printf("peer requests bad length: %d\n", request.Length)
+		return fmt.Errorf("peer: bad request length: %d", request.Length)
+	}
+
+	c.upMu.Lock()
+	full := len(c.uploads) >= myRequestQueue
+	if !full {
+		c.uploads = append(c.uploads, *request)
+	}
+	c.upMu.Unlock()
+
+	if full {
+		log.Printf("peer exceeds request queue: %s\n", c.PeerAddr)
+		return c.rejectRequest(request)
+	}
+
+	select {
+	case c.uploadCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// cancelUpload removes a queued request of her, if it's not served yet.
+func (c *Peer) cancelUpload(request message.Request) {
+	c.upMu.Lock()
+	defer c.upMu.Unlock()
+	for i, r := range c.uploads {
+		if r == request {
+			c.uploads = append(c.uploads[:i], c.uploads[i+1:]...)
+			return
+		}
+	}
+}
+
+// clearUploads removes queued requests on choking her, except those of
+// the allowed fast pieces. They are rejected with the fast extension.
+func (c *Peer) clearUploads() error {
+	var rejected []message.Request
+
+	c.upMu.Lock()
+	kept := c.uploads[:0]
+	for _, r := range c.uploads {
+		if c.myAllowedFast[r.Index] {
+			kept = append(kept, r)
+		} else {
+			rejected = append(rejected, r)
+		}
+	}
+	c.uploads = kept
+	c.upMu.Unlock()
+
+	for i := range rejected {
+		if err := c.rejectRequest(&rejected[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Peer) nextUpload() (message.Request, bool) {
+	c.upMu.Lock()
+	defer c.upMu.Unlock()
+	if len(c.uploads) == 0 {
+		return message.Request{}, false
+	}
+	r := c.uploads[0]
+	c.uploads = c.uploads[1:]
+	return r, true
+}
+
+// serveUploads serves the queued requests of her in order,
+// until the peer exits. Errors are sent to uploadErr.
+func (c *Peer) serveUploads() {
+	for {
+		select {
+		case <-c.uploadCh:
+		case <-c.quit:
+			return
+		}
+		for {
+			request, ok := c.nextUpload()
+			if !ok {
+				break
+			}
+			if err := c.upload(&request); err != nil {
+				select {
+				case c.uploadErr <- err:
+				default:
+				}
+				return
+			}
+		}
+	}
+}
+
+func (c *Peer) upload(request *message.Request) error {
+	// She may have been choked since the request was queued.
+	if c.AmChoking() && !c.myAllowedFast[request.Index] {
+		return nil
+	}
+	piece, err := c.PM.ReadPiece(request.Index)
+	if err != nil {
+		log.Printf("read piece failed: %d, %v\n", request.Index, err)
+		return fmt.Errorf("peer: read piece failed: %v", err)
+	}
+	if request.Begin < 0 || request.Begin+request.Length > len(piece) {
+		log.Printf("peer requests piece out of bound: %d\n", request.Index)
+		return fmt.Errorf("peer: request piece out of bound")
+	}
+	if err := c.Send(message.MsgPiece, &message.Piece{
+		Index: request.Index,
+		Begin: request.Begin,
+		Data:  piece[request.Begin : request.Begin+request.Length],
+	}); err != nil {
+		log.Printf("error sent piece: %v\n", err)
+		return fmt.Errorf("peer: error sending piece: %v", err)
+	}
+	atomic.AddInt64(&c.uploadedBytes, int64(request.Length))
+	log.Printf("upload piece to %s: %d\n", c.PeerAddr, request.Index)
+	return nil
+}
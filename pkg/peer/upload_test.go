@@ -0,0 +1,45 @@
+package peer
+
+import (
+	"net"
+	"testing"
+
+	"github.com/movsb/torrent/pkg/message"
+)
+
+func TestUploadQueue(t *testing.T) {
+	conn, _ := net.Pipe()
+	defer conn.Close()
+
+	c := Peer{
+		MyBitField: message.NewBitField(8, 0xFF),
+	}
+	c.SetConn(conn)
+	c.amChoking = 0
+
+	for i := 0; i < myRequestQueue+10; i++ {
+		req := message.Request{Index: i % 8, Begin: i * 16384, Length: 16384}
+		if err := c.queueRequest(&req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(c.uploads) != myRequestQueue {
+		t.Fatalf("queue length: got %d, want %d", len(c.uploads), myRequestQueue)
+	}
+
+	c.cancelUpload(message.Request{Index: 1, Begin: 16384, Length: 16384})
+	if len(c.uploads) != myRequestQueue-1 {
+		t.Fatalf("queue length after cancel: got %d, want %d", len(c.uploads), myRequestQueue-1)
+	}
+	if r, _ := c.nextUpload(); r.Index != 0 {
+		t.Fatalf("first request: got %v", r)
+	}
+	if r, _ := c.nextUpload(); r.Index != 2 {
+		t.Fatalf("second request: got %v", r)
+	}
+
+	bad := message.Request{Index: 0, Length: maxRequestLength + 1}
+	if err := c.queueRequest(&bad); err == nil {
+		t.Fatal("large request should fail")
+	}
+}
@@ -0,0 +1,150 @@
+package pex
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/movsb/torrent/pkg/peer"
+)
+
+// ExtensionName is the name of peer exchange in the extended handshake.
+const ExtensionName = `ut_pex`
+
+const (
+	// The max number of peers in added or dropped of a message.
+	maxPeersPerMessage = 50
+
+	// Messages sent sooner than this after the last one are ignored.
+	// Peers should send at most one message per minute.
+	minInterval = time.Second * 45
+)
+
+// Extension implements peer exchange (BEP 11).
+//
+// Peers learned from others are reported by OnPeers, and the owner
+// calls Update periodically to tell each peer the changes of the
+// peers we are connected to.
+type Extension struct {
+	// OnPeers is called with the addresses a peer told us.
+	OnPeers func(addresses []string)
+
+	mu     sync.Mutex
+	states map[*peer.Peer]*_State
+}
+
+type _State struct {
+	lastRecv time.Time
+
+	// Addresses we have told her.
+	sent map[string]bool
+}
+
+var _ peer.Extension = &Extension{}
+
+// NewExtension ...
+func NewExtension(onPeers func(addresses []string)) *Extension {
+	return &Extension{
+		OnPeers: onPeers,
+		states:  make(map[*peer.Peer]*_State),
+	}
+}
+
+// Name ...
+func (e *Extension) Name() string {
+	return ExtensionName
+}
+
+func (e *Extension) state(p *peer.Peer) *_State {
+	s, ok := e.states[p]
+	if !ok {
+		s = &_State{
+			sent: make(map[string]bool),
+		}
+		e.states[p] = s
+	}
+	return s
+}
+
+// HandleMessage ...
+func (e *Extension) HandleMessage(p *peer.Peer, payload []byte) error {
+	var m Message
+	if err := m.Unmarshal(payload); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	s := e.state(p)
+	now := time.Now()
+	if !s.lastRecv.IsZero() && now.Sub(s.lastRecv) < minInterval {
+		e.mu.Unlock()
+		log.Printf("pex: %s sends too frequently", p.PeerAddr)
+		return nil
+	}
+	s.lastRecv = now
+	e.mu.Unlock()
+
+	added := m.Added
+	if len(added) > maxPeersPerMessage {
+		added = added[:maxPeersPerMessage]
+	}
+	addresses := make([]string, 0, len(added))
+	for _, a := range added {
+		addresses = append(addresses, a.Address)
+	}
+
+	if len(addresses) > 0 && e.OnPeers != nil {
+		e.OnPeers(addresses)
+	}
+
+	return nil
+}
+
+// Update sends the changes of connected peers since the last update to p,
+// the first update sends all of them. It does nothing if p doesn't support PEX.
+func (e *Extension) Update(p *peer.Peer, connected []Peer) error {
+	if p.HerExtensionID(ExtensionName) == 0 {
+		return nil
+	}
+
+	self := p.ListenAddr()
+
+	e.mu.Lock()
+	s := e.state(p)
+	var m Message
+	current := make(map[string]bool, len(connected))
+	for _, c := range connected {
+		if c.Address == self {
+			continue
+		}
+		current[c.Address] = true
+		if !s.sent[c.Address] && len(m.Added) < maxPeersPerMessage {
+			m.Added = append(m.Added, c)
+			s.sent[c.Address] = true
+		}
+	}
+	for address := range s.sent {
+		if !current[address] && len(m.Dropped) < maxPeersPerMessage {
+			m.Dropped = append(m.Dropped, address)
+			delete(s.sent, address)
+		}
+	}
+	e.mu.Unlock()
+
+	if len(m.Added) == 0 && len(m.Dropped) == 0 {
+		return nil
+	}
+
+	b, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+	return p.SendExtended(ExtensionName, b)
+}
+
+// Remove forgets the peer, should be called when the peer exits.
+func (e *Extension) Remove(p *peer.Peer) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.states, p)
+}
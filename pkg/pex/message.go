@@ -0,0 +1,123 @@
+package pex
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/movsb/torrent/pkg/common"
+	"github.com/zeebo/bencode"
+)
+
+// Flags of added peers.
+const (
+	FlagPreferEncryption = byte(0x01)
+	FlagSeed             = byte(0x02)
+	FlagUTP              = byte(0x04)
+	FlagHolePunch        = byte(0x08)
+	FlagReachable        = byte(0x10)
+)
+
+// Peer is a peer in the PEX message.
+type Peer struct {
+	// Address of the form ip:port.
+	Address string
+	Flags   byte
+}
+
+// Message is a ut_pex message.
+type Message struct {
+	Added   []Peer
+	Dropped []string
+}
+
+type _Message struct {
+	Added    []byte `bencode:"added,omitempty"`
+	AddedF   []byte `bencode:"added.f,omitempty"`
+	Added6   []byte `bencode:"added6,omitempty"`
+	Added6F  []byte `bencode:"added6.f,omitempty"`
+	Dropped  []byte `bencode:"dropped,omitempty"`
+	Dropped6 []byte `bencode:"dropped6,omitempty"`
+}
+
+// Marshal ...
+func (m *Message) Marshal() ([]byte, error) {
+	var r _Message
+
+	for _, p := range m.Added {
+		b, err := common.CompactPeer(p.Address)
+		if err != nil {
+			return nil, fmt.Errorf("pex: %v", err)
+		}
+		if len(b) == common.CompactPeerLength4 {
+			r.Added = append(r.Added, b...)
+			r.AddedF = append(r.AddedF, p.Flags)
+		} else {
+			r.Added6 = append(r.Added6, b...)
+			r.Added6F = append(r.Added6F, p.Flags)
+		}
+	}
+
+	for _, address := range m.Dropped {
+		b, err := common.CompactPeer(address)
+		if err != nil {
+			return nil, fmt.Errorf("pex: %v", err)
+		}
+		if len(b) == common.CompactPeerLength4 {
+			r.Dropped = append(r.Dropped, b...)
+		} else {
+			r.Dropped6 = append(r.Dropped6, b...)
+		}
+	}
+
+	return bencode.EncodeBytes(r)
+}
+
+// Unmarshal ...
+func (m *Message) Unmarshal(b []byte) error {
+	var r _Message
+	if err := bencode.DecodeBytes(b, &r); err != nil {
+		return fmt.Errorf("pex: %v", err)
+	}
+
+	m.Added = nil
+	m.Dropped = nil
+
+	added := func(b []byte, flags []byte, ipLen int) error {
+		peers, err := common.ParseCompactPeers(b, ipLen)
+		if err != nil {
+			return fmt.Errorf("pex: %v", err)
+		}
+		for i, address := range peers {
+			p := Peer{Address: address}
+			// Flags are optional.
+			if i < len(flags) {
+				p.Flags = flags[i]
+			}
+			m.Added = append(m.Added, p)
+		}
+		return nil
+	}
+	dropped := func(b []byte, ipLen int) error {
+		peers, err := common.ParseCompactPeers(b, ipLen)
+		if err != nil {
+			return fmt.Errorf("pex: %v", err)
+		}
+		m.Dropped = append(m.Dropped, peers...)
+		return nil
+	}
+
+	if err := added(r.Added, r.AddedF, net.IPv4len); err != nil {
+		return err
+	}
+	if err := added(r.Added6, r.Added6F, net.IPv6len); err != nil {
+		return err
+	}
+	if err := dropped(r.Dropped, net.IPv4len); err != nil {
+		return err
+	}
+	if err := dropped(r.Dropped6, net.IPv6len); err != nil {
+		return err
+	}
+
+	return nil
+}
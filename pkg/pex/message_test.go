@@ -0,0 +1,53 @@
+package pex
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMessage(t *testing.T) {
+	m := Message{
+		Added: []Peer{
+			{Address: `10.0.0.1:6881`, Flags: FlagSeed | FlagReachable},
+			{Address: `[2001:db8::1]:51413`, Flags: FlagUTP},
+			{Address: `10.0.0.2:80`},
+		},
+		Dropped: []string{
+			`192.168.1.1:1234`,
+			`[::1]:8080`,
+		},
+	}
+
+	b, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got Message
+	if err := got.Unmarshal(b); err != nil {
+		t.Fatal(err)
+	}
+
+	// IPv4 peers come before IPv6 ones.
+	want := Message{
+		Added: []Peer{
+			{Address: `10.0.0.1:6881`, Flags: FlagSeed | FlagReachable},
+			{Address: `10.0.0.2:80`},
+			{Address: `[2001:db8::1]:51413`, Flags: FlagUTP},
+		},
+		Dropped: m.Dropped,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestUnmarshalWithoutFlags(t *testing.T) {
+	var m Message
+	if err := m.Unmarshal([]byte("d5:added6:\x0a\x00\x00\x01\x1a\xe1e")); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Added) != 1 || m.Added[0] != (Peer{Address: `10.0.0.1:6881`}) {
+		t.Fatalf("bad added: %+v", m.Added)
+	}
+}
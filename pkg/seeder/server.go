@@ -17,6 +17,7 @@ type LoadInfo struct {
 	TF *torrent.File
 	PM *store.PieceManager
 	BF *message.BitField
+	EX *peer.Extensions
 }
 
 type LoadTorrent interface {
@@ -85,21 +86,38 @@ func (s *Server) handle(conn net.Conn) {
 
 	c := peer.Peer{
 		HerPeerID:   handshake.PeerID,
+		HerReserved: handshake.Reserved,
+		Extensions:  li.EX,
 		PM:          li.PM,
 		MyBitField:  li.BF,
 		HerBitField: message.NewBitField(li.TF.PieceHashes.Count(), 0),
 		InfoHash:    li.TF.InfoHash(),
 		PeerAddr:    conn.RemoteAddr().String(),
+		Incoming:    true,
 	}
 
 	c.SetConn(conn)
 
-	if err := c.Send(message.MsgBitField, li.BF); err != nil {
+	if err := c.SendBitField(); err != nil {
 		log.Printf("error send bitbield: %v\n", err)
 		return
 	}
-	if err := c.Send(message.MsgUnChoke, message.UnChoke{}); err != nil {
-		log.Printf("error send unchoked: %v\n", err)
+	// With the fast extension, she must send one of
+	// bitfield, have all or have none. Otherwise her bitfield,
+	// if any, is handled as her first message once running.
+	if c.SupportsFastExtension() {
+		if err := c.RecvBitField(); err != nil {
+			log.Printf("error recv bitbield: %v\n", err)
+			return
+		}
+	}
+	if err := c.SendExtendedHandshake(); err != nil {
+		log.Printf("error send extended handshake: %v\n", err)
+		return
+	}
+	if err := c.SendAllowedFast(); err != nil {
+		log.Printf("error send allowed fast: %v\n", err)
+		return
 	}
 	if err := c.Send(message.MsgInterested, message.Interested{}); err != nil {
 		log.Printf("error send unchoked: %v\n", err)
@@ -12,9 +12,11 @@ import (
 
 // _File ...
 type _File struct {
-	Announce string             `bencode:"announce"`
-	Info     bencode.RawMessage `bencode:"info"`
-	Nodes    []_Node            `bencode:"nodes"`
+	Announce     string             `bencode:"announce"`
+	AnnounceList [][]string         `bencode:"announce-list,omitempty"`
+	Info         bencode.RawMessage `bencode:"info"`
+	Nodes        []_Node            `bencode:"nodes"`
+	URLList      _URLList           `bencode:"url-list,omitempty"`
 }
 
 func (f *_File) convert() (*File, error) {
@@ -28,12 +30,15 @@ func (f *_File) convert() (*File, error) {
 	}
 
 	c := &File{
-		Name:        i.Name,
-		Announce:    f.Announce,
-		Nodes:       f.Nodes,
-		Length:      i.Length,
-		PieceLength: i.PieceLength,
-		Files:       make([]Item, 0, len(i.Files)),
+		Name:         i.Name,
+		Announce:     f.Announce,
+		AnnounceList: f.AnnounceList,
+		Nodes:        f.Nodes,
+		WebSeeds:     f.URLList,
+		Length:       i.Length,
+		PieceLength:  i.PieceLength,
+		Private:      i.Private == 1,
+		Files:        make([]Item, 0, len(i.Files)),
 
 		rawInfo:  f.Info,
 		infoHash: f.infoHash(),
@@ -116,6 +121,37 @@ func (n *_Node) UnmarshalBencode(l []byte) error {
 	return nil
 }
 
+// _URLList is the url-list of web seeds (BEP 19),
+// which is either a single URL or a list of URLs.
+type _URLList []string
+
+// UnmarshalBencode ...
+func (u *_URLList) UnmarshalBencode(l []byte) error {
+	var v interface{}
+	if err := bencode.DecodeBytes(l, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case string:
+		if v != `` {
+			*u = _URLList{v}
+		}
+	case []interface{}:
+		for _, x := range v {
+			s, ok := x.(string)
+			if !ok {
+				return fmt.Errorf("url-list: url is not a string")
+			}
+			if s != `` {
+				*u = append(*u, s)
+			}
+		}
+	default:
+		return fmt.Errorf("url-list is neither a string nor a list")
+	}
+	return nil
+}
+
 // _Info ...
 type _Info struct {
 	Name        string  `bencode:"name"`
@@ -124,6 +160,7 @@ type _Info struct {
 	Pieces      []byte  `bencode:"pieces"`
 	PieceLength int     `bencode:"piece length"`
 	Files       []_Item `bencode:"files,omitempty"`
+	Private     int     `bencode:"private,omitempty"`
 }
 
 // _Item ...
@@ -0,0 +1,63 @@
+package torrent
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zeebo/bencode"
+)
+
+func TestURLList(t *testing.T) {
+	tests := []struct {
+		urlList interface{}
+		want    []string
+	}{
+		{`http://a/`, []string{`http://a/`}},
+		{[]string{`http://a/`, ``, `http://b/`}, []string{`http://a/`, `http://b/`}},
+		{``, nil},
+	}
+
+	for _, test := range tests {
+		b, err := bencode.EncodeBytes(map[string]interface{}{
+			`url-list`: test.urlList,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var f _File
+		if err := bencode.DecodeBytes(b, &f); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual([]string(f.URLList), test.want) {
+			t.Errorf("%v: got %v, want %v", test.urlList, f.URLList, test.want)
+		}
+	}
+}
+
+func TestAnnounceList(t *testing.T) {
+	b, err := bencode.EncodeBytes(map[string]interface{}{
+		`announce`:      `http://a/announce`,
+		`announce-list`: [][]string{{`http://a/announce`, `udp://b:80`}, {`http://c/announce`}},
+		`info`: map[string]interface{}{
+			`name`:         `f`,
+			`length`:       1,
+			`piece length`: 16384,
+			`pieces`:       string(make([]byte, 20)),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f _File
+	if err := bencode.DecodeBytes(b, &f); err != nil {
+		t.Fatal(err)
+	}
+	tf, err := f.convert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{`http://a/announce`, `udp://b:80`}, {`http://c/announce`}}
+	if !reflect.DeepEqual(tf.AnnounceList, want) {
+		t.Fatalf("got %v, want %v", tf.AnnounceList, want)
+	}
+}
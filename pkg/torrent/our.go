@@ -12,10 +12,19 @@ type File struct {
 	Announce string
 	Nodes    []_Node
 
+	// Tiers of trackers (BEP 12), which supersede Announce if not empty.
+	AnnounceList [][]string
+
+	// URLs of web seeds (BEP 19).
+	WebSeeds []string
+
 	Single bool
 	Files  []Item
 	Length int64
 
+	// Private torrents get peers from trackers only (BEP 27).
+	Private bool
+
 	PieceLength int
 	PieceHashes common.PieceHashes
 
@@ -28,6 +37,11 @@ func (f *File) InfoHash() common.Hash {
 	return f.infoHash
 }
 
+// RawInfo returns the bencoded info dictionary.
+func (f *File) RawInfo() []byte {
+	return f.rawInfo
+}
+
 // Item ...
 type Item struct {
 	Length int64
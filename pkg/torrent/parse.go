@@ -34,3 +34,13 @@ func ParseFileToInterface(path string) (interface{}, error) {
 	}
 	return f, nil
 }
+
+// ParseInfo builds a File from a raw bencoded info dictionary,
+// which is what we get from peers via the metadata extension.
+func ParseInfo(info []byte, announce string) (*File, error) {
+	f := _File{
+		Announce: announce,
+		Info:     bencode.RawMessage(info),
+	}
+	return f.convert()
+}
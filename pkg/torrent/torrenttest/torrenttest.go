@@ -0,0 +1,55 @@
+// Package torrenttest creates files and torrents of them for tests.
+package torrenttest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/movsb/torrent/pkg/torrent"
+	"github.com/zeebo/bencode"
+)
+
+// WriteFiles writes the files, by their slash separated paths, under root.
+func WriteFiles(t testing.TB, root string, files map[string][]byte) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Create creates a torrent of the file or the directory at root,
+// with the web seeds if any, and saves it to path.
+func Create(t testing.TB, root string, path string, webSeeds ...string) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := torrent.NewCreator(root).Create(&buf); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+
+	if len(webSeeds) > 0 {
+		var m map[string]bencode.RawMessage
+		if err := bencode.DecodeBytes(b, &m); err != nil {
+			t.Fatal(err)
+		}
+		m[`url-list`], _ = bencode.EncodeBytes(webSeeds)
+		var err error
+		if b, err = bencode.EncodeBytes(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,6 +1,11 @@
 package trackercommon
 
-import "github.com/movsb/torrent/pkg/common"
+import (
+	"fmt"
+	"net"
+
+	"github.com/movsb/torrent/pkg/common"
+)
 
 // MyPeerID ...
 var MyPeerID = makePeerID()
@@ -10,3 +15,76 @@ func makePeerID() common.PeerID {
 	copy(id[:], []byte(`dev-bt12345678123457`))
 	return id
 }
+
+// Event is the event of an announce, numbered as in BEP 15.
+type Event int
+
+// Events ...
+const (
+	EventNone Event = iota
+	EventCompleted
+	EventStarted
+	EventStopped
+)
+
+func (e Event) String() string {
+	switch e {
+	case EventCompleted:
+		return `completed`
+	case EventStarted:
+		return `started`
+	case EventStopped:
+		return `stopped`
+	}
+	return ``
+}
+
+// ParseEvent parses the event of HTTP announces, empty for EventNone.
+func ParseEvent(s string) (Event, error) {
+	switch s {
+	case ``:
+		return EventNone, nil
+	case `completed`:
+		return EventCompleted, nil
+	case `started`:
+		return EventStarted, nil
+	case `stopped`:
+		return EventStopped, nil
+	}
+	return EventNone, fmt.Errorf("invalid event: %s", s)
+}
+
+// AnnounceRequest is what a client announces to trackers.
+type AnnounceRequest struct {
+	InfoHash common.Hash
+	PeerID   common.PeerID
+
+	// The IP to announce, nil for the address requests come from.
+	IP   net.IP
+	Port int
+
+	// Bytes uploaded and downloaded, and left to download.
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+
+	Event Event
+
+	// The number of peers wanted, negative for the tracker's default.
+	NumWant int
+
+	// Key identifies the client if its IP changes.
+	Key uint32
+}
+
+// ScrapeResult is the stats of a torrent scraped from a tracker.
+type ScrapeResult struct {
+	InfoHash common.Hash
+
+	// The numbers of seeders and leechers.
+	Complete   int
+	Incomplete int
+
+	// The number of times the torrent is downloaded completely.
+	Downloaded int
+}
@@ -0,0 +1,233 @@
+package trackerstore
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/movsb/torrent/pkg/common"
+)
+
+// Snapshot is the state of a store, to be persisted.
+type Snapshot struct {
+	Time time.Time `json:"time"`
+
+	// Keyed by the info hashes in hex.
+	Torrents map[string]*TorrentSnapshot `json:"torrents"`
+
+	Accounts map[string]Account `json:"accounts,omitempty"`
+}
+
+// TorrentSnapshot ...
+type TorrentSnapshot struct {
+	Completed int            `json:"completed"`
+	Peers     []PeerSnapshot `json:"peers,omitempty"`
+}
+
+// PeerSnapshot ...
+type PeerSnapshot struct {
+	ID        string    `json:"id"` // In hex.
+	IP        string    `json:"ip"`
+	Port      int       `json:"port"`
+	Left      int64     `json:"left"`
+	Announced time.Time `json:"announced"`
+	Completed bool      `json:"completed,omitempty"`
+}
+
+// Persister saves and loads snapshots of stores.
+type Persister interface {
+	Save(snapshot *Snapshot) error
+
+	// Load returns nil if nothing is saved.
+	Load() (*Snapshot, error)
+}
+
+// Snapshot takes a snapshot of the store.
+func (s *Store) Snapshot() *Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+
+	snapshot := &Snapshot{
+		Time:     s.now(),
+		Torrents: make(map[string]*TorrentSnapshot, len(s.m)),
+		Accounts: make(map[string]Account, len(s.accounts)),
+	}
+	for ih, t := range s.m {
+		ts := &TorrentSnapshot{
+			Completed: t.completed,
+		}
+		for _, p := range t.peers {
+			ts.Peers = append(ts.Peers, PeerSnapshot{
+				ID:        hex.EncodeToString(p.ID[:]),
+				IP:        p.IP,
+				Port:      p.Port,
+				Left:      p.Left,
+				Announced: p.announced,
+				Completed: p.completed,
+			})
+		}
+		sort.Slice(ts.Peers, func(i, j int) bool {
+			if ts.Peers[i].IP != ts.Peers[j].IP {
+				return ts.Peers[i].IP < ts.Peers[j].IP
+			}
+			return ts.Peers[i].Port < ts.Peers[j].Port
+		})
+		snapshot.Torrents[ih.String()] = ts
+	}
+	for user, a := range s.accounts {
+		snapshot.Accounts[user] = *a
+	}
+	return snapshot
+}
+
+// Restore replaces the state of the store with the snapshot.
+// Peers that would have expired by now are dropped.
+func (s *Store) Restore(snapshot *Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	ttl := s.Interval * time.Duration(s.Expiry)
+
+	m := make(map[common.Hash]*_Torrent, len(snapshot.Torrents))
+	for key, ts := range snapshot.Torrents {
+		ih, err := common.HashFromString(key)
+		if err != nil {
+			return fmt.Errorf("trackerstore.Restore: %v", err)
+		}
+		t := &_Torrent{
+			peers:     make(map[string]*_Entry),
+			completed: ts.Completed,
+		}
+		for _, p := range ts.Peers {
+			if now.Sub(p.Announced) > ttl {
+				continue
+			}
+			var id common.PeerID
+			b, err := hex.DecodeString(p.ID)
+			if err != nil || len(b) != len(id) {
+				return fmt.Errorf("trackerstore.Restore: invalid peer id: %s", p.ID)
+			}
+			copy(id[:], b)
+			e := &_Entry{
+				Peer: Peer{
+					ID:   id,
+					IP:   p.IP,
+					Port: p.Port,
+					Left: p.Left,
+				},
+				announced: p.Announced,
+				completed: p.Completed,
+			}
+			t.peers[e.Address()] = e
+		}
+		if len(t.peers) > 0 || t.completed > 0 {
+			m[ih] = t
+		}
+	}
+
+	accounts := make(map[string]*Account, len(snapshot.Accounts))
+	for user, a := range snapshot.Accounts {
+		a := a
+		accounts[user] = &a
+	}
+
+	s.m = m
+	s.accounts = accounts
+	return nil
+}
+
+// Save saves a snapshot of the store.
+func (s *Store) Save(p Persister) error {
+	return p.Save(s.Snapshot())
+}
+
+// Load restores the store from the snapshot saved, if any.
+func (s *Store) Load(p Persister) error {
+	snapshot, err := p.Load()
+	if err != nil {
+		return err
+	}
+	if snapshot == nil {
+		return nil
+	}
+	return s.Restore(snapshot)
+}
+
+// SaveEvery saves snapshots of the store in every interval, until ctx is done.
+func (s *Store) SaveEvery(ctx context.Context, p Persister, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := s.Save(p); err != nil {
+			log.Printf("trackerstore: failed to save: %v", err)
+		}
+	}
+}
+
+// FilePersister persists snapshots to a JSON file, which is replaced
+// atomically, so that it's never left half written.
+type FilePersister struct {
+	Path string
+}
+
+var _ Persister = &FilePersister{}
+
+// Save ...
+func (f *FilePersister) Save(snapshot *Snapshot) error {
+	b, err := json.MarshalIndent(snapshot, ``, `  `)
+	if err != nil {
+		return fmt.Errorf("trackerstore.Save: %v", err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(f.Path), filepath.Base(f.Path)+`.*.tmp`)
+	if err != nil {
+		return fmt.Errorf("trackerstore.Save: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("trackerstore.Save: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("trackerstore.Save: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("trackerstore.Save: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), f.Path); err != nil {
+		return fmt.Errorf("trackerstore.Save: %v", err)
+	}
+	return nil
+}
+
+// Load ...
+func (f *FilePersister) Load() (*Snapshot, error) {
+	b, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("trackerstore.Load: %v", err)
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(b, &snapshot); err != nil {
+		return nil, fmt.Errorf("trackerstore.Load: %v", err)
+	}
+	return &snapshot, nil
+}
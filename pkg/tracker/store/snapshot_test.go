@@ -0,0 +1,75 @@
+package trackerstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/movsb/torrent/pkg/common"
+	trackercommon "github.com/movsb/torrent/pkg/tracker/common"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	p := &FilePersister{Path: filepath.Join(dir, `state.json`)}
+
+	s := New()
+	if err := s.Load(p); err != nil {
+		t.Fatalf("load nothing: %v", err)
+	}
+
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	ih1, ih2 := common.Hash{1}, common.Hash{2}
+	s.Announce(ih1, Peer{ID: common.PeerID{1}, IP: `1.2.3.4`, Port: 1, Left: 1}, trackercommon.EventStarted, 0)
+	now = now.Add(time.Minute)
+	s.Announce(ih1, Peer{ID: common.PeerID{2}, IP: `::1`, Port: 2}, trackercommon.EventCompleted, 0)
+	s.Announce(ih2, Peer{IP: `1.2.3.4`, Port: 1, Left: 1}, trackercommon.EventStarted, 0)
+	s.Announce(ih2, Peer{IP: `1.2.3.4`, Port: 1, Left: 1}, trackercommon.EventStopped, 0)
+	s.AddAccount(`alice`, 1, 2)
+
+	if err := s.Save(p); err != nil {
+		t.Fatal(err)
+	}
+	// Saved again by replacing, with no temporary files left.
+	if err := s.Save(p); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Fatalf("%d files", len(files))
+	}
+
+	r := New()
+	r.now = s.now
+	if err := r.Load(p); err != nil {
+		t.Fatal(err)
+	}
+	restored, _ := json.Marshal(r.Snapshot())
+	saved, _ := json.Marshal(s.Snapshot())
+	if !bytes.Equal(restored, saved) {
+		t.Fatalf("restored:\n%s\n%s", restored, saved)
+	}
+	if stats := r.Stats(ih1); stats != (Stats{Seeders: 1, Leechers: 1, Completed: 1}) {
+		t.Fatalf("stats: %+v", stats)
+	}
+	if accounts := r.Accounts(); accounts[`alice`] != (Account{Uploaded: 1, Downloaded: 2}) {
+		t.Fatalf("accounts: %+v", accounts)
+	}
+	if hashes := r.InfoHashes(); len(hashes) != 1 {
+		t.Fatalf("info hashes: %v", hashes)
+	}
+
+	// Stale peers are dropped.
+	r = New()
+	r.now = func() time.Time { return now.Add(r.Interval*time.Duration(r.Expiry) - time.Second) }
+	if err := r.Load(p); err != nil {
+		t.Fatal(err)
+	}
+	if peers := r.Get(ih1); len(peers) != 1 || peers[0].Port != 2 {
+		t.Fatalf("stale peers: %v", peers)
+	}
+}
@@ -0,0 +1,242 @@
+package trackerstore
+
+import (
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/movsb/torrent/pkg/common"
+	trackercommon "github.com/movsb/torrent/pkg/tracker/common"
+)
+
+const (
+	// The numbers of peers responded if not asked, and at most.
+	defaultNumWant = 50
+	maxNumWant     = 200
+)
+
+// Store stores the peers announced to the tracker servers,
+// and is shared by the HTTP and the UDP servers.
+type Store struct {
+	// The interval between announces told to peers.
+	Interval time.Duration
+
+	// Peers expire if they don't announce in Expiry times of the interval.
+	Expiry int
+
+	mu        sync.Mutex
+	m         map[common.Hash]*_Torrent
+	accounts  map[string]*Account
+	now       func() time.Time
+	lastSweep time.Time
+}
+
+// _Torrent is the peers of a torrent, keyed by addresses.
+type _Torrent struct {
+	peers     map[string]*_Entry
+	completed int
+}
+
+type _Entry struct {
+	Peer
+	announced time.Time
+
+	// Whether the completion of the peer is counted.
+	completed bool
+}
+
+// New ...
+func New() *Store {
+	return &Store{
+		Interval: time.Minute,
+		Expiry:   3,
+		m:        make(map[common.Hash]*_Torrent),
+		accounts: make(map[string]*Account),
+		now:      time.Now,
+	}
+}
+
+// Peer ...
+type Peer struct {
+	ID   common.PeerID
+	IP   string
+	Port int
+
+	// The bytes left to download, 0 for seeders, or -1 if unknown.
+	Left int64
+}
+
+// Address ...
+func (p Peer) Address() string {
+	return net.JoinHostPort(p.IP, strconv.Itoa(p.Port))
+}
+
+// Announce records the announce of the peer, and returns up to numWant
+// other peers randomly, or the default number of them if numWant is negative.
+// Peers that stopped are removed, and get no peers.
+func (s *Store) Announce(ih common.Hash, peer Peer, event trackercommon.Event, numWant int) []Peer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep()
+
+	t := s.m[ih]
+	if t == nil {
+		t = &_Torrent{peers: make(map[string]*_Entry)}
+		s.m[ih] = t
+	}
+
+	address := peer.Address()
+	if event == trackercommon.EventStopped {
+		delete(t.peers, address)
+		s.remove(ih)
+		return nil
+	}
+
+	e := t.peers[address]
+	if e == nil {
+		e = &_Entry{}
+		t.peers[address] = e
+	}
+	e.Peer = peer
+	e.announced = s.now()
+	if event == trackercommon.EventCompleted && !e.completed {
+		e.completed = true
+		t.completed++
+	}
+
+	if numWant < 0 {
+		numWant = defaultNumWant
+	}
+	if numWant > maxNumWant {
+		numWant = maxNumWant
+	}
+
+	// Sampled by a partial Fisher-Yates shuffle.
+	peers := make([]Peer, 0, len(t.peers))
+	for _, p := range t.peers {
+		if p == e || (p.ID == peer.ID && p.ID != (common.PeerID{})) {
+			continue
+		}
+		peers = append(peers, p.Peer)
+	}
+	if numWant > len(peers) {
+		numWant = len(peers)
+	}
+	for i := 0; i < numWant; i++ {
+		j := i + rand.Intn(len(peers)-i)
+		peers[i], peers[j] = peers[j], peers[i]
+	}
+	return peers[:numWant]
+}
+
+// Get returns all the peers of the torrent.
+func (s *Store) Get(ih common.Hash) (peers []Peer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+	if t := s.m[ih]; t != nil {
+		for _, p := range t.peers {
+			peers = append(peers, p.Peer)
+		}
+	}
+	return
+}
+
+// Stats is the stats of a torrent.
+type Stats struct {
+	Seeders   int
+	Leechers  int
+	Completed int
+}
+
+// Stats returns the stats of the torrent.
+func (s *Store) Stats(ih common.Hash) (stats Stats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+	t := s.m[ih]
+	if t == nil {
+		return
+	}
+	for _, p := range t.peers {
+		if p.Left == 0 {
+			stats.Seeders++
+		} else {
+			stats.Leechers++
+		}
+	}
+	stats.Completed = t.completed
+	return
+}
+
+// InfoHashes returns the info hashes of all the torrents.
+func (s *Store) InfoHashes() []common.Hash {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+	hashes := make([]common.Hash, 0, len(s.m))
+	for ih := range s.m {
+		hashes = append(hashes, ih)
+	}
+	return hashes
+}
+
+// Account is the bytes uploaded and downloaded by a user,
+// as announced to private servers.
+type Account struct {
+	Uploaded   int64 `json:"uploaded"`
+	Downloaded int64 `json:"downloaded"`
+}
+
+// AddAccount adds the bytes transferred to the account of the user.
+func (s *Store) AddAccount(user string, uploaded, downloaded int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.accounts[user]
+	if a == nil {
+		a = &Account{}
+		s.accounts[user] = a
+	}
+	a.Uploaded += uploaded
+	a.Downloaded += downloaded
+}
+
+// Accounts returns the accounts of the users.
+func (s *Store) Accounts() map[string]Account {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	accounts := make(map[string]Account, len(s.accounts))
+	for user, a := range s.accounts {
+		accounts[user] = *a
+	}
+	return accounts
+}
+
+// sweep removes the expired peers, at most once in a second.
+func (s *Store) sweep() {
+	now := s.now()
+	if now.Sub(s.lastSweep) < time.Second {
+		return
+	}
+	s.lastSweep = now
+
+	ttl := s.Interval * time.Duration(s.Expiry)
+	for ih, t := range s.m {
+		for address, p := range t.peers {
+			if now.Sub(p.announced) > ttl {
+				delete(t.peers, address)
+			}
+		}
+		s.remove(ih)
+	}
+}
+
+// remove removes the torrent if nothing of it is left.
+func (s *Store) remove(ih common.Hash) {
+	if t := s.m[ih]; t != nil && len(t.peers) == 0 && t.completed == 0 {
+		delete(s.m, ih)
+	}
+}
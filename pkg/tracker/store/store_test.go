@@ -0,0 +1,80 @@
+package trackerstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/movsb/torrent/pkg/common"
+	trackercommon "github.com/movsb/torrent/pkg/tracker/common"
+)
+
+func TestStore(t *testing.T) {
+	s := New()
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	ih := common.Hash{1}
+	peer := func(port int, left int64) Peer {
+		return Peer{ID: common.PeerID{byte(port)}, IP: `1.2.3.4`, Port: port, Left: left}
+	}
+
+	for port := 1; port <= 10; port++ {
+		peers := s.Announce(ih, peer(port, 1), trackercommon.EventStarted, -1)
+		if len(peers) != port-1 {
+			t.Fatalf("%d peers", len(peers))
+		}
+		for _, p := range peers {
+			if p.Port == port {
+				t.Fatal("the requesting peer is included")
+			}
+		}
+	}
+
+	// Sampled randomly.
+	seen := make(map[int]bool)
+	for i := 0; i < 100; i++ {
+		peers := s.Announce(ih, peer(1, 1), trackercommon.EventNone, 3)
+		if len(peers) != 3 {
+			t.Fatalf("numwant: %d peers", len(peers))
+		}
+		for _, p := range peers {
+			seen[p.Port] = true
+		}
+	}
+	if len(seen) != 9 {
+		t.Fatalf("sampled: %v", seen)
+	}
+
+	// Completed once.
+	s.Announce(ih, peer(2, 0), trackercommon.EventCompleted, 0)
+	s.Announce(ih, peer(2, 0), trackercommon.EventCompleted, 0)
+	if stats := s.Stats(ih); stats != (Stats{Seeders: 1, Leechers: 9, Completed: 1}) {
+		t.Fatalf("stats: %+v", stats)
+	}
+
+	if peers := s.Announce(ih, peer(3, 1), trackercommon.EventStopped, -1); len(peers) != 0 {
+		t.Fatalf("stopped: %d peers", len(peers))
+	}
+	if stats := s.Stats(ih); stats.Leechers != 8 {
+		t.Fatalf("stopped: %+v", stats)
+	}
+
+	// Peers not announcing expire.
+	now = now.Add(s.Interval * time.Duration(s.Expiry))
+	s.Announce(ih, peer(1, 1), trackercommon.EventNone, 0)
+	now = now.Add(time.Second)
+	if peers := s.Get(ih); len(peers) != 1 || peers[0].Port != 1 {
+		t.Fatalf("expired: %v", peers)
+	}
+	if stats := s.Stats(ih); stats != (Stats{Leechers: 1, Completed: 1}) {
+		t.Fatalf("expired: %+v", stats)
+	}
+
+	// Torrents are removed if nothing is left.
+	ih2 := common.Hash{2}
+	s.Announce(ih2, peer(1, 1), trackercommon.EventStarted, 0)
+	s.Announce(ih2, peer(1, 1), trackercommon.EventStopped, 0)
+	if hashes := s.InfoHashes(); len(hashes) != 1 || hashes[0] != ih {
+		t.Fatalf("info hashes: %v", hashes)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 
 	"github.com/movsb/torrent/pkg/common"
 	trackercommon "github.com/movsb/torrent/pkg/tracker/common"
@@ -15,25 +16,34 @@ import (
 
 // Client ...
 type Client struct {
-	Address  string
-	InfoHash common.Hash
-	MyPeerID common.PeerID
+	Address string
 }
 
 // Announce ...
-func (t *Client) Announce(ctx context.Context) (*trackertcpcommon.AnnounceResponse, error) {
+func (t *Client) Announce(ctx context.Context, r *trackercommon.AnnounceRequest) (*trackertcpcommon.AnnounceResponse, error) {
 	u, err := url.Parse(t.Address)
 	if err != nil {
 		log.Printf("Announce: failed to parse address: %v", err)
 		return nil, err
 	}
 	a := u.Query()
-	a.Set(`info_hash`, string(t.InfoHash[:]))
-	a.Set(`peer_id`, string(trackercommon.MyPeerID[:]))
-	a.Set(`port`, `8888`)
-	a.Set(`uploaded`, `0`)
-	a.Set(`downloaded`, `0`)
-	a.Set(`left`, `0`)
+	a.Set(`info_hash`, string(r.InfoHash[:]))
+	a.Set(`peer_id`, string(r.PeerID[:]))
+	a.Set(`port`, strconv.Itoa(r.Port))
+	a.Set(`uploaded`, strconv.FormatInt(r.Uploaded, 10))
+	a.Set(`downloaded`, strconv.FormatInt(r.Downloaded, 10))
+	a.Set(`left`, strconv.FormatInt(r.Left, 10))
+	if r.Event != trackercommon.EventNone {
+		a.Set(`event`, r.Event.String())
+	}
+	if r.NumWant >= 0 {
+		a.Set(`numwant`, strconv.Itoa(r.NumWant))
+	}
+	a.Set(`key`, fmt.Sprintf(`%08x`, r.Key))
+	a.Set(`compact`, `1`)
+	if r.IP != nil {
+		a.Set(`ip`, r.IP.String())
+	}
 	u.RawQuery = a.Encode()
 
 	log.Printf("Announce: %s\n", u.String())
@@ -58,5 +68,61 @@ func (t *Client) Announce(ctx context.Context) (*trackertcpcommon.AnnounceRespon
 	if err := bencode.NewDecoder(resp.Body).Decode(&d); err != nil {
 		return nil, err
 	}
+	if d.FailureReason != `` {
+		return nil, fmt.Errorf("tracker: %s", d.FailureReason)
+	}
 	return &d, nil
 }
+
+// Scrape scrapes the stats of the torrents, in the order of the info hashes,
+// from the scrape URL converted from the announce address. Torrents unknown
+// to the tracker are zeros.
+func (t *Client) Scrape(ctx context.Context, infoHashes []common.Hash) ([]trackercommon.ScrapeResult, error) {
+	address, err := trackertcpcommon.ScrapeURL(t.Address)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	a := u.Query()
+	for _, ih := range infoHashes {
+		a.Add(`info_hash`, string(ih[:]))
+	}
+	u.RawQuery = a.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf(`tracker returns non-200 status code: %v`, resp.StatusCode)
+	}
+
+	var d trackertcpcommon.ScrapeResponse
+	if err := bencode.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return nil, err
+	}
+	if d.FailureReason != `` {
+		return nil, fmt.Errorf("tracker: %s", d.FailureReason)
+	}
+
+	results := make([]trackercommon.ScrapeResult, 0, len(infoHashes))
+	for _, ih := range infoHashes {
+		f := d.Files[string(ih[:])]
+		results = append(results, trackercommon.ScrapeResult{
+			InfoHash:   ih,
+			Complete:   f.Complete,
+			Incomplete: f.Incomplete,
+			Downloaded: f.Downloaded,
+		})
+	}
+	return results, nil
+}
@@ -0,0 +1,78 @@
+package trackertcpclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	trackercommon "github.com/movsb/torrent/pkg/tracker/common"
+	trackertcpcommon "github.com/movsb/torrent/pkg/tracker/tcp/common"
+	"github.com/zeebo/bencode"
+)
+
+func TestAnnounce(t *testing.T) {
+	var query url.Values
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		bencode.NewEncoder(w).Encode(&trackertcpcommon.AnnounceResponse{
+			Interval:    1800,
+			MinInterval: 60,
+		})
+	}))
+	defer s.Close()
+
+	c := Client{Address: s.URL + `/announce?passkey=x`}
+	rsp, err := c.Announce(context.Background(), &trackercommon.AnnounceRequest{
+		PeerID:     trackercommon.MyPeerID,
+		Port:       6881,
+		Uploaded:   1,
+		Downloaded: 2,
+		Left:       3,
+		Event:      trackercommon.EventStarted,
+		NumWant:    50,
+		Key:        0xabcd,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.Interval != 1800 || rsp.MinInterval != 60 {
+		t.Fatalf("intervals: %d, %d", rsp.Interval, rsp.MinInterval)
+	}
+
+	want := map[string]string{
+		`passkey`:    `x`,
+		`port`:       `6881`,
+		`uploaded`:   `1`,
+		`downloaded`: `2`,
+		`left`:       `3`,
+		`event`:      `started`,
+		`numwant`:    `50`,
+		`key`:        `0000abcd`,
+		`compact`:    `1`,
+		`peer_id`:    string(trackercommon.MyPeerID[:]),
+	}
+	for k, v := range want {
+		if got := query.Get(k); got != v {
+			t.Errorf("%s: got %q, want %q", k, got, v)
+		}
+	}
+	if _, ok := query[`ip`]; ok {
+		t.Errorf("ip is sent")
+	}
+}
+
+func TestAnnounceFailure(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bencode.NewEncoder(w).Encode(&trackertcpcommon.AnnounceResponse{
+			FailureReason: `unregistered torrent`,
+		})
+	}))
+	defer s.Close()
+
+	c := Client{Address: s.URL}
+	if _, err := c.Announce(context.Background(), &trackercommon.AnnounceRequest{}); err == nil {
+		t.Fatal("failure reason should fail")
+	}
+}
@@ -2,8 +2,11 @@ package trackertcpcommon
 
 import (
 	"fmt"
+	"net"
+	"strconv"
 
 	"github.com/movsb/torrent/pkg/common"
+	"github.com/zeebo/bencode"
 )
 
 // Peer ...
@@ -16,3 +19,72 @@ type Peer struct {
 func (p Peer) String() string {
 	return fmt.Sprintf(`%v (%s:%d)`, p.ID.String(), p.IP, p.Port)
 }
+
+// Address returns the address of the peer to dial.
+func (p Peer) Address() string {
+	return net.JoinHostPort(p.IP, strconv.Itoa(p.Port))
+}
+
+// Peers is a list of peers, either of dictionaries,
+// or compact as a string of 6 bytes for each IPv4 peer (BEP 23).
+type Peers []Peer
+
+// UnmarshalBencode ...
+func (p *Peers) UnmarshalBencode(b []byte) error {
+	var compact string
+	if err := bencode.DecodeBytes(b, &compact); err == nil {
+		peers, err := decodeCompact([]byte(compact), net.IPv4len)
+		*p = peers
+		return err
+	}
+
+	var peers []Peer
+	if err := bencode.DecodeBytes(b, &peers); err != nil {
+		return fmt.Errorf("peers: %v", err)
+	}
+	*p = peers
+	return nil
+}
+
+// Peers6 is a list of IPv6 peers, compact as a string
+// of 18 bytes for each (BEP 7).
+type Peers6 []Peer
+
+// UnmarshalBencode ...
+func (p *Peers6) UnmarshalBencode(b []byte) error {
+	var compact string
+	if err := bencode.DecodeBytes(b, &compact); err != nil {
+		return fmt.Errorf("peers6: %v", err)
+	}
+	peers, err := decodeCompact([]byte(compact), net.IPv6len)
+	*p = peers
+	return err
+}
+
+func decodeCompact(b []byte, ipLen int) ([]Peer, error) {
+	addresses, err := common.ParseCompactPeers(b, ipLen)
+	if err != nil {
+		return nil, err
+	}
+	peers := make([]Peer, 0, len(addresses))
+	for _, address := range addresses {
+		host, port, _ := net.SplitHostPort(address)
+		n, _ := strconv.Atoi(port)
+		peers = append(peers, Peer{IP: host, Port: n})
+	}
+	return peers, nil
+}
+
+// EncodeCompact encodes the peers of the IP version in the compact form,
+// ipLen being net.IPv4len or net.IPv6len. Peers of the other version are skipped.
+func EncodeCompact(peers []Peer, ipLen int) []byte {
+	b := make([]byte, 0, len(peers)*(ipLen+2))
+	for _, p := range peers {
+		c, err := common.CompactPeer(p.Address())
+		if err != nil || len(c) != ipLen+2 {
+			continue
+		}
+		b = append(b, c...)
+	}
+	return b
+}
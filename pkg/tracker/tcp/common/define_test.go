@@ -0,0 +1,123 @@
+package trackertcpcommon
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/zeebo/bencode"
+)
+
+func TestDecodePeers(t *testing.T) {
+	compact := string([]byte{1, 2, 3, 4, 0x1a, 0xe1, 5, 6, 7, 8, 0, 80})
+	compact6 := string(append(net.ParseIP(`2001:db8::1`), 0x1a, 0xe1))
+
+	tests := []struct {
+		name   string
+		resp   map[string]interface{}
+		peers  []string
+		peers6 []string
+	}{
+		{
+			name: `dictionaries`,
+			resp: map[string]interface{}{
+				`interval`: 60,
+				`peers`: []map[string]interface{}{
+					{`peer id`: `-TT0000-000000000000`, `ip`: `1.2.3.4`, `port`: 6881},
+					{`ip`: `::1`, `port`: 80},
+				},
+			},
+			peers: []string{`1.2.3.4:6881`, `[::1]:80`},
+		},
+		{
+			name: `compact`,
+			resp: map[string]interface{}{
+				`interval`: 60,
+				`peers`:    compact,
+			},
+			peers: []string{`1.2.3.4:6881`, `5.6.7.8:80`},
+		},
+		{
+			name: `compact with peers6`,
+			resp: map[string]interface{}{
+				`interval`: 60,
+				`peers`:    compact,
+				`peers6`:   compact6,
+			},
+			peers:  []string{`1.2.3.4:6881`, `5.6.7.8:80`},
+			peers6: []string{`[2001:db8::1]:6881`},
+		},
+		{
+			name: `dictionaries with peers6`,
+			resp: map[string]interface{}{
+				`interval`: 60,
+				`peers`: []map[string]interface{}{
+					{`ip`: `1.2.3.4`, `port`: 6881},
+				},
+				`peers6`: compact6,
+			},
+			peers:  []string{`1.2.3.4:6881`},
+			peers6: []string{`[2001:db8::1]:6881`},
+		},
+		{
+			name: `empty`,
+			resp: map[string]interface{}{
+				`interval`: 60,
+				`peers`:    ``,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		b, err := bencode.EncodeBytes(tt.resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp AnnounceResponse
+		if err := bencode.DecodeBytes(b, &resp); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if resp.Interval != 60 {
+			t.Errorf("%s: interval: %d", tt.name, resp.Interval)
+		}
+		if got := addresses(resp.Peers); !reflect.DeepEqual(got, tt.peers) {
+			t.Errorf("%s: peers: got %v, want %v", tt.name, got, tt.peers)
+		}
+		if got := addresses(resp.Peers6); !reflect.DeepEqual(got, tt.peers6) {
+			t.Errorf("%s: peers6: got %v, want %v", tt.name, got, tt.peers6)
+		}
+	}
+}
+
+func TestDecodeMalformedPeers(t *testing.T) {
+	b, _ := bencode.EncodeBytes(map[string]interface{}{
+		`peers`: string([]byte{1, 2, 3, 4, 5}),
+	})
+	var resp AnnounceResponse
+	if err := bencode.DecodeBytes(b, &resp); err == nil {
+		t.Fatal("malformed compact peers should fail")
+	}
+}
+
+func TestEncodeCompact(t *testing.T) {
+	peers := []Peer{
+		{IP: `1.2.3.4`, Port: 6881},
+		{IP: `2001:db8::1`, Port: 6881},
+		{IP: `::ffff:5.6.7.8`, Port: 80},
+	}
+	if got, want := EncodeCompact(peers, net.IPv4len), []byte{1, 2, 3, 4, 0x1a, 0xe1, 5, 6, 7, 8, 0, 80}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ipv4: got %v, want %v", got, want)
+	}
+	if got := EncodeCompact(peers, net.IPv6len); len(got) != 18 {
+		t.Errorf("ipv6: got %d bytes", len(got))
+	}
+}
+
+func addresses(peers []Peer) []string {
+	var s []string
+	for _, p := range peers {
+		s = append(s, p.Address())
+	}
+	return s
+}
@@ -2,7 +2,24 @@ package trackertcpcommon
 
 // AnnounceResponse ...
 type AnnounceResponse struct {
-	FailureReason string `bencode:"failure reason"`
+	FailureReason string `bencode:"failure reason,omitempty"`
 	Interval      int    `bencode:"interval,omitempty"`
-	Peers         []Peer `bencode:"peers,omitempty"`
+	MinInterval   int    `bencode:"min interval,omitempty"`
+	Peers         Peers  `bencode:"peers,omitempty"`
+	Peers6        Peers6 `bencode:"peers6,omitempty"`
+}
+
+// ScrapeResponse ...
+type ScrapeResponse struct {
+	FailureReason string `bencode:"failure reason,omitempty"`
+
+	// Keyed by the info hashes in bytes.
+	Files map[string]ScrapeFile `bencode:"files"`
+}
+
+// ScrapeFile is the stats of a torrent in a scrape response.
+type ScrapeFile struct {
+	Complete   int `bencode:"complete"`
+	Downloaded int `bencode:"downloaded"`
+	Incomplete int `bencode:"incomplete"`
 }
@@ -0,0 +1,33 @@
+package trackertcpcommon
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ScrapePath returns the scrape path of the announce path, by replacing
+// the "announce" the last segment starts with by "scrape". Trackers whose
+// announce paths are not like this don't support scrape.
+func ScrapePath(announce string) (string, bool) {
+	i := strings.LastIndex(announce, `/`) + 1
+	if !strings.HasPrefix(announce[i:], `announce`) {
+		return ``, false
+	}
+	return announce[:i] + `scrape` + announce[i+len(`announce`):], true
+}
+
+// ScrapeURL returns the scrape URL of the announce URL.
+func ScrapeURL(announce string) (string, error) {
+	u, err := url.Parse(announce)
+	if err != nil {
+		return ``, err
+	}
+	path, ok := ScrapePath(u.Path)
+	if !ok {
+		return ``, fmt.Errorf("scrape is not supported by %s", announce)
+	}
+	u.Path = path
+	u.RawPath = ``
+	return u.String(), nil
+}
@@ -0,0 +1,29 @@
+package trackertcpcommon
+
+import "testing"
+
+func TestScrapeURL(t *testing.T) {
+	tests := map[string]string{
+		`http://example.com/announce`:           `http://example.com/scrape`,
+		`http://example.com/x/announce`:         `http://example.com/x/scrape`,
+		`http://example.com/announce.php`:       `http://example.com/scrape.php`,
+		`http://example.com/announce?passkey=x`: `http://example.com/scrape?passkey=x`,
+		`http://example.com/x%20y/announce?a=b`: `http://example.com/x%20y/scrape?a=b`,
+		`http://example.com/a`:                  ``,
+		`http://example.com/announce/x`:         ``,
+		`http://example.com/x/announce/`:        ``,
+		`http://example.com`:                    ``,
+	}
+	for announce, want := range tests {
+		got, err := ScrapeURL(announce)
+		if want == `` {
+			if err == nil {
+				t.Errorf("%s: should not be supported, got %s", announce, got)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("%s: got %s, %v, want %s", announce, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package trackertcpserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/movsb/torrent/pkg/common"
+	trackercommon "github.com/movsb/torrent/pkg/tracker/common"
+	trackerstore "github.com/movsb/torrent/pkg/tracker/store"
+	"github.com/zeebo/bencode"
+)
+
+// reloadInterval is how often the allowlist and the users
+// are checked for changes.
+const reloadInterval = 10 * time.Second
+
+// SetAllowlist allows only the torrents of the info hashes to be announced,
+// which are the torrents in the directory if path is a directory, or the
+// info hashes in hex, one per line, in the file. It's reloaded once changed.
+func (s *Server) SetAllowlist(path string) error {
+	a := &_Allowlist{}
+	r, err := _NewReloader(path, a.load)
+	if err != nil {
+		return fmt.Errorf("trackertcpserver.SetAllowlist: %v", err)
+	}
+	a._Reloader = r
+	s.allowlist = a
+	return nil
+}
+
+// SetUsers makes the server private, to which only users announce,
+// by the passkeys in the announce URLs, like /<passkey>/announce.
+// The file has a user and the passkey of the user per line, separated
+// by spaces. It's reloaded once changed.
+func (s *Server) SetUsers(path string) error {
+	u := &_Users{
+		store:    s.store,
+		sessions: make(map[_Session]*_SessionStats),
+	}
+	r, err := _NewReloader(path, u.load)
+	if err != nil {
+		return fmt.Errorf("trackertcpserver.SetUsers: %v", err)
+	}
+	u._Reloader = r
+	s.users = u
+	return nil
+}
+
+// Accounts returns the accounts of the users, which are kept in the store.
+func (s *Server) Accounts() map[string]trackerstore.Account {
+	return s.store.Accounts()
+}
+
+// reload reloads the allowlist and the users once they're changed,
+// until ctx is done.
+func (s *Server) reload(ctx context.Context) {
+	var reloaders []*_Reloader
+	if s.allowlist != nil {
+		reloaders = append(reloaders, s.allowlist._Reloader)
+	}
+	if s.users != nil {
+		reloaders = append(reloaders, s.users._Reloader)
+	}
+	if len(reloaders) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, r := range reloaders {
+			if err := r.reload(); err != nil {
+				log.Printf("trackertcpserver: failed to reload %s: %v", r.path, err)
+			}
+		}
+	}
+}
+
+// privateHandler handles requests to paths prefixed by passkeys,
+// by the handler of the paths without the passkeys.
+func (s *Server) privateHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		passkey, path := strings.TrimPrefix(r.URL.Path, `/`), `/`
+		if i := strings.IndexByte(passkey, '/'); i >= 0 {
+			passkey, path = passkey[:i], passkey[i:]
+		}
+		user, ok := s.users.Lookup(passkey)
+		if !ok {
+			writeFailure(w, `invalid passkey`)
+			return
+		}
+		r = r.Clone(context.WithValue(r.Context(), _UserKey{}, user))
+		r.URL.Path = path
+		r.URL.RawPath = ``
+		h.ServeHTTP(w, r)
+	})
+}
+
+type _UserKey struct{}
+
+// userOf returns the user of the request to a private server.
+func userOf(r *http.Request) string {
+	user, _ := r.Context().Value(_UserKey{}).(string)
+	return user
+}
+
+// _Allowlist is the info hashes of the torrents allowed.
+type _Allowlist struct {
+	*_Reloader
+
+	mu     sync.RWMutex
+	hashes map[common.Hash]bool
+}
+
+// Allowed ...
+func (a *_Allowlist) Allowed(ih common.Hash) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.hashes[ih]
+}
+
+func (a *_Allowlist) load(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	hashes := make(map[common.Hash]bool)
+	if info.IsDir() {
+		files, err := filepath.Glob(filepath.Join(path, `*.torrent`))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			ih, err := infoHashOf(file)
+			if err != nil {
+				// Maybe it's being copied, and is loaded once it's done.
+				log.Printf("trackertcpserver: skipping %s: %v", file, err)
+				continue
+			}
+			hashes[ih] = true
+		}
+	} else {
+		err := readLines(path, func(line string) error {
+			ih, err := common.HashFromString(line)
+			if err != nil {
+				return err
+			}
+			hashes[ih] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	a.mu.Lock()
+	a.hashes = hashes
+	a.mu.Unlock()
+
+	log.Printf("trackertcpserver: %d torrents are allowed", len(hashes))
+	return nil
+}
+
+// infoHashOf returns the info hash of the torrent file.
+func infoHashOf(path string) (common.Hash, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	var f struct {
+		Info bencode.RawMessage `bencode:"info"`
+	}
+	if err := bencode.DecodeBytes(b, &f); err != nil {
+		return common.Hash{}, err
+	}
+	if len(f.Info) == 0 {
+		return common.Hash{}, fmt.Errorf("no info")
+	}
+	return sha1.Sum(f.Info), nil
+}
+
+// readLines calls fn for each line of the file,
+// with empty lines and comments starting with # skipped.
+func readLines(path string, fn func(line string) error) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == `` || strings.HasPrefix(line, `#`) {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// _Users is the users of a private server, whose accounts are in the store.
+type _Users struct {
+	*_Reloader
+	store *trackerstore.Store
+
+	mu       sync.Mutex
+	passkeys map[string]string
+
+	// The stats last announced in the sessions, by which the bytes
+	// transferred since the last announce are accounted.
+	sessions  map[_Session]*_SessionStats
+	lastSweep time.Time
+}
+
+// _Session is a peer of a user downloading or seeding a torrent.
+type _Session struct {
+	user     string
+	infoHash common.Hash
+	peerID   common.PeerID
+}
+
+type _SessionStats struct {
+	uploaded   int64
+	downloaded int64
+	announced  time.Time
+}
+
+func (u *_Users) load(path string) error {
+	passkeys := make(map[string]string)
+	err := readLines(path, func(line string) error {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return fmt.Errorf("want a user and a passkey")
+		}
+		if _, ok := passkeys[fields[1]]; ok {
+			return fmt.Errorf("duplicate passkey")
+		}
+		passkeys[fields[1]] = fields[0]
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	u.mu.Lock()
+	u.passkeys = passkeys
+	u.mu.Unlock()
+
+	log.Printf("trackertcpserver: %d users are loaded", len(passkeys))
+	return nil
+}
+
+// Lookup returns the user of the passkey.
+func (u *_Users) Lookup(passkey string) (string, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	user, ok := u.passkeys[passkey]
+	return user, ok
+}
+
+// Account accounts the bytes transferred since the last announce of the
+// session. The stats announced are totals of the session, and a session
+// restarts if they decrease. Sessions not announced in ttl are removed.
+//
+// A session unknown but not started, e.g. one removed as expired, or of an
+// announce before the server restarted, is accounted since its next
+// announce, or its totals would be counted twice.
+func (u *_Users) Account(session _Session, uploaded, downloaded int64, event trackercommon.Event, now time.Time, ttl time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if now.Sub(u.lastSweep) > ttl {
+		u.lastSweep = now
+		for s, stats := range u.sessions {
+			if now.Sub(stats.announced) > ttl {
+				delete(u.sessions, s)
+			}
+		}
+	}
+
+	last := u.sessions[session]
+	if last == nil {
+		last = &_SessionStats{}
+		if event != trackercommon.EventStarted {
+			last.uploaded, last.downloaded = uploaded, downloaded
+		}
+	}
+	delta := func(current, last int64) int64 {
+		if current < last {
+			return current
+		}
+		return current - last
+	}
+	u.store.AddAccount(session.user, delta(uploaded, last.uploaded), delta(downloaded, last.downloaded))
+
+	if event == trackercommon.EventStopped {
+		delete(u.sessions, session)
+		return
+	}
+	u.sessions[session] = &_SessionStats{
+		uploaded:   uploaded,
+		downloaded: downloaded,
+		announced:  now,
+	}
+}
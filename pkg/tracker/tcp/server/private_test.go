@@ -0,0 +1,166 @@
+package trackertcpserver
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/movsb/torrent/pkg/common"
+	"github.com/movsb/torrent/pkg/torrent/torrenttest"
+	trackercommon "github.com/movsb/torrent/pkg/tracker/common"
+	trackerstore "github.com/movsb/torrent/pkg/tracker/store"
+	trackertcpclient "github.com/movsb/torrent/pkg/tracker/tcp/client"
+)
+
+// createTorrent creates a torrent of a file in the directory,
+// and returns its info hash.
+func createTorrent(t *testing.T, dir string, name string) common.Hash {
+	root := t.TempDir()
+	torrenttest.WriteFiles(t, root, map[string][]byte{name: []byte(name)})
+	path := filepath.Join(dir, name+`.torrent`)
+	torrenttest.Create(t, filepath.Join(root, name), path)
+	ih, err := infoHashOf(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ih
+}
+
+func TestPrivate(t *testing.T) {
+	dir := t.TempDir()
+	torrents := filepath.Join(dir, `torrents`)
+	if err := os.Mkdir(torrents, 0755); err != nil {
+		t.Fatal(err)
+	}
+	ih1 := createTorrent(t, torrents, `a`)
+	usersFile := filepath.Join(dir, `users`)
+	if err := ioutil.WriteFile(usersFile, []byte("# user passkey\nalice k1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(``, nil)
+	if err := s.SetAllowlist(torrents); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetUsers(usersFile); err != nil {
+		t.Fatal(err)
+	}
+	hs := httptest.NewServer(s.handler(`/announce`))
+	defer hs.Close()
+
+	announce := func(passkey string, ih common.Hash, uploaded, downloaded int64, event trackercommon.Event) error {
+		c := trackertcpclient.Client{Address: hs.URL + `/` + passkey + `/announce`}
+		_, err := c.Announce(context.Background(), &trackercommon.AnnounceRequest{
+			InfoHash:   ih,
+			PeerID:     trackercommon.MyPeerID,
+			Port:       6881,
+			Uploaded:   uploaded,
+			Downloaded: downloaded,
+			Event:      event,
+		})
+		return err
+	}
+	expectFailure := func(err error, reason string) {
+		t.Helper()
+		if err == nil || !strings.Contains(err.Error(), reason) {
+			t.Fatalf("want failure %q, got %v", reason, err)
+		}
+	}
+
+	expectFailure(announce(`k2`, ih1, 0, 0, trackercommon.EventStarted), `invalid passkey`)
+	expectFailure(announce(`k1`, common.Hash{1}, 0, 0, trackercommon.EventStarted), `unregistered torrent`)
+
+	for _, a := range []struct {
+		uploaded, downloaded int64
+		event                trackercommon.Event
+	}{
+		{0, 0, trackercommon.EventStarted},
+		{100, 50, trackercommon.EventNone},
+		{150, 50, trackercommon.EventStopped},
+		// A new session.
+		{10, 0, trackercommon.EventStarted},
+	} {
+		if err := announce(`k1`, ih1, a.uploaded, a.downloaded, a.event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if accounts := s.Accounts(); accounts[`alice`] != (trackerstore.Account{Uploaded: 160, Downloaded: 50}) {
+		t.Fatalf("accounts: %+v", accounts)
+	}
+
+	c := trackertcpclient.Client{Address: hs.URL + `/k1/announce`}
+	results, err := c.Scrape(context.Background(), []common.Hash{ih1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Complete != 1 {
+		t.Fatalf("scrape: %+v", results)
+	}
+
+	// Reloaded once changed.
+	ih2 := createTorrent(t, torrents, `b`)
+	if err := ioutil.WriteFile(usersFile, []byte("alice k1\nbob k2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectFailure(announce(`k2`, ih2, 0, 0, trackercommon.EventStarted), `invalid passkey`)
+	if err := s.allowlist.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.users.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := announce(`k2`, ih2, 0, 0, trackercommon.EventStarted); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAllowlistFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), `allowlist`)
+	ih := common.Hash{1}
+	content := "# allowed\n\n" + ih.String() + "\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(``, nil)
+	if err := s.SetAllowlist(path); err != nil {
+		t.Fatal(err)
+	}
+	if !s.allowlist.Allowed(ih) || s.allowlist.Allowed(common.Hash{2}) {
+		t.Fatal("allowed")
+	}
+
+	// Malformed files fail to load, and the old list is kept.
+	if err := ioutil.WriteFile(path, []byte(content+"xyz\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.allowlist.reload(); err == nil {
+		t.Fatal("malformed allowlist loaded")
+	}
+	if !s.allowlist.Allowed(ih) {
+		t.Fatal("old allowlist is not kept")
+	}
+}
+
+func TestAccountExpiredSession(t *testing.T) {
+	store := trackerstore.New()
+	u := &_Users{store: store, sessions: make(map[_Session]*_SessionStats)}
+	session := _Session{user: `alice`}
+	now := time.Now()
+
+	u.Account(session, 0, 0, trackercommon.EventStarted, now, time.Minute)
+	u.Account(session, 100, 100, trackercommon.EventNone, now, time.Minute)
+
+	// Back after the session expired, whose totals are accounted already.
+	now = now.Add(2 * time.Minute)
+	u.Account(session, 150, 120, trackercommon.EventNone, now, time.Minute)
+	u.Account(session, 200, 150, trackercommon.EventNone, now, time.Minute)
+	if a := store.Accounts()[`alice`]; a != (trackerstore.Account{Uploaded: 150, Downloaded: 130}) {
+		t.Fatalf("account: %+v", a)
+	}
+}
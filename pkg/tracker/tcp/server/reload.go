@@ -0,0 +1,70 @@
+package trackertcpserver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// _Reloader loads a file, or a directory, and reloads it once it's changed,
+// which is told by the sizes and the modification times.
+type _Reloader struct {
+	path string
+	load func(path string) error
+
+	mu        sync.Mutex
+	signature string
+}
+
+func _NewReloader(path string, load func(path string) error) (*_Reloader, error) {
+	r := &_Reloader{
+		path: path,
+		load: load,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload reloads the file if it's changed. The file loaded last time
+// is kept in use if it fails to load.
+func (r *_Reloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	signature, err := signatureOf(r.path)
+	if err != nil {
+		return err
+	}
+	if signature == r.signature {
+		return nil
+	}
+	if err := r.load(r.path); err != nil {
+		return err
+	}
+	r.signature = signature
+	return nil
+}
+
+func signatureOf(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ``, err
+	}
+	if !info.IsDir() {
+		return fmt.Sprintf(`%d %d`, info.Size(), info.ModTime().UnixNano()), nil
+	}
+
+	infos, err := ioutil.ReadDir(path)
+	if err != nil {
+		return ``, err
+	}
+	var b strings.Builder
+	for _, info := range infos {
+		fmt.Fprintf(&b, "%s %d %d\n", info.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String(), nil
+}
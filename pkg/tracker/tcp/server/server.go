@@ -3,6 +3,7 @@ package trackertcpserver
 import (
 	"context"
 	"fmt"
+	"log"
 	"net"
 	"net/http"
 	"net/url"
@@ -11,6 +12,9 @@ import (
 	"strings"
 	"time"
 
+	"github.com/movsb/torrent/pkg/common"
+	trackercommon "github.com/movsb/torrent/pkg/tracker/common"
+	trackerstore "github.com/movsb/torrent/pkg/tracker/store"
 	trackertcpcommon "github.com/movsb/torrent/pkg/tracker/tcp/common"
 	"github.com/zeebo/bencode"
 )
@@ -18,18 +22,33 @@ import (
 // Server ...
 type Server struct {
 	endpoint string
-	cache    *_PeerCache
+	store    *trackerstore.Store
+
+	// Optional, for private servers.
+	allowlist *_Allowlist
+	users     *_Users
+
+	// Closed once the server is shut down.
+	done chan struct{}
 }
 
-// NewServer ...
-func NewServer(endpoint string) *Server {
+// NewServer creates a server storing peers in store,
+// or in a new store if it's nil.
+func NewServer(endpoint string, store *trackerstore.Store) *Server {
+	if store == nil {
+		store = trackerstore.New()
+	}
 	return &Server{
 		endpoint: endpoint,
-		cache:    _NewPeerCache(),
+		store:    store,
 	}
 }
 
-// Run ...
+// shutdownTimeout is how long requests being handled are waited for
+// when the server is shut down.
+const shutdownTimeout = 5 * time.Second
+
+// Run starts serving, and the server is shut down once ctx is done.
 func (s *Server) Run(ctx context.Context) error {
 	endpoint := s.endpoint
 	if !strings.Contains(endpoint, "://") {
@@ -40,48 +59,58 @@ func (s *Server) Run(ctx context.Context) error {
 		return err
 	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc(filepath.Join(`/`, u.Path), s.handleAnnounce)
+	l, err := net.Listen(`tcp`, u.Host)
+	if err != nil {
+		return err
+	}
 
 	hs := http.Server{
-		Addr:    u.Host,
-		Handler: mux,
+		Handler: s.handler(filepath.Join(`/`, u.Path)),
 	}
+	s.done = make(chan struct{})
 
-	ch := make(chan error)
 	go func() {
-		err := hs.ListenAndServe()
-		if err != http.ErrServerClosed {
-			if ch != nil { // race?
-				ch <- err
-			}
+		if err := hs.Serve(l); err != http.ErrServerClosed {
+			log.Printf("trackertcpserver: serve failed: %v", err)
 		}
 	}()
-	select {
-	case err := <-ch:
-		close(ch)
-		return err
-	case <-time.After(time.Second):
-		c := ch
-		ch = nil // race?
-		close(c)
-		go func() {
-			<-ctx.Done()
-			// TODO(movsb): wait this done
-			hs.Shutdown(context.Background())
-		}()
-		return nil
+	go s.reload(ctx)
+	go func() {
+		defer close(s.done)
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		hs.Shutdown(sctx)
+	}()
+
+	return nil
+}
+
+// Wait waits until the server is shut down, after ctx of Run is done.
+func (s *Server) Wait() {
+	<-s.done
+}
+
+// handler handles announces to the path, and scrapes to the scrape path of it.
+func (s *Server) handler(announcePath string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(announcePath, s.handleAnnounce)
+	// Announces to any path are handled if the path is the root,
+	// and the conventional /scrape is for scrapes of /announce.
+	if announcePath == `/` {
+		mux.HandleFunc(`/scrape`, s.handleScrape)
+	} else if scrapePath, ok := trackertcpcommon.ScrapePath(announcePath); ok {
+		mux.HandleFunc(scrapePath, s.handleScrape)
 	}
+	if s.users != nil {
+		return s.privateHandler(mux)
+	}
+	return mux
 }
 
 func (s *Server) handleAnnounce(w http.ResponseWriter, r *http.Request) {
 	announceError := func(w http.ResponseWriter, err error) {
-		w.WriteHeader(400)
-		bencode.NewEncoder(w).Encode(
-			&trackertcpcommon.AnnounceResponse{
-				FailureReason: err.Error(),
-			},
-		)
+		writeFailure(w, err.Error())
 	}
 
 	var (
@@ -89,6 +118,7 @@ func (s *Server) handleAnnounce(w http.ResponseWriter, r *http.Request) {
 		peerID   [20]byte
 		ip       string
 		port     int
+		left     int64 = -1
 	)
 
 	host, _, err := net.SplitHostPort(r.RemoteAddr)
@@ -96,12 +126,15 @@ func (s *Server) handleAnnounce(w http.ResponseWriter, r *http.Request) {
 		announceError(w, err)
 		return
 	}
-	ipv4 := net.ParseIP(host).To4()
-	if ipv4 == nil {
+	remoteIP := net.ParseIP(host)
+	if remoteIP == nil {
 		announceError(w, fmt.Errorf("invalid remote address"))
 		return
 	}
-	ip = ipv4.String()
+	if ipv4 := remoteIP.To4(); ipv4 != nil {
+		remoteIP = ipv4
+	}
+	ip = remoteIP.String()
 
 	paramFuncs := map[string]func(value string) error{
 		`info_hash`: func(value string) error {
@@ -135,20 +168,162 @@ func (s *Server) handleAnnounce(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	peersCache := s.cache.Add(infoHash, peerID, ip, port)
+	if s.allowlist != nil && !s.allowlist.Allowed(infoHash) {
+		announceError(w, fmt.Errorf("unregistered torrent"))
+		return
+	}
+
+	query := r.URL.Query()
+	if value := query.Get(`left`); value != `` {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			announceError(w, fmt.Errorf("param left: invalid left"))
+			return
+		}
+		left = n
+	}
+	event, err := trackercommon.ParseEvent(query.Get(`event`))
+	if err != nil {
+		announceError(w, fmt.Errorf("param event: %v", err))
+		return
+	}
+	numWant := -1
+	if value := query.Get(`numwant`); value != `` {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			announceError(w, fmt.Errorf("param numwant: invalid numwant"))
+			return
+		}
+		numWant = n
+	}
+	compact := query.Get(`compact`) == `1`
+	noPeerID := query.Get(`no_peer_id`) == `1`
+
+	if s.users != nil {
+		var uploaded, downloaded int64
+		for name, p := range map[string]*int64{`uploaded`: &uploaded, `downloaded`: &downloaded} {
+			if value := query.Get(name); value != `` {
+				n, err := strconv.ParseInt(value, 10, 64)
+				if err != nil || n < 0 {
+					announceError(w, fmt.Errorf("param %s: invalid %s", name, name))
+					return
+				}
+				*p = n
+			}
+		}
+		s.users.Account(
+			_Session{user: userOf(r), infoHash: infoHash, peerID: peerID},
+			uploaded, downloaded, event,
+			time.Now(), s.store.Interval*time.Duration(s.store.Expiry),
+		)
+	}
+
+	stored := s.store.Announce(infoHash, trackerstore.Peer{
+		ID:   peerID,
+		IP:   ip,
+		Port: port,
+		Left: left,
+	}, event, numWant)
 	peers := []trackertcpcommon.Peer{}
-	for _, c := range peersCache {
+	for _, c := range stored {
 		peers = append(peers, trackertcpcommon.Peer{
-			ID:   c.PeerID,
+			ID:   c.ID,
 			IP:   c.IP,
 			Port: c.Port,
 		})
 	}
 
+	stats := s.store.Stats(infoHash)
+	resp := &_AnnounceResponse{
+		Interval:   int(s.store.Interval / time.Second),
+		Complete:   stats.Seeders,
+		Incomplete: stats.Leechers,
+	}
+	encodePeers(resp, peers, compact, noPeerID)
+	bencode.NewEncoder(w).Encode(resp)
+}
+
+// _AnnounceResponse is the announce response sent, whose peers are
+// either compact strings, or a list of dictionaries with or without peer ids.
+type _AnnounceResponse struct {
+	Interval   int         `bencode:"interval"`
+	Complete   int         `bencode:"complete"`
+	Incomplete int         `bencode:"incomplete"`
+	Peers      interface{} `bencode:"peers"`
+	Peers6     string      `bencode:"peers6,omitempty"`
+}
+
+type _PeerNoID struct {
+	IP   string `bencode:"ip"`
+	Port int    `bencode:"port"`
+}
+
+// encodePeers encodes the peers in the compact form if asked (BEP 23),
+// IPv4 ones in peers, and IPv6 ones in peers6 (BEP 7). Otherwise all the
+// peers are listed as dictionaries, without peer ids if asked.
+func encodePeers(resp *_AnnounceResponse, peers []trackertcpcommon.Peer, compact bool, noPeerID bool) {
+	switch {
+	case compact:
+		resp.Peers = string(trackertcpcommon.EncodeCompact(peers, net.IPv4len))
+		resp.Peers6 = string(trackertcpcommon.EncodeCompact(peers, net.IPv6len))
+	case noPeerID:
+		list := make([]_PeerNoID, 0, len(peers))
+		for _, p := range peers {
+			list = append(list, _PeerNoID{IP: p.IP, Port: p.Port})
+		}
+		resp.Peers = list
+	default:
+		resp.Peers = peers
+	}
+}
+
+// handleScrape responds the stats of the torrents of the info hashes,
+// or of all the torrents if no info hashes are given.
+func (s *Server) handleScrape(w http.ResponseWriter, r *http.Request) {
+	var infoHashes []common.Hash
+	for _, value := range r.URL.Query()[`info_hash`] {
+		if len(value) != 20 {
+			writeFailure(w, `param info_hash: invalid info_hash`)
+			return
+		}
+		var ih common.Hash
+		copy(ih[:], value)
+		infoHashes = append(infoHashes, ih)
+	}
+	if len(infoHashes) == 0 {
+		infoHashes = s.store.InfoHashes()
+	}
+	if s.allowlist != nil {
+		allowed := infoHashes[:0]
+		for _, ih := range infoHashes {
+			if s.allowlist.Allowed(ih) {
+				allowed = append(allowed, ih)
+			}
+		}
+		infoHashes = allowed
+	}
+
+	resp := trackertcpcommon.ScrapeResponse{
+		Files: make(map[string]trackertcpcommon.ScrapeFile, len(infoHashes)),
+	}
+	for _, ih := range infoHashes {
+		stats := s.store.Stats(ih)
+		resp.Files[string(ih[:])] = trackertcpcommon.ScrapeFile{
+			Complete:   stats.Seeders,
+			Downloaded: stats.Completed,
+			Incomplete: stats.Leechers,
+		}
+	}
+
+	bencode.NewEncoder(w).Encode(&resp)
+}
+
+// writeFailure responds the failure reason. The status is 200,
+// or clients may not show the reason.
+func writeFailure(w http.ResponseWriter, reason string) {
 	bencode.NewEncoder(w).Encode(
 		&trackertcpcommon.AnnounceResponse{
-			Interval: 60,
-			Peers:    peers,
+			FailureReason: reason,
 		},
 	)
 }
@@ -2,7 +2,19 @@ package trackertcpserver
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/movsb/torrent/pkg/common"
+	trackercommon "github.com/movsb/torrent/pkg/tracker/common"
+	trackertcpclient "github.com/movsb/torrent/pkg/tracker/tcp/client"
+	trackertcpcommon "github.com/movsb/torrent/pkg/tracker/tcp/common"
+	"github.com/zeebo/bencode"
 )
 
 func TestServer(t *testing.T) {
@@ -17,4 +29,118 @@ func TestServer(t *testing.T) {
 	}
 
 	cancel()
+	s.Wait()
+}
+
+func announce(t *testing.T, s *Server, remote string, query string) map[string]interface{} {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, `/announce?`+query, nil)
+	r.RemoteAddr = remote
+	w := httptest.NewRecorder()
+	s.handleAnnounce(w, r)
+	if w.Code != 200 {
+		t.Fatalf("status: %d: %s", w.Code, w.Body.String())
+	}
+	var resp map[string]interface{}
+	if err := bencode.DecodeString(w.Body.String(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestAnnouncePeers(t *testing.T) {
+	s := NewServer(`localhost:0`, nil)
+
+	ih := url.QueryEscape(strings.Repeat(`i`, 20))
+	query := func(id string, port int, extra string) string {
+		return fmt.Sprintf(`info_hash=%s&peer_id=%s&port=%d%s`, ih, strings.Repeat(id, 20), port, extra)
+	}
+
+	announce(t, s, `1.2.3.4:1000`, query(`a`, 6881, ``))
+	announce(t, s, `[2001:db8::1]:1000`, query(`b`, 6881, ``))
+
+	resp := announce(t, s, `5.6.7.8:1000`, query(`c`, 80, `&compact=1`))
+	peers, ok := resp[`peers`].(string)
+	if !ok || len(peers) != 6 {
+		t.Fatalf("compact peers: %q", resp[`peers`])
+	}
+	peers6, ok := resp[`peers6`].(string)
+	if !ok || len(peers6) != 18 {
+		t.Fatalf("compact peers6: %q", resp[`peers6`])
+	}
+
+	resp = announce(t, s, `5.6.7.8:1000`, query(`c`, 80, ``))
+	list, ok := resp[`peers`].([]interface{})
+	if !ok || len(list) != 2 {
+		t.Fatalf("peers: %v", resp[`peers`])
+	}
+	for _, p := range list {
+		if _, ok := p.(map[string]interface{})[`peer id`]; !ok {
+			t.Fatalf("peer id is missing: %v", p)
+		}
+	}
+
+	resp = announce(t, s, `5.6.7.8:1000`, query(`c`, 80, `&no_peer_id=1`))
+	list, ok = resp[`peers`].([]interface{})
+	if !ok || len(list) != 2 {
+		t.Fatalf("peers: %v", resp[`peers`])
+	}
+	for _, p := range list {
+		if _, ok := p.(map[string]interface{})[`peer id`]; ok {
+			t.Fatalf("peer id is sent: %v", p)
+		}
+	}
+}
+
+func TestScrape(t *testing.T) {
+	s := NewServer(``, nil)
+	hs := httptest.NewServer(s.handler(`/announce`))
+	defer hs.Close()
+
+	c := trackertcpclient.Client{Address: hs.URL + `/announce`}
+	ih1, ih2, ih3 := common.Hash{1}, common.Hash{2}, common.Hash{3}
+	for i, left := range []int64{0, 1, 2} {
+		_, err := c.Announce(context.Background(), &trackercommon.AnnounceRequest{
+			InfoHash: ih1,
+			PeerID:   trackercommon.MyPeerID,
+			Port:     1000 + i,
+			Left:     left,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.Announce(context.Background(), &trackercommon.AnnounceRequest{
+		InfoHash: ih2,
+		Port:     1000,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := c.Scrape(context.Background(), []common.Hash{ih1, ih2, ih3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []trackercommon.ScrapeResult{
+		{InfoHash: ih1, Complete: 1, Incomplete: 2},
+		{InfoHash: ih2, Complete: 1},
+		{InfoHash: ih3},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Fatalf("got %+v, want %+v", results, want)
+	}
+
+	// All the torrents without info hashes.
+	rsp, err := http.Get(hs.URL + `/scrape`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+	var all trackertcpcommon.ScrapeResponse
+	if err := bencode.NewDecoder(rsp.Body).Decode(&all); err != nil {
+		t.Fatal(err)
+	}
+	if len(all.Files) != 2 {
+		t.Fatalf("files: %v", all.Files)
+	}
 }
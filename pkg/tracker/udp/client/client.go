@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/movsb/torrent/pkg/common"
+	trackercommon "github.com/movsb/torrent/pkg/tracker/common"
 	"github.com/movsb/torrent/pkg/utils"
 )
 
@@ -22,31 +23,85 @@ func makeTransactionID() uint32 {
 
 // Client ...
 type Client struct {
-	Address  string
-	InfoHash common.Hash
-	MyPeerID common.PeerID
-	conn     *net.UDPConn
+	Address string
+	conn    *net.UDPConn
 }
 
 // Announce ...
-// Ctx isn't used.
-func (t *Client) Announce(ctx context.Context) (*AnnounceResponse, error) {
+// The request is aborted once ctx is done.
+func (t *Client) Announce(ctx context.Context, r *trackercommon.AnnounceRequest) (*AnnounceResponse, error) {
 	if err := t.dial(); err != nil {
 		return nil, err
 	}
 	defer t.conn.Close()
+	defer t.abortOnDone(ctx)()
 
 	connResp, err := t.connect()
 	if err != nil {
 		return nil, err
 	}
-	announceResp, err := t.announce(connResp.ConnectionID)
+	announceResp, err := t.announce(connResp.ConnectionID, r)
 	if err != nil {
 		return nil, err
 	}
 	return announceResp, nil
 }
 
+// maxScrapeInfoHashes is the max info hashes scraped at a time,
+// so that the response fits in a packet.
+const maxScrapeInfoHashes = 74
+
+// Scrape scrapes the stats of the torrents, in the order of the info hashes.
+// The request is aborted once ctx is done.
+func (t *Client) Scrape(ctx context.Context, infoHashes []common.Hash) ([]trackercommon.ScrapeResult, error) {
+	if err := t.dial(); err != nil {
+		return nil, err
+	}
+	defer t.conn.Close()
+	defer t.abortOnDone(ctx)()
+
+	connResp, err := t.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]trackercommon.ScrapeResult, 0, len(infoHashes))
+	for len(infoHashes) > 0 {
+		n := len(infoHashes)
+		if n > maxScrapeInfoHashes {
+			n = maxScrapeInfoHashes
+		}
+		scrapeResp, err := t.scrape(connResp.ConnectionID, infoHashes[:n])
+		if err != nil {
+			return nil, err
+		}
+		for i, f := range scrapeResp.Files {
+			results = append(results, trackercommon.ScrapeResult{
+				InfoHash:   infoHashes[i],
+				Complete:   int(f.Seeders),
+				Incomplete: int(f.Leechers),
+				Downloaded: int(f.Completed),
+			})
+		}
+		infoHashes = infoHashes[n:]
+	}
+	return results, nil
+}
+
+// abortOnDone closes the connection once ctx is done, until the returned
+// function is called.
+func (t *Client) abortOnDone(ctx context.Context) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			t.conn.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
 func (t *Client) dial() error {
 	u, err := url.Parse(t.Address)
 	if err != nil {
@@ -73,7 +128,7 @@ func (t *Client) connect() (*ConnectResponse, error) {
 	defer t.conn.SetDeadline(time.Time{})
 
 	req := ConnectRequest{
-		ProtocolID:    protocolID,
+		ProtocolID:    ProtocolID,
 		Action:        ActionConnect,
 		TransactionID: makeTransactionID(),
 	}
@@ -88,15 +143,18 @@ func (t *Client) connect() (*ConnectResponse, error) {
 		return nil, fmt.Errorf("connect error: %v", err)
 	}
 
-	b = make([]byte, 16)
+	b = make([]byte, 65536)
 	utils.SetDeadlineSeconds(t.conn, 10)
-	_, err = t.conn.Read(b)
+	n, err := t.conn.Read(b)
 	if err != nil {
 		return nil, fmt.Errorf("read error: %v", err)
 	}
+	if err := checkError(b[:n]); err != nil {
+		return nil, err
+	}
 
 	resp := ConnectResponse{}
-	if err = resp.Unmarshal(b); err != nil {
+	if err = resp.Unmarshal(b[:n]); err != nil {
 		return nil, fmt.Errorf("ConnectResponse error: %v", err)
 	}
 	if resp.TransactionID != req.TransactionID {
@@ -108,23 +166,28 @@ func (t *Client) connect() (*ConnectResponse, error) {
 	return &resp, nil
 }
 
-func (t *Client) announce(connectionID uint64) (*AnnounceResponse, error) {
+func (t *Client) announce(connectionID uint64, r *trackercommon.AnnounceRequest) (*AnnounceResponse, error) {
 	defer t.conn.SetDeadline(time.Time{})
 
+	ip := net.IPv4zero
+	if ipv4 := r.IP.To4(); ipv4 != nil {
+		ip = ipv4
+	}
+
 	req := AnnounceRequest{
 		ConnectionID:  connectionID,
 		Action:        ActionAnnounce,
 		TransactionID: makeTransactionID(),
-		InfoHash:      t.InfoHash,
-		PeerID:        t.MyPeerID,
-		Downloaded:    0,
-		Left:          0,
-		Uploaded:      0,
-		Event:         EventNone,
-		IP:            net.IPv4zero,
-		Key:           0,
-		NumWant:       -1,
-		Port:          8888,
+		InfoHash:      r.InfoHash,
+		PeerID:        r.PeerID,
+		Downloaded:    uint64(r.Downloaded),
+		Left:          uint64(r.Left),
+		Uploaded:      uint64(r.Uploaded),
+		Event:         Event(r.Event),
+		IP:            ip,
+		Key:           r.Key,
+		NumWant:       int32(r.NumWant),
+		Port:          uint16(r.Port),
 	}
 	b, err := req.Marshal()
 	if err != nil {
@@ -142,6 +205,9 @@ func (t *Client) announce(connectionID uint64) (*AnnounceResponse, error) {
 	if err != nil {
 		return nil, fmt.Errorf("read announce failed: %v", err)
 	}
+	if err := checkError(b[:n]); err != nil {
+		return nil, err
+	}
 
 	resp := AnnounceResponse{}
 	if err = resp.Unmarshal(b[:n]); err != nil {
@@ -157,3 +223,58 @@ func (t *Client) announce(connectionID uint64) (*AnnounceResponse, error) {
 
 	return &resp, nil
 }
+
+// checkError returns the error message of an error response as an error.
+func checkError(b []byte) error {
+	var resp ErrorResponse
+	if err := resp.Unmarshal(b); err != nil || resp.Action != ActionError {
+		return nil
+	}
+	return fmt.Errorf("tracker: %s", resp.Message)
+}
+
+func (t *Client) scrape(connectionID uint64, infoHashes []common.Hash) (*ScrapeResponse, error) {
+	defer t.conn.SetDeadline(time.Time{})
+
+	req := ScrapeRequest{
+		ConnectionID:  connectionID,
+		Action:        ActionScrape,
+		TransactionID: makeTransactionID(),
+		InfoHashes:    infoHashes,
+	}
+	b, err := req.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("marshal error: %v", err)
+	}
+
+	utils.SetDeadlineSeconds(t.conn, 10)
+	if _, err := t.conn.Write(b); err != nil {
+		return nil, fmt.Errorf("scrape error: %v", err)
+	}
+
+	b = make([]byte, 65536)
+	utils.SetDeadlineSeconds(t.conn, 10)
+	n, err := t.conn.Read(b)
+	if err != nil {
+		return nil, fmt.Errorf("read scrape failed: %v", err)
+	}
+	if err := checkError(b[:n]); err != nil {
+		return nil, err
+	}
+
+	resp := ScrapeResponse{}
+	if err = resp.Unmarshal(b[:n]); err != nil {
+		return nil, fmt.Errorf("ScrapeResponse error: %v", err)
+	}
+	if resp.TransactionID != req.TransactionID {
+		return nil, fmt.Errorf("TransactionID mismatch")
+	}
+	if resp.Action != ActionScrape {
+		return nil, fmt.Errorf("Action mismatch")
+	}
+	if len(resp.Files) != len(infoHashes) {
+		return nil, fmt.Errorf("ScrapeResponse: %d files for %d info hashes", len(resp.Files), len(infoHashes))
+	}
+
+	return &resp, nil
+}
@@ -17,12 +17,16 @@ func TestClient(t *testing.T) {
 	}
 
 	ut := &Client{
-		Address:  `udp://tracker.leechers-paradise.org:6969`,
-		InfoHash: f.InfoHash(),
-		MyPeerID: trackercommon.MyPeerID,
+		Address: `udp://tracker.leechers-paradise.org:6969`,
 	}
 
-	resp, err := ut.Announce(context.TODO())
+	resp, err := ut.Announce(context.TODO(), &trackercommon.AnnounceRequest{
+		InfoHash: f.InfoHash(),
+		PeerID:   trackercommon.MyPeerID,
+		Port:     6881,
+		Left:     f.Length,
+		NumWant:  -1,
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
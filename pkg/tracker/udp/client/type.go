@@ -30,9 +30,8 @@ const (
 	EventStopped   = Event(3)
 )
 
-const (
-	protocolID = 0x41727101980
-)
+// ProtocolID is the magic constant of connect requests.
+const ProtocolID = 0x41727101980
 
 // ConnectRequest ...
 type ConnectRequest struct {
@@ -50,6 +49,17 @@ func (c ConnectRequest) Marshal() ([]byte, error) {
 	return b, nil
 }
 
+// Unmarshal ...
+func (c *ConnectRequest) Unmarshal(b []byte) error {
+	if len(b) < 16 {
+		return fmt.Errorf("ConnectRequest: at least 16 bytes are required")
+	}
+	c.ProtocolID = binary.BigEndian.Uint64(b[0:])
+	c.Action = Action(binary.BigEndian.Uint32(b[8:]))
+	c.TransactionID = binary.BigEndian.Uint32(b[12:])
+	return nil
+}
+
 // ConnectResponse ...
 type ConnectResponse struct {
 	Action        Action
@@ -68,6 +78,15 @@ func (c *ConnectResponse) Unmarshal(b []byte) error {
 	return nil
 }
 
+// Marshal ...
+func (c ConnectResponse) Marshal() ([]byte, error) {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint32(b[0:], uint32(c.Action))
+	binary.BigEndian.PutUint32(b[4:], c.TransactionID)
+	binary.BigEndian.PutUint64(b[8:], c.ConnectionID)
+	return b, nil
+}
+
 // AnnounceRequest ...
 type AnnounceRequest struct {
 	ConnectionID  uint64
@@ -104,6 +123,27 @@ func (r AnnounceRequest) Marshal() ([]byte, error) {
 	return b, nil
 }
 
+// Unmarshal ...
+func (r *AnnounceRequest) Unmarshal(b []byte) error {
+	if len(b) < 98 {
+		return fmt.Errorf("AnnounceRequest: at least 98 bytes are required")
+	}
+	r.ConnectionID = binary.BigEndian.Uint64(b[0:])
+	r.Action = Action(binary.BigEndian.Uint32(b[8:]))
+	r.TransactionID = binary.BigEndian.Uint32(b[12:])
+	copy(r.InfoHash[:], b[16:36])
+	copy(r.PeerID[:], b[36:56])
+	r.Downloaded = binary.BigEndian.Uint64(b[56:])
+	r.Left = binary.BigEndian.Uint64(b[64:])
+	r.Uploaded = binary.BigEndian.Uint64(b[72:])
+	r.Event = Event(binary.BigEndian.Uint32(b[80:]))
+	r.IP = net.IPv4(b[84], b[85], b[86], b[87]).To4()
+	r.Key = binary.BigEndian.Uint32(b[88:])
+	r.NumWant = int32(binary.BigEndian.Uint32(b[92:]))
+	r.Port = binary.BigEndian.Uint16(b[96:])
+	return nil
+}
+
 // AnnounceResponse ...
 type AnnounceResponse struct {
 	Action        Action
@@ -140,3 +180,136 @@ func (r *AnnounceResponse) Unmarshal(b []byte) error {
 
 	return nil
 }
+
+// Marshal encodes the peers in 6 bytes for IPv4 ones,
+// and 18 bytes for IPv6 ones.
+func (r AnnounceResponse) Marshal() ([]byte, error) {
+	b := make([]byte, 20, 20+len(r.Peers)*common.CompactPeerLength4)
+	binary.BigEndian.PutUint32(b[0:], uint32(r.Action))
+	binary.BigEndian.PutUint32(b[4:], r.TransactionID)
+	binary.BigEndian.PutUint32(b[8:], r.Interval)
+	binary.BigEndian.PutUint32(b[12:], r.Leechers)
+	binary.BigEndian.PutUint32(b[16:], r.Seeders)
+	for _, peer := range r.Peers {
+		c, err := common.CompactPeer(peer)
+		if err != nil {
+			return nil, fmt.Errorf("AnnounceResponse: %v", err)
+		}
+		b = append(b, c...)
+	}
+	return b, nil
+}
+
+// ScrapeRequest ...
+type ScrapeRequest struct {
+	ConnectionID  uint64
+	Action        Action
+	TransactionID uint32
+	InfoHashes    []common.Hash
+}
+
+// Marshal ...
+func (r ScrapeRequest) Marshal() ([]byte, error) {
+	b := make([]byte, 16, 16+len(r.InfoHashes)*20)
+	binary.BigEndian.PutUint64(b[0:], r.ConnectionID)
+	binary.BigEndian.PutUint32(b[8:], uint32(r.Action))
+	binary.BigEndian.PutUint32(b[12:], r.TransactionID)
+	for _, ih := range r.InfoHashes {
+		b = append(b, ih[:]...)
+	}
+	return b, nil
+}
+
+// Unmarshal ...
+func (r *ScrapeRequest) Unmarshal(b []byte) error {
+	if len(b) < 16 {
+		return fmt.Errorf("ScrapeRequest: at least 16 bytes are required")
+	}
+	if len(b[16:])%20 != 0 {
+		return fmt.Errorf("ScrapeRequest: malformed info hashes")
+	}
+	r.ConnectionID = binary.BigEndian.Uint64(b[0:])
+	r.Action = Action(binary.BigEndian.Uint32(b[8:]))
+	r.TransactionID = binary.BigEndian.Uint32(b[12:])
+	r.InfoHashes = nil
+	for b = b[16:]; len(b) > 0; b = b[20:] {
+		var ih common.Hash
+		copy(ih[:], b)
+		r.InfoHashes = append(r.InfoHashes, ih)
+	}
+	return nil
+}
+
+// ScrapeFile is the stats of a torrent in a scrape response.
+type ScrapeFile struct {
+	Seeders   uint32
+	Completed uint32
+	Leechers  uint32
+}
+
+// ScrapeResponse ...
+type ScrapeResponse struct {
+	Action        Action
+	TransactionID uint32
+	Files         []ScrapeFile // In the order of the info hashes requested.
+}
+
+// Marshal ...
+func (r ScrapeResponse) Marshal() ([]byte, error) {
+	b := make([]byte, 8+len(r.Files)*12)
+	binary.BigEndian.PutUint32(b[0:], uint32(r.Action))
+	binary.BigEndian.PutUint32(b[4:], r.TransactionID)
+	for i, f := range r.Files {
+		binary.BigEndian.PutUint32(b[8+i*12:], f.Seeders)
+		binary.BigEndian.PutUint32(b[12+i*12:], f.Completed)
+		binary.BigEndian.PutUint32(b[16+i*12:], f.Leechers)
+	}
+	return b, nil
+}
+
+// Unmarshal ...
+func (r *ScrapeResponse) Unmarshal(b []byte) error {
+	if len(b) < 8 {
+		return fmt.Errorf("ScrapeResponse: at least 8 bytes are required")
+	}
+	if len(b[8:])%12 != 0 {
+		return fmt.Errorf("ScrapeResponse: malformed files")
+	}
+	r.Action = Action(binary.BigEndian.Uint32(b[0:]))
+	r.TransactionID = binary.BigEndian.Uint32(b[4:])
+	r.Files = nil
+	for b = b[8:]; len(b) > 0; b = b[12:] {
+		r.Files = append(r.Files, ScrapeFile{
+			Seeders:   binary.BigEndian.Uint32(b[0:]),
+			Completed: binary.BigEndian.Uint32(b[4:]),
+			Leechers:  binary.BigEndian.Uint32(b[8:]),
+		})
+	}
+	return nil
+}
+
+// ErrorResponse ...
+type ErrorResponse struct {
+	Action        Action
+	TransactionID uint32
+	Message       string
+}
+
+// Marshal ...
+func (r ErrorResponse) Marshal() ([]byte, error) {
+	b := make([]byte, 8, 8+len(r.Message))
+	binary.BigEndian.PutUint32(b[0:], uint32(r.Action))
+	binary.BigEndian.PutUint32(b[4:], r.TransactionID)
+	return append(b, r.Message...), nil
+}
+
+// Unmarshal ...
+func (r *ErrorResponse) Unmarshal(b []byte) error {
+	if len(b) < 8 {
+		return fmt.Errorf("ErrorResponse: at least 8 bytes are required")
+	}
+	r.Action = Action(binary.BigEndian.Uint32(b[0:]))
+	r.TransactionID = binary.BigEndian.Uint32(b[4:])
+	r.Message = string(b[8:])
+	return nil
+}
@@ -0,0 +1,254 @@
+package trackerudpserver
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	trackercommon "github.com/movsb/torrent/pkg/tracker/common"
+	trackerstore "github.com/movsb/torrent/pkg/tracker/store"
+	trackerudpclient "github.com/movsb/torrent/pkg/tracker/udp/client"
+)
+
+// The max info hashes of a scrape request, so that
+// the response fits in a packet.
+const maxScrapeInfoHashes = 74
+
+// Server is a UDP tracker server (BEP 15).
+//
+// Connection IDs are signed by the address of the client and the time,
+// and are valid for one to two minutes, so no connection state is kept.
+type Server struct {
+	endpoint string
+	store    *trackerstore.Store
+	secret   []byte
+	now      func() time.Time
+	conn     net.PacketConn
+
+	// Closed once the server is closed.
+	done chan struct{}
+}
+
+// NewServer creates a server storing peers in store,
+// or in a new store if it's nil.
+func NewServer(endpoint string, store *trackerstore.Store) *Server {
+	if store == nil {
+		store = trackerstore.New()
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return &Server{
+		endpoint: endpoint,
+		store:    store,
+		secret:   secret,
+		now:      time.Now,
+	}
+}
+
+// Run starts serving, and the server is closed once ctx is done.
+func (s *Server) Run(ctx context.Context) error {
+	conn, err := net.ListenPacket(`udp`, strings.TrimPrefix(s.endpoint, `udp://`))
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	s.done = make(chan struct{})
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	go func() {
+		defer close(s.done)
+		s.serve()
+	}()
+
+	return nil
+}
+
+// Wait waits until the server is closed, after ctx of Run is done.
+func (s *Server) Wait() {
+	<-s.done
+}
+
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+func (s *Server) serve() {
+	b := make([]byte, 2048)
+	for {
+		n, addr, err := s.conn.ReadFrom(b)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("trackerudpserver: read failed: %v", err)
+			}
+			return
+		}
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		resp := s.handle(b[:n], udpAddr)
+		if resp == nil {
+			continue
+		}
+		if _, err := s.conn.WriteTo(resp, addr); err != nil {
+			log.Printf("trackerudpserver: write to %s failed: %v", addr, err)
+		}
+	}
+}
+
+// handle handles a request, and returns the response,
+// or nil if the request is dropped.
+func (s *Server) handle(b []byte, addr *net.UDPAddr) []byte {
+	// All requests start with a connection ID, an action and a transaction ID.
+	if len(b) < 16 {
+		return nil
+	}
+	connectionID := binary.BigEndian.Uint64(b[0:])
+	action := trackerudpclient.Action(binary.BigEndian.Uint32(b[8:]))
+	transactionID := binary.BigEndian.Uint32(b[12:])
+
+	var (
+		resp interface{ Marshal() ([]byte, error) }
+		err  error
+	)
+
+	switch {
+	case action == trackerudpclient.ActionConnect:
+		if connectionID != trackerudpclient.ProtocolID {
+			err = fmt.Errorf("invalid protocol id")
+			break
+		}
+		resp = &trackerudpclient.ConnectResponse{
+			Action:        trackerudpclient.ActionConnect,
+			TransactionID: transactionID,
+			ConnectionID:  s.connectionID(addr, s.now()),
+		}
+	case !s.validConnectionID(addr, connectionID):
+		err = fmt.Errorf("invalid connection id")
+	case action == trackerudpclient.ActionAnnounce:
+		resp, err = s.handleAnnounce(b, addr)
+	case action == trackerudpclient.ActionScrape:
+		resp, err = s.handleScrape(b)
+	default:
+		err = fmt.Errorf("unknown action")
+	}
+
+	if err != nil {
+		resp = &trackerudpclient.ErrorResponse{
+			Action:        trackerudpclient.ActionError,
+			TransactionID: transactionID,
+			Message:       err.Error(),
+		}
+	}
+
+	out, err := resp.Marshal()
+	if err != nil {
+		log.Printf("trackerudpserver: marshal failed: %v", err)
+		return nil
+	}
+	return out
+}
+
+func (s *Server) handleAnnounce(b []byte, addr *net.UDPAddr) (*trackerudpclient.AnnounceResponse, error) {
+	var req trackerudpclient.AnnounceRequest
+	if err := req.Unmarshal(b); err != nil {
+		return nil, err
+	}
+	if req.Port == 0 {
+		return nil, fmt.Errorf("invalid port")
+	}
+
+	// The IP in the request is ignored, peers are only reachable
+	// by the address the request is sent from.
+	ip := addr.IP
+	if ipv4 := ip.To4(); ipv4 != nil {
+		ip = ipv4
+	}
+
+	stored := s.store.Announce(req.InfoHash, trackerstore.Peer{
+		ID:   req.PeerID,
+		IP:   ip.String(),
+		Port: int(req.Port),
+		Left: int64(req.Left),
+	}, trackercommon.Event(req.Event), int(req.NumWant))
+
+	stats := s.store.Stats(req.InfoHash)
+	resp := &trackerudpclient.AnnounceResponse{
+		Action:        trackerudpclient.ActionAnnounce,
+		TransactionID: req.TransactionID,
+		Interval:      uint32(s.store.Interval / time.Second),
+		Leechers:      uint32(stats.Leechers),
+		Seeders:       uint32(stats.Seeders),
+	}
+
+	// Peers of the IP version of the request only, the client
+	// tells IPv4 peers from IPv6 ones by the address family.
+	ipv6 := ip.To4() == nil
+	for _, p := range stored {
+		peerIP := net.ParseIP(p.IP)
+		if peerIP == nil || (peerIP.To4() == nil) != ipv6 {
+			continue
+		}
+		resp.Peers = append(resp.Peers, p.Address())
+	}
+
+	return resp, nil
+}
+
+func (s *Server) handleScrape(b []byte) (*trackerudpclient.ScrapeResponse, error) {
+	var req trackerudpclient.ScrapeRequest
+	if err := req.Unmarshal(b); err != nil {
+		return nil, err
+	}
+	if len(req.InfoHashes) > maxScrapeInfoHashes {
+		req.InfoHashes = req.InfoHashes[:maxScrapeInfoHashes]
+	}
+
+	resp := &trackerudpclient.ScrapeResponse{
+		Action:        trackerudpclient.ActionScrape,
+		TransactionID: req.TransactionID,
+	}
+	for _, ih := range req.InfoHashes {
+		stats := s.store.Stats(ih)
+		resp.Files = append(resp.Files, trackerudpclient.ScrapeFile{
+			Seeders:   uint32(stats.Seeders),
+			Completed: uint32(stats.Completed),
+			Leechers:  uint32(stats.Leechers),
+		})
+	}
+
+	return resp, nil
+}
+
+// connectionID signs the address with the minute of t.
+func (s *Server) connectionID(addr *net.UDPAddr, t time.Time) uint64 {
+	b := make([]byte, 8+net.IPv6len+2)
+	binary.BigEndian.PutUint64(b[0:], uint64(t.Unix()/60))
+	copy(b[8:], addr.IP.To16())
+	binary.BigEndian.PutUint16(b[8+net.IPv6len:], uint16(addr.Port))
+
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(b)
+	return binary.BigEndian.Uint64(mac.Sum(nil))
+}
+
+// validConnectionID reports whether the ID is signed in this minute,
+// or in the last one.
+func (s *Server) validConnectionID(addr *net.UDPAddr, id uint64) bool {
+	now := s.now()
+	return id == s.connectionID(addr, now) || id == s.connectionID(addr, now.Add(-time.Minute))
+}
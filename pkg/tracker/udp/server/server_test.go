@@ -0,0 +1,161 @@
+package trackerudpserver
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/movsb/torrent/pkg/common"
+	trackercommon "github.com/movsb/torrent/pkg/tracker/common"
+	trackerudpclient "github.com/movsb/torrent/pkg/tracker/udp/client"
+)
+
+func TestAnnounce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := NewServer(`udp://127.0.0.1:0`, nil)
+	if err := s.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	c := trackerudpclient.Client{Address: `udp://` + s.Addr().String()}
+	ih := common.Hash{1, 2, 3}
+
+	for i, port := range []int{1000, 2000} {
+		resp, err := c.Announce(ctx, &trackercommon.AnnounceRequest{
+			InfoHash: ih,
+			PeerID:   common.PeerID{byte(i + 1)},
+			Port:     port,
+			Left:     int64(i),
+			NumWant:  -1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The requesting peer is excluded.
+		if len(resp.Peers) != i {
+			t.Fatalf("peers: %v", resp.Peers)
+		}
+		if resp.Interval != 60 {
+			t.Fatalf("interval: %d", resp.Interval)
+		}
+	}
+
+	resp, err := c.Announce(ctx, &trackercommon.AnnounceRequest{InfoHash: ih, Port: 3000, Left: 1, NumWant: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Seeders != 1 || resp.Leechers != 2 || len(resp.Peers) != 2 {
+		t.Fatalf("seeders: %d, leechers: %d, peers: %v", resp.Seeders, resp.Leechers, resp.Peers)
+	}
+
+	if _, err := c.Announce(ctx, &trackercommon.AnnounceRequest{InfoHash: ih}); err == nil || !strings.Contains(err.Error(), `invalid port`) {
+		t.Fatalf("port 0: %v", err)
+	}
+
+	// More info hashes than a packet holds are scraped in batches.
+	hashes := make([]common.Hash, 100)
+	hashes[99] = ih
+	results, err := c.Scrape(ctx, hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 100 || results[99] != (trackercommon.ScrapeResult{InfoHash: ih, Complete: 1, Incomplete: 2}) {
+		t.Fatalf("scrape: %d results, %+v", len(results), results[99])
+	}
+}
+
+func TestHandle(t *testing.T) {
+	s := NewServer(``, nil)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	addr := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 1000}
+	ih := common.Hash{1}
+
+	b, _ := trackerudpclient.ConnectRequest{
+		ProtocolID:    trackerudpclient.ProtocolID,
+		Action:        trackerudpclient.ActionConnect,
+		TransactionID: 1,
+	}.Marshal()
+	var connResp trackerudpclient.ConnectResponse
+	if err := connResp.Unmarshal(s.handle(b, addr)); err != nil {
+		t.Fatal(err)
+	}
+	if connResp.Action != trackerudpclient.ActionConnect || connResp.TransactionID != 1 {
+		t.Fatalf("connect: %+v", connResp)
+	}
+	id := connResp.ConnectionID
+
+	announce := func(id uint64, addr *net.UDPAddr) []byte {
+		b, _ := trackerudpclient.AnnounceRequest{
+			ConnectionID:  id,
+			Action:        trackerudpclient.ActionAnnounce,
+			TransactionID: 2,
+			InfoHash:      ih,
+			IP:            net.IPv4zero,
+			Left:          1,
+			Port:          6881,
+		}.Marshal()
+		return s.handle(b, addr)
+	}
+	expectError := func(b []byte, message string) {
+		t.Helper()
+		var resp trackerudpclient.ErrorResponse
+		if err := resp.Unmarshal(b); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Action != trackerudpclient.ActionError || resp.Message != message {
+			t.Fatalf("error: %+v", resp)
+		}
+	}
+
+	var announceResp trackerudpclient.AnnounceResponse
+	if err := announceResp.Unmarshal(announce(id, addr)); err != nil {
+		t.Fatal(err)
+	}
+	if announceResp.Action != trackerudpclient.ActionAnnounce || len(announceResp.Peers) != 0 || announceResp.Leechers != 1 {
+		t.Fatalf("announce: %+v", announceResp)
+	}
+
+	// Connection IDs are bound to the address.
+	expectError(announce(id, &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 1001}), `invalid connection id`)
+	expectError(announce(id+1, addr), `invalid connection id`)
+
+	b, _ = trackerudpclient.ScrapeRequest{
+		ConnectionID:  id,
+		Action:        trackerudpclient.ActionScrape,
+		TransactionID: 3,
+		InfoHashes:    []common.Hash{ih, {2}},
+	}.Marshal()
+	var scrapeResp trackerudpclient.ScrapeResponse
+	if err := scrapeResp.Unmarshal(s.handle(b, addr)); err != nil {
+		t.Fatal(err)
+	}
+	if scrapeResp.TransactionID != 3 || len(scrapeResp.Files) != 2 ||
+		scrapeResp.Files[0] != (trackerudpclient.ScrapeFile{Leechers: 1}) ||
+		scrapeResp.Files[1] != (trackerudpclient.ScrapeFile{}) {
+		t.Fatalf("scrape: %+v", scrapeResp)
+	}
+
+	b, _ = trackerudpclient.ConnectRequest{ProtocolID: id, Action: 9}.Marshal()
+	expectError(s.handle(b, addr), `unknown action`)
+
+	b, _ = trackerudpclient.ConnectRequest{ProtocolID: 1}.Marshal()
+	expectError(s.handle(b, addr), `invalid protocol id`)
+
+	if s.handle([]byte{1, 2, 3}, addr) != nil {
+		t.Fatal("short packets should be dropped")
+	}
+
+	// Connection IDs expire in two minutes.
+	now = now.Add(time.Minute)
+	if err := announceResp.Unmarshal(announce(id, addr)); err != nil || announceResp.Action != trackerudpclient.ActionAnnounce {
+		t.Fatalf("announce in the next minute: %+v, %v", announceResp, err)
+	}
+	now = now.Add(time.Minute)
+	expectError(announce(id, addr), `invalid connection id`)
+}
@@ -0,0 +1,94 @@
+package webseed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/movsb/torrent/pkg/torrent"
+)
+
+// ErrRangeNotSupported is returned by reads not at the start of files,
+// if the web seed doesn't support Range requests, and sends whole files,
+// which are too costly to download again and again for parts.
+var ErrRangeNotSupported = errors.New("webseed: range is not supported")
+
+// Client downloads the files of a torrent from a web seed (BEP 19),
+// which is an HTTP server that has the files.
+type Client struct {
+	URL  string
+	File *torrent.File
+
+	// The HTTP client, nil for http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// FileURL returns the URL of the file. The name of the torrent, and the
+// path of the file for multi-file torrents, are appended to the URL if it
+// ends with a slash, or if the torrent has multiple files.
+func (c *Client) FileURL(index int) string {
+	if c.File.Single && !strings.HasSuffix(c.URL, `/`) {
+		return c.URL
+	}
+
+	u := c.URL
+	if !strings.HasSuffix(u, `/`) {
+		u += `/`
+	}
+	u += url.PathEscape(c.File.Name)
+	if !c.File.Single {
+		for _, segment := range c.File.Files[index].Paths {
+			u += `/` + url.PathEscape(segment)
+		}
+	}
+	return u
+}
+
+// ReadAt reads len(p) bytes of the file at offset, by a Range request.
+// The Content-Range of the response must be the range requested.
+func (c *Client) ReadAt(ctx context.Context, index int, p []byte, offset int64) error {
+	if len(p) == 0 {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.FileURL(index), nil)
+	if err != nil {
+		return fmt.Errorf("webseed: %v", err)
+	}
+	req.Header.Set(`Range`, fmt.Sprintf(`bytes=%d-%d`, offset, offset+int64(len(p))-1))
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	rsp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webseed: %v", err)
+	}
+	defer rsp.Body.Close()
+
+	switch rsp.StatusCode {
+	case http.StatusPartialContent:
+		want := fmt.Sprintf(`bytes %d-%d/`, offset, offset+int64(len(p))-1)
+		if cr := rsp.Header.Get(`Content-Range`); !strings.HasPrefix(cr, want) {
+			return fmt.Errorf("webseed: %s: invalid content range: %q", req.URL, cr)
+		}
+	case http.StatusOK:
+		// Range is not supported, and the whole file is sent,
+		// whose start is all that can be read.
+		if offset > 0 {
+			return ErrRangeNotSupported
+		}
+	default:
+		return fmt.Errorf("webseed: %s: %s", req.URL, rsp.Status)
+	}
+
+	if _, err := io.ReadFull(rsp.Body, p); err != nil {
+		return fmt.Errorf("webseed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package webseed
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/movsb/torrent/pkg/torrent"
+)
+
+func TestFileURL(t *testing.T) {
+	single := &torrent.File{Name: `a b`, Single: true, Files: []torrent.Item{{Paths: []string{`a b`}}}}
+	multi := &torrent.File{Name: `dir`, Files: []torrent.Item{{Paths: []string{`sub`, `c#`}}}}
+
+	tests := []struct {
+		url  string
+		file *torrent.File
+		want string
+	}{
+		{`http://x/a`, single, `http://x/a`},
+		{`http://x/`, single, `http://x/a%20b`},
+		{`http://x/files`, multi, `http://x/files/dir/sub/c%23`},
+		{`http://x/files/`, multi, `http://x/files/dir/sub/c%23`},
+	}
+	for _, test := range tests {
+		c := Client{URL: test.url, File: test.file}
+		if got := c.FileURL(0); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.url, got, test.want)
+		}
+	}
+}
+
+func TestReadAt(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789`), 100)
+	noRange, badRange := false, false
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != `/f` {
+			http.NotFound(w, r)
+			return
+		}
+		if noRange {
+			w.Write(data)
+			return
+		}
+		if badRange {
+			w.Header().Set(`Content-Range`, fmt.Sprintf(`bytes 0-24/%d`, len(data)))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[:25])
+			return
+		}
+		http.ServeContent(w, r, `f`, time.Time{}, bytes.NewReader(data))
+	}))
+	defer s.Close()
+
+	c := Client{
+		URL:  s.URL + `/f`,
+		File: &torrent.File{Name: `f`, Single: true, Files: []torrent.Item{{Paths: []string{`f`}}}},
+	}
+
+	p := make([]byte, 25)
+	if err := c.ReadAt(context.Background(), 0, p, 95); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p, data[95:120]) {
+		t.Fatalf("got %s", p)
+	}
+
+	// Only the start of files are read without Range.
+	noRange = true
+	if err := c.ReadAt(context.Background(), 0, p, 95); err != ErrRangeNotSupported {
+		t.Fatalf("want ErrRangeNotSupported, got %v", err)
+	}
+	if err := c.ReadAt(context.Background(), 0, p, 0); err != nil || !bytes.Equal(p, data[:25]) {
+		t.Fatalf("read the start without range: %s, %v", p, err)
+	}
+	noRange = false
+
+	// Ranges other than that requested.
+	badRange = true
+	if err := c.ReadAt(context.Background(), 0, p, 95); err == nil || !strings.Contains(err.Error(), `content range`) {
+		t.Fatalf("bad range: %v", err)
+	}
+	badRange = false
+
+	c.URL = s.URL + `/missing`
+	if err := c.ReadAt(context.Background(), 0, make([]byte, 1), 0); err == nil {
+		t.Fatal("missing file should fail")
+	}
+}